@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"takedat/internal/auth"
+)
+
+const keysUsage = `Usage: takedat keys <command> [flags]
+
+Commands:
+  create -name NAME -scopes create,receive,admin
+  list
+  revoke ID
+
+Flags:
+  -file PATH   keystore file (default $API_KEYS_FILE)
+`
+
+// runKeys implements the keys subcommand for managing API keys and returns
+// the process exit code. keysFile is the configured keystore, which -file
+// overrides.
+func runKeys(keysFile string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	file := fs.String("file", keysFile, "keystore file")
+	name := fs.String("name", "", "key name")
+	scopes := fs.String("scopes", "", "comma-separated scopes")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "No keystore file: set API_KEYS_FILE or pass -file")
+		return 2
+	}
+	keys, err := auth.NewKeystore(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load API keys: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "create":
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "-name is required")
+			return 2
+		}
+		token, key, err := keys.Create(*name, splitScopes(*scopes))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create key: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Created key %s (%s) with scopes %s\n", key.ID, key.Name, strings.Join(key.Scopes, ","))
+		fmt.Println("Store this key now, it will not be shown again:")
+		fmt.Println(token)
+
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED")
+		for _, key := range keys.List() {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key.ID, key.Name, strings.Join(key.Scopes, ","), key.CreatedAt.Format(time.RFC3339))
+		}
+		w.Flush()
+
+	case "revoke":
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: takedat keys revoke ID")
+			return 2
+		}
+		if err := keys.Revoke(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke key: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Revoked key %s\n", fs.Arg(0))
+
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	return 0
+}
+
+func splitScopes(s string) []string {
+	var scopes []string
+	for _, scope := range strings.Split(s, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -10,19 +12,145 @@ import (
 	"time"
 
 	"takedat/internal/api"
+	"takedat/internal/auth"
 	"takedat/internal/config"
+	"takedat/internal/origin"
+	"takedat/internal/realip"
 	"takedat/internal/session"
+	"takedat/internal/turn"
 	"takedat/internal/websocket"
+
+	"github.com/quic-go/quic-go/http3"
 )
 
 func main() {
-	cfg := config.Load()
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		keysFile, err := config.LoadKeysFile()
+		if err != nil {
+			log.Fatalf("Invalid configuration:\n%v", err)
+		}
+		os.Exit(runKeys(keysFile, os.Args[2:]))
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// Initialize session manager
-	sessions := session.NewManager(cfg.SessionTTL)
+	codes, err := session.NewCodeGenerator(cfg.CodeFormat, cfg.CodeWords)
+	if err != nil {
+		log.Fatalf("Invalid code format: %v", err)
+	}
+
+	var reservations *session.Reservations
+	if cfg.VanityCodes {
+		reservations, err = session.NewReservations(cfg.ReservationsFile)
+		if err != nil {
+			log.Fatalf("Failed to load reservations: %v", err)
+		}
+	}
+
+	sessions := session.NewManager(session.Options{
+		TTL:             cfg.SessionTTL,
+		CleanupInterval: cfg.SessionCleanupInterval,
+		Codes:           codes,
+		Reservations:    reservations,
+		Quotas: session.Quotas{
+			MaxSessionsPerIP:   cfg.MaxSessionsPerIP,
+			MaxDailyBytesPerIP: cfg.MaxDailyBytesPerIP,
+		},
+	})
+
+	// Start embedded TURN relay
+	var turnServer *turn.Server
+	if cfg.TURNEnabled {
+		turnServer, err = turn.Start(turn.Config{
+			ListenAddr:    cfg.TURNListenAddr,
+			PublicIP:      cfg.TURNPublicIP,
+			Realm:         cfg.TURNRealm,
+			Secret:        cfg.TURNSecret,
+			CredentialTTL: cfg.TURNCredentialTTL,
+			MinPort:       uint16(cfg.TURNMinPort),
+			MaxPort:       uint16(cfg.TURNMaxPort),
+			AllowedPeers:  cfg.TURNAllowedPeers,
+		}, func(sessionID string) bool {
+			_, err := sessions.GetByID(sessionID)
+			return err == nil
+		})
+		if err != nil {
+			log.Fatalf("Failed to start TURN relay: %v", err)
+		}
+		defer turnServer.Close()
+	}
+
+	// Load API keys
+	var keys *auth.Keystore
+	if cfg.APIKeysFile != "" {
+		keys, err = auth.NewKeystore(cfg.APIKeysFile)
+		if err != nil {
+			log.Fatalf("Failed to load API keys: %v", err)
+		}
+	}
+
+	// Load the OIDC provider's signing keys
+	var verifier *auth.Verifier
+	if cfg.OIDCJWKSFile != "" || cfg.OIDCJWKSURL != "" {
+		verifier, err = auth.NewVerifier(auth.OIDCConfig{
+			JWKSFile: cfg.OIDCJWKSFile,
+			JWKSURL:  cfg.OIDCJWKSURL,
+			Issuer:   cfg.OIDCIssuer,
+			Audience: cfg.OIDCAudience,
+		})
+		if err != nil {
+			log.Fatalf("Failed to load OIDC keys: %v", err)
+		}
+	}
+
+	origins, err := origin.New(cfg.AllowedOrigins)
+	if err != nil {
+		log.Fatalf("Invalid allowed origins: %v", err)
+	}
+	proxies, err := realip.New(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
 
 	// Initialize WebSocket hub
-	hub := websocket.NewHub(sessions)
+	hub := websocket.NewHub(sessions, websocket.Options{
+		EnableCompression: cfg.WSCompression,
+		Compressions:      cfg.ChunkCompressions,
+		MaxStreams:        cfg.MaxStreams,
+		RateLimits: websocket.RateLimits{
+			Global:  cfg.RateLimitGlobal,
+			Session: cfg.RateLimitSession,
+			IP:      cfg.RateLimitIP,
+		},
+		MaxPairs:            cfg.MaxConcurrentPairs,
+		RequireSenderAuth:   cfg.RequireAPIKey || cfg.RequireSenderAuth,
+		RequireReceiverAuth: cfg.RequireAPIKey,
+		Origins:             origins,
+		WriteWait:           cfg.WSWriteWait,
+		PongWait:            cfg.WSPongWait,
+		PingPeriod:          cfg.WSPingPeriod,
+		RegisterWait:        cfg.WSRegisterWait,
+		MaxMessageSize:      cfg.WSMaxMessageSize,
+		ReadBufferSize:      cfg.WSReadBufferSize,
+		WriteBufferSize:     cfg.WSWriteBufferSize,
+		SendQueue:           cfg.WSSendQueue,
+		ReplayBuffer:        cfg.WSReplayBuffer,
+		ReplayGrace:         cfg.WSReplayGrace,
+	})
 	go hub.Run()
 
 	// Start session cleanup
@@ -30,30 +158,104 @@ func main() {
 	go sessions.StartCleanup(ctx)
 
 	// Create router
-	router := api.NewRouter(cfg, sessions, hub)
+	router := api.NewRouter(api.RouterConfig{
+		Config:       cfg,
+		Sessions:     sessions,
+		Reservations: reservations,
+		TURN:         turnServer,
+		Hub:          hub,
+		Keys:         keys,
+		Verifier:     verifier,
+		Origins:      origins,
+		Proxies:      proxies,
+	})
+
+	// Set up TLS
+	tlsConfig, plainHTTP, err := serverTLS(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up TLS: %v", err)
+	}
 
 	// Create server
 	addr := cfg.Host + ":" + cfg.Port
 	server := &http.Server{
 		Addr:         addr,
 		Handler:      router,
+		TLSConfig:    tlsConfig,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Add HTTP/3 and HTTP/2
+	var h3 *http3.Server
+	if cfg.HTTP3Addr != "" {
+		h3, server.Handler = newHTTP3(server, cfg.HTTP3Addr)
+		go func() {
+			log.Printf("HTTP/3 starting on %s", cfg.HTTP3Addr)
+			if err := h3.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("HTTP/3 server error: %v", err)
+			}
+		}()
+	}
+	if err := configureHTTP2(server, cfg); err != nil {
+		log.Fatalf("Failed to set up HTTP/2: %v", err)
+	}
+
 	// Start server in goroutine
 	go func() {
-		log.Printf("Server starting on %s", addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			log.Printf("Server starting on %s (HTTPS)", addr)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("Server starting on %s", addr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
 
-	// Wait for interrupt signal
+	// Redirect plain HTTP to HTTPS
+	var redirect *http.Server
+	if cfg.HTTPRedirectAddr != "" {
+		redirect = &http.Server{
+			Addr:         cfg.HTTPRedirectAddr,
+			Handler:      plainHTTP,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+		}
+		go func() {
+			log.Printf("Redirecting HTTP on %s to HTTPS", cfg.HTTPRedirectAddr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Redirect server error: %v", err)
+			}
+		}()
+	}
+
+	// Reload the config on SIGHUP until an interrupt signal arrives
+	reloads := &reloader{
+		args:     os.Args[1:],
+		cfg:      cfg,
+		origins:  origins,
+		sessions: sessions,
+		hub:      hub,
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+wait:
+	for {
+		select {
+		case <-hup:
+			log.Println("Reloading config...")
+			reloads.reload()
+		case <-quit:
+			break wait
+		}
+	}
 
 	log.Println("Shutting down server...")
 	cancel()
@@ -62,6 +264,12 @@ func main() {
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
+	if redirect != nil {
+		redirect.Shutdown(shutdownCtx)
+	}
+	if h3 != nil {
+		h3.Close()
+	}
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Fatalf("Server shutdown error: %v", err)
 	}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"slices"
+
+	"takedat/internal/config"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// configureHTTP2 turns HTTP/2 on or off for server. It uses x/net/http2
+// rather than the copy bundled with net/http because only the former
+// accepts the extended CONNECT requests (RFC 8441) that carry WebSockets
+// over HTTP/2. The TLS config is edited on a copy so that other listeners
+// sharing it keep their protocols.
+func configureHTTP2(server *http.Server, cfg *config.Config) error {
+	h2 := &http2.Server{IdleTimeout: server.IdleTimeout}
+	if server.TLSConfig != nil {
+		server.TLSConfig = server.TLSConfig.Clone()
+	}
+
+	switch {
+	case server.TLSConfig != nil && cfg.HTTP2:
+		return http2.ConfigureServer(server, h2)
+
+	case server.TLSConfig != nil:
+		// A non-nil empty map keeps net/http from adding HTTP/2.
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		server.TLSConfig.NextProtos = slices.DeleteFunc(server.TLSConfig.NextProtos, func(p string) bool {
+			return p == http2.NextProtoTLS
+		})
+
+	case cfg.H2C:
+		server.Handler = h2c.NewHandler(server.Handler, h2)
+	}
+	return nil
+}
+
+// newHTTP3 returns an HTTP/3 server for server's handler, and a handler
+// for the TCP listener that advertises it with Alt-Svc. WebSockets are not
+// carried over HTTP/3, so browsers keep opening them over TCP. The HTTP/3
+// server gets its own copy of the TLS config since each listener negotiates
+// different protocols.
+func newHTTP3(server *http.Server, addr string) (*http3.Server, http.Handler) {
+	h3 := &http3.Server{
+		Addr:      addr,
+		Handler:   server.Handler,
+		TLSConfig: server.TLSConfig.Clone(),
+		// 0-RTT requests can be replayed; keep them off.
+		QuicConfig: &quic.Config{},
+	}
+
+	next := server.Handler
+	advertise := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3.SetQuicHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+	return h3, advertise
+}
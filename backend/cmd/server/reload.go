@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+
+	"takedat/internal/config"
+	"takedat/internal/origin"
+	"takedat/internal/session"
+	"takedat/internal/websocket"
+)
+
+// reloader re-reads the configuration on SIGHUP and applies the settings
+// that can change without a restart. Connections and transfers in
+// progress are kept.
+type reloader struct {
+	args     []string // command-line args, re-applied on every reload
+	cfg      *config.Config
+	origins  *origin.Matcher
+	sessions *session.Manager
+	hub      *websocket.Hub
+}
+
+// reload loads the configuration again. An invalid configuration is
+// rejected as a whole and the running one stays in effect.
+func (r *reloader) reload() {
+	next, err := config.Load(r.args)
+	if err != nil {
+		log.Printf("Config reload rejected:\n%v", err)
+		return
+	}
+
+	cfg, changes := config.Reloaded(r.cfg, next)
+	if len(changes) == 0 {
+		log.Println("Config reloaded, nothing changed")
+		return
+	}
+
+	if err := r.origins.Update(cfg.AllowedOrigins); err != nil {
+		log.Printf("Config reload rejected: allowed_origins: %v", err)
+		return
+	}
+	r.sessions.SetLimits(cfg.SessionTTL, session.Quotas{
+		MaxSessionsPerIP:   cfg.MaxSessionsPerIP,
+		MaxDailyBytesPerIP: cfg.MaxDailyBytesPerIP,
+	})
+	r.hub.SetLimits(websocket.RateLimits{
+		Global:  cfg.RateLimitGlobal,
+		Session: cfg.RateLimitSession,
+		IP:      cfg.RateLimitIP,
+	}, cfg.MaxConcurrentPairs)
+	r.cfg = cfg
+
+	for _, c := range changes {
+		if c.Reloadable {
+			log.Printf("Config %s: %s -> %s", c.Key, c.Old, c.New)
+		} else {
+			log.Printf("Config %s: %s -> %s needs a restart, still using %s", c.Key, c.Old, c.New, c.Old)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"takedat/internal/config"
+	"takedat/internal/origin"
+	"takedat/internal/session"
+	"takedat/internal/websocket"
+)
+
+// newTestReloader builds the reloadable parts of the server from the
+// config file at path, the way main does.
+func newTestReloader(t *testing.T, path string) *reloader {
+	t.Helper()
+
+	args := []string{"--config", path}
+	cfg, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins, err := origin.New(cfg.AllowedOrigins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.NewManager(session.Options{
+		TTL: cfg.SessionTTL,
+		Quotas: session.Quotas{
+			MaxSessionsPerIP:   cfg.MaxSessionsPerIP,
+			MaxDailyBytesPerIP: cfg.MaxDailyBytesPerIP,
+		},
+	})
+	hub := websocket.NewHub(sessions, websocket.Options{
+		Origins: origins,
+		RateLimits: websocket.RateLimits{
+			Global:  cfg.RateLimitGlobal,
+			Session: cfg.RateLimitSession,
+			IP:      cfg.RateLimitIP,
+		},
+		MaxPairs: cfg.MaxConcurrentPairs,
+	})
+	return &reloader{args: args, cfg: cfg, origins: origins, sessions: sessions, hub: hub}
+}
+
+// captureLog collects what the standard logger prints during the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func writeConfig(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkLimits verifies the settings in effect in the reloader's
+// components, creating sessions from ip to probe the session manager.
+func checkLimits(t *testing.T, r *reloader, ip, allowed, refused string, ttl time.Duration, perIP int, dailyBytes int64, limits websocket.RateLimits, pairs int) {
+	t.Helper()
+
+	if !r.origins.Allowed(allowed) || r.origins.Allowed(refused) {
+		t.Errorf("origins: %s allowed %v, %s allowed %v", allowed, r.origins.Allowed(allowed), refused, r.origins.Allowed(refused))
+	}
+
+	for i := 0; i < perIP; i++ {
+		sess, err := r.sessions.Create(session.CreateParams{FileName: "a.txt", OwnerIP: ip})
+		if err != nil {
+			t.Fatalf("session %d of %d: %v", i+1, perIP, err)
+		}
+		if got := sess.ExpiresAt.Sub(sess.CreatedAt); got != ttl {
+			t.Errorf("session TTL = %s, want %s", got, ttl)
+		}
+	}
+	if _, err := r.sessions.Create(session.CreateParams{FileName: "a.txt", OwnerIP: ip}); !errors.Is(err, session.ErrQuotaExceeded) {
+		t.Errorf("session %d from one IP: err = %v, want %v", perIP+1, err, session.ErrQuotaExceeded)
+	}
+
+	if err := r.sessions.ChargeRelay(ip, dailyBytes); err != nil {
+		t.Errorf("relaying %d bytes: %v", dailyBytes, err)
+	}
+	if err := r.sessions.ChargeRelay(ip, 1); !errors.Is(err, session.ErrQuotaExceeded) {
+		t.Errorf("relaying past %d bytes: err = %v, want %v", dailyBytes, err, session.ErrQuotaExceeded)
+	}
+
+	gotLimits, gotPairs := r.hub.Limits()
+	if gotLimits != limits || gotPairs != pairs {
+		t.Errorf("hub limits = %+v, %d pairs, want %+v, %d pairs", gotLimits, gotPairs, limits, pairs)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "takedat.yaml")
+	writeConfig(t, path, `
+port: "9000"
+max_streams: 2
+allowed_origins: ["https://a.example"]
+session_ttl: 5m
+rate_limit_global: 1000
+rate_limit_session: 500
+rate_limit_ip: 200
+max_sessions_per_ip: 1
+max_daily_bytes_per_ip: 1000
+max_concurrent_pairs: 3
+`)
+	r := newTestReloader(t, path)
+	checkLimits(t, r, "192.0.2.1", "https://a.example", "https://b.example", 5*time.Minute, 1, 1000,
+		websocket.RateLimits{Global: 1000, Session: 500, IP: 200}, 3)
+
+	writeConfig(t, path, `
+port: "9100"
+max_streams: 3
+allowed_origins: ["https://b.example"]
+session_ttl: 7m
+rate_limit_global: 2000
+rate_limit_session: 0
+rate_limit_ip: 300
+max_sessions_per_ip: 2
+max_daily_bytes_per_ip: 2000
+max_concurrent_pairs: 4
+`)
+	logs := captureLog(t)
+	r.reload()
+
+	checkLimits(t, r, "192.0.2.2", "https://b.example", "https://a.example", 7*time.Minute, 2, 2000,
+		websocket.RateLimits{Global: 2000, Session: 0, IP: 300}, 4)
+	if r.cfg.SessionTTL != 7*time.Minute || r.cfg.MaxConcurrentPairs != 4 {
+		t.Errorf("reloadable settings not kept: ttl %s, pairs %d", r.cfg.SessionTTL, r.cfg.MaxConcurrentPairs)
+	}
+
+	// Settings that need a restart are reported and keep their values.
+	if r.cfg.Port != "9000" || r.cfg.MaxStreams != 2 {
+		t.Errorf("restart-only settings applied: port %s, max_streams %d", r.cfg.Port, r.cfg.MaxStreams)
+	}
+	for _, want := range []string{
+		`Config port: "9000" -> "9100" needs a restart`,
+		"Config max_streams: 2 -> 3 needs a restart",
+		`Config session_ttl: "5m0s" -> "7m0s"` + "\n",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log does not contain %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "takedat.yaml")
+	writeConfig(t, path, `
+allowed_origins: ["https://a.example"]
+session_ttl: 5m
+rate_limit_global: 1000
+max_sessions_per_ip: 1
+max_daily_bytes_per_ip: 1000
+max_concurrent_pairs: 3
+`)
+	r := newTestReloader(t, path)
+	cfg := r.cfg
+
+	// Valid reloadable changes alongside an invalid setting are all
+	// rejected.
+	writeConfig(t, path, `
+allowed_origins: ["https://b.example"]
+session_ttl: 7m
+rate_limit_global: 2000
+max_sessions_per_ip: 2
+max_daily_bytes_per_ip: 2000
+max_concurrent_pairs: 4
+code_format: emoji
+`)
+	logs := captureLog(t)
+	r.reload()
+
+	if !strings.Contains(logs.String(), "Config reload rejected") {
+		t.Errorf("rejection not logged:\n%s", logs)
+	}
+	if r.cfg != cfg {
+		t.Error("running config replaced")
+	}
+	checkLimits(t, r, "192.0.2.1", "https://a.example", "https://b.example", 5*time.Minute, 1, 1000,
+		websocket.RateLimits{Global: 1000}, 3)
+}
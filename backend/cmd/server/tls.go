@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"takedat/internal/certs"
+	"takedat/internal/config"
+)
+
+// serverTLS returns the TLS configuration for cfg, or nil when HTTPS is
+// off, and the handler for plain HTTP requests. That redirects the domains
+// we hold certificates for to HTTPS and, with ACME, answers HTTP-01
+// challenges.
+func serverTLS(cfg *config.Config) (*tls.Config, http.Handler, error) {
+	switch {
+	case cfg.TLSCertFile != "":
+		files, err := certs.NewFileSource(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return files.TLSConfig(), certs.RedirectHandler(cfg.Port, files.Names()), nil
+
+	case len(cfg.ACMEDomains) > 0:
+		acme, err := certs.NewACME(certs.ACMEConfig{
+			Domains:      cfg.ACMEDomains,
+			Email:        cfg.ACMEEmail,
+			DirectoryURL: cfg.ACMEDirectoryURL,
+			CAFile:       cfg.ACMECAFile,
+			CacheDir:     cfg.ACMECacheDir,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return acme.TLSConfig(), acme.HTTPHandler(certs.RedirectHandler(cfg.Port, acme.Names())), nil
+	}
+	return nil, nil, nil
+}
@@ -0,0 +1,188 @@
+// Command takedat-bench measures how many concurrent transfers a server can
+// relay. It opens sender/receiver pairs that speak the real WebSocket
+// protocol, pushes a file through each and reports throughput, latency
+// percentiles, dropped chunks and memory use.
+//
+// Without -server it benchmarks a server started in the same process.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: takedat-bench [flags]
+
+Runs sender/receiver pairs against a server and reports how it coped.
+
+Flags:
+`
+
+// options are the command-line settings.
+type options struct {
+	server   string // base URL; empty starts an in-process server
+	pairs    int
+	size     byteSize
+	chunk    byteSize
+	window   int // chunks in flight per pair
+	ramp     time.Duration
+	stall    time.Duration
+	timeout  time.Duration
+	token    string
+	insecure bool
+	verbose  bool
+}
+
+func main() {
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	os.Exit(run(opts))
+}
+
+func parseFlags(args []string) (*options, error) {
+	opts := &options{
+		pairs:   10,
+		size:    10 << 20,
+		chunk:   64 << 10,
+		window:  8,
+		stall:   10 * time.Second,
+		timeout: 5 * time.Minute,
+	}
+
+	fs := flag.NewFlagSet("takedat-bench", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.server, "server", "", "server base URL, e.g. https://takedat.example.com (default: in-process server)")
+	fs.IntVar(&opts.pairs, "pairs", opts.pairs, "concurrent sender/receiver pairs")
+	fs.Var(&opts.size, "size", "file size per pair; K, M and G are powers of 1024")
+	fs.Var(&opts.chunk, "chunk", "chunk size")
+	fs.IntVar(&opts.window, "window", opts.window, "unacknowledged chunks each sender may have in flight")
+	fs.DurationVar(&opts.ramp, "ramp", 0, "spread the pairs' start over this long")
+	fs.DurationVar(&opts.stall, "stall", opts.stall, "give up on a pair after this long without progress")
+	fs.DurationVar(&opts.timeout, "timeout", opts.timeout, "give up on the whole run after this long")
+	fs.StringVar(&opts.token, "token", "", "API key or user token sent as a bearer token")
+	fs.BoolVar(&opts.insecure, "insecure", false, "skip TLS certificate verification")
+	fs.BoolVar(&opts.verbose, "v", false, "show the in-process server's log")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	switch {
+	case opts.pairs < 1:
+		return nil, errors.New("-pairs must be at least 1")
+	case opts.size < 1:
+		return nil, errors.New("-size must be positive")
+	case opts.chunk < 1:
+		return nil, errors.New("-chunk must be positive")
+	case opts.window < 1:
+		return nil, errors.New("-window must be at least 1")
+	case opts.stall <= 0 || opts.timeout <= 0:
+		return nil, errors.New("-stall and -timeout must be positive")
+	}
+	opts.server = strings.TrimSuffix(opts.server, "/")
+	return opts, nil
+}
+
+// run benchmarks the server and returns the exit code: 1 if any pair
+// failed.
+func run(opts *options) int {
+	if !opts.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	target := opts.server
+	if target == "" {
+		srv, err := startServer(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to start server: %v\n", err)
+			return 1
+		}
+		defer srv.Close()
+		target = srv.URL
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, opts.timeout)
+	defer cancelTimeout()
+
+	b, err := newBench(opts, target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	report := b.run(ctx)
+	report.print(os.Stdout)
+	if report.failed() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// byteSize is a flag value in bytes that accepts K, M and G suffixes,
+// optionally followed by B or iB.
+type byteSize int64
+
+func (s *byteSize) String() string {
+	return formatBytes(float64(*s))
+}
+
+func (s *byteSize) Set(v string) error {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+
+	shift := 0
+	switch {
+	case strings.HasSuffix(v, "K"):
+		shift = 10
+	case strings.HasSuffix(v, "M"):
+		shift = 20
+	case strings.HasSuffix(v, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		v = v[:len(v)-1]
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 || n > (1<<62)>>shift {
+		return errors.New("invalid size")
+	}
+	*s = byteSize(n << shift)
+	return nil
+}
+
+// formatBytes renders n with a binary unit.
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"takedat/internal/api"
+	"takedat/internal/websocket"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// bench runs the pairs against one server.
+type bench struct {
+	opts   *options
+	target string // HTTP base URL
+	wsURL  string // WebSocket base URL
+	http   *http.Client
+	dialer *gorilla.Dialer
+	// chunk and last are the encoded data of a full chunk and of the
+	// final, possibly shorter one. Every pair sends the same random bytes.
+	chunk, last string
+	lastSize    int
+	chunks      int
+}
+
+func newBench(opts *options, target string) (*bench, error) {
+	wsURL, ok := strings.CutPrefix(target, "http")
+	if !ok {
+		return nil, fmt.Errorf("server URL %q must start with http:// or https://", target)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.insecure}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConnsPerHost = opts.pairs
+
+	data := make([]byte, opts.chunk)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	chunks := int((opts.size + opts.chunk - 1) / opts.chunk)
+	lastSize := int(opts.size) - (chunks-1)*int(opts.chunk)
+
+	return &bench{
+		opts:   opts,
+		target: target,
+		wsURL:  "ws" + wsURL,
+		http:   &http.Client{Transport: transport, Timeout: opts.stall},
+		dialer: &gorilla.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: opts.stall,
+			TLSClientConfig:  tlsConfig,
+		},
+		chunk:    base64.StdEncoding.EncodeToString(data),
+		last:     base64.StdEncoding.EncodeToString(data[:lastSize]),
+		lastSize: lastSize,
+		chunks:   chunks,
+	}, nil
+}
+
+// run starts every pair, spread over the ramp, and collects their results.
+func (b *bench) run(ctx context.Context) *report {
+	sampler := startSampler()
+	start := time.Now()
+
+	results := make([]pairResult, b.opts.pairs)
+	var wg sync.WaitGroup
+	for i := range results {
+		delay := time.Duration(0)
+		if b.opts.pairs > 1 {
+			delay = b.opts.ramp * time.Duration(i) / time.Duration(b.opts.pairs-1)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			select {
+			case <-time.After(delay):
+				results[i] = b.runPair(ctx, i)
+			case <-ctx.Done():
+				results[i] = pairResult{err: ctx.Err()}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return newReport(b, results, time.Since(start), sampler.stop())
+}
+
+// pairResult is what one sender/receiver pair measured.
+type pairResult struct {
+	setup     time.Duration // creating the session until both peers are ready
+	transfer  time.Duration // file_meta until transfer_complete arrived
+	sent      int           // chunks the sender sent
+	received  int           // chunks the receiver got
+	bytes     int64         // payload bytes the receiver got
+	latencies []time.Duration
+	err       error
+}
+
+// runPair creates a session, connects a sender and a receiver to it and
+// transfers one file.
+func (b *bench) runPair(ctx context.Context, id int) (res pairResult) {
+	start := time.Now()
+
+	created, err := b.createSession(ctx, id)
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	sender, err := b.join(ctx, created, "sender")
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer sender.close()
+	receiver, err := b.join(ctx, created, "receiver")
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer receiver.close()
+
+	// Close both sockets if the run is cancelled, unblocking any read.
+	stop := context.AfterFunc(ctx, func() {
+		sender.close()
+		receiver.close()
+	})
+	defer stop()
+
+	steps := []func() error{
+		func() error { return sender.expect(websocket.TypePeerJoined, nil) },
+		func() error { return receiver.send(websocket.TypeTransferRequest, websocket.TransferRequestPayload{}) },
+		func() error { return sender.expect(websocket.TypeTransferRequest, nil) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			res.err = err
+			return res
+		}
+	}
+	res.setup = time.Since(start)
+
+	transferStart := time.Now()
+	meta := websocket.FileMetaPayload{
+		FileName:    fmt.Sprintf("bench-%d.bin", id),
+		FileSize:    int64(b.opts.size),
+		MimeType:    "application/octet-stream",
+		TotalChunks: b.chunks,
+		ChunkSize:   int(b.opts.chunk),
+	}
+	steps = []func() error{
+		func() error { return sender.send(websocket.TypeFileMeta, meta) },
+		func() error { return receiver.expect(websocket.TypeFileMeta, nil) },
+		func() error { return sender.expect(websocket.TypeFileMetaAck, nil) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			res.err = err
+			return res
+		}
+	}
+
+	sentAt := make([]atomic.Int64, b.chunks)
+	var recv receiveResult
+	done := make(chan struct{})
+	go func() {
+		recv = b.receive(receiver, sentAt)
+		close(done)
+	}()
+
+	res.sent, err = b.send(sender, sentAt)
+	if err != nil {
+		// The receiver will not see transfer_complete; stop waiting.
+		receiver.close()
+	}
+	<-done
+
+	res.transfer = time.Since(transferStart)
+	res.received = recv.chunks
+	res.bytes = recv.bytes
+	res.latencies = recv.latencies
+	res.err = errors.Join(err, recv.err)
+	if ctx.Err() != nil {
+		res.err = ctx.Err()
+	}
+	return res
+}
+
+// createSession creates a session over the REST API.
+func (b *bench) createSession(ctx context.Context, id int) (*api.CreateSessionResponse, error) {
+	body, _ := json.Marshal(api.CreateSessionRequest{
+		FileName: fmt.Sprintf("bench-%d.bin", id),
+		FileSize: int64(b.opts.size),
+		MimeType: "application/octet-stream",
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.target+"/api/sessions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	b.authorize(req.Header)
+
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		var apiErr api.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return nil, fmt.Errorf("create session: %s %s", resp.Status, apiErr.Code)
+	}
+	var created api.CreateSessionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	return &created, nil
+}
+
+func (b *bench) authorize(h http.Header) {
+	if b.opts.token != "" {
+		h.Set("Authorization", "Bearer "+b.opts.token)
+	}
+}
+
+// join connects to the session as role and completes the register
+// handshake.
+func (b *bench) join(ctx context.Context, created *api.CreateSessionResponse, role string) (*peer, error) {
+	header := http.Header{}
+	b.authorize(header)
+
+	conn, _, err := b.dialer.DialContext(ctx, b.wsURL+"/ws/"+created.Code, header)
+	if err != nil {
+		return nil, fmt.Errorf("%s: connect: %w", role, err)
+	}
+	p := &peer{conn: conn, role: role, stall: b.opts.stall}
+
+	err = p.send(websocket.TypeRegister, websocket.RegisterPayload{
+		Role:      role,
+		SessionID: created.SessionID,
+		Version:   websocket.ProtocolVersion,
+	})
+	if err == nil {
+		err = p.expect(websocket.TypeRegisterAck, nil)
+	}
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// send pushes the file's chunks, keeping up to the window in flight, then
+// announces the end of the transfer. It returns the number of chunks sent.
+func (b *bench) send(p *peer, sentAt []atomic.Int64) (int, error) {
+	acks := make(chan error)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for {
+			var ack websocket.ChunkAckPayload
+			err := p.expect(websocket.TypeChunkAck, &ack)
+			if err == nil && !ack.Success {
+				err = fmt.Errorf("sender: chunk %d not acknowledged", ack.Index)
+			}
+			select {
+			case acks <- err:
+			case <-quit:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	sent, inFlight := 0, 0
+	for sent < b.chunks || inFlight > 0 {
+		for inFlight < b.opts.window && sent < b.chunks {
+			data, size := b.chunk, int(b.opts.chunk)
+			if sent == b.chunks-1 {
+				data, size = b.last, b.lastSize
+			}
+			sentAt[sent].Store(time.Now().UnixNano())
+			err := p.send(websocket.TypeChunk, websocket.ChunkPayload{
+				Index: sent,
+				Data:  data,
+				Size:  size,
+			})
+			if err != nil {
+				return sent, err
+			}
+			sent++
+			inFlight++
+		}
+
+		if err := <-acks; err != nil {
+			return sent, err
+		}
+		inFlight--
+	}
+
+	err := p.send(websocket.TypeTransferComplete, websocket.TransferCompletePayload{
+		TotalBytes:  int64(b.opts.size),
+		TotalChunks: b.chunks,
+	})
+	return sent, err
+}
+
+type receiveResult struct {
+	chunks    int
+	bytes     int64
+	latencies []time.Duration
+	err       error
+}
+
+// receive acknowledges chunks until transfer_complete arrives, timing how
+// long each chunk took from the sender.
+func (b *bench) receive(p *peer, sentAt []atomic.Int64) (res receiveResult) {
+	res.latencies = make([]time.Duration, 0, b.chunks)
+	for {
+		msg, err := p.read()
+		if err != nil {
+			res.err = err
+			return res
+		}
+
+		switch msg.Type {
+		case websocket.TypeChunk:
+			var chunk websocket.ChunkPayload
+			if err := json.Unmarshal(msg.Payload, &chunk); err != nil || chunk.Index < 0 || chunk.Index >= b.chunks {
+				res.err = fmt.Errorf("receiver: invalid chunk")
+				return res
+			}
+			res.latencies = append(res.latencies, time.Duration(time.Now().UnixNano()-sentAt[chunk.Index].Load()))
+			res.chunks++
+			res.bytes += int64(chunk.Size)
+
+			if err := p.send(websocket.TypeChunkAck, websocket.ChunkAckPayload{Index: chunk.Index, Success: true}); err != nil {
+				res.err = err
+				return res
+			}
+
+		case websocket.TypeTransferComplete:
+			return res
+
+		case websocket.TypeError, websocket.TypePeerLeft, websocket.TypeSessionExpired:
+			res.err = fmt.Errorf("receiver: got %s %s", msg.Type, msg.Payload)
+			return res
+		}
+	}
+}
+
+// peer is one WebSocket connection of a pair.
+type peer struct {
+	conn      *gorilla.Conn
+	role      string
+	stall     time.Duration
+	closeOnce sync.Once
+}
+
+func (p *peer) send(msgType websocket.MessageType, payload any) error {
+	msg, err := websocket.NewMessage(msgType, payload)
+	if err != nil {
+		return err
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(p.stall))
+	if err := p.conn.WriteMessage(gorilla.TextMessage, data); err != nil {
+		return fmt.Errorf("%s: send %s: %w", p.role, msgType, err)
+	}
+	return nil
+}
+
+// read returns the next message, failing if none arrives before the stall
+// timeout.
+func (p *peer) read() (*websocket.Message, error) {
+	p.conn.SetReadDeadline(time.Now().Add(p.stall))
+	_, data, err := p.conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("%s: read: %w", p.role, err)
+	}
+
+	var msg websocket.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("%s: invalid message: %w", p.role, err)
+	}
+	return &msg, nil
+}
+
+// expect reads the next message and checks its type, decoding its payload
+// into payload if given. Error messages from the server fail the
+// expectation.
+func (p *peer) expect(msgType websocket.MessageType, payload any) error {
+	msg, err := p.read()
+	if err != nil {
+		return err
+	}
+	if msg.Type == websocket.TypeError {
+		var e websocket.ErrorPayload
+		json.Unmarshal(msg.Payload, &e)
+		return fmt.Errorf("%s: server error %s: %s", p.role, e.Code, e.Message)
+	}
+	if msg.Type != msgType {
+		return fmt.Errorf("%s: got %s, want %s", p.role, msg.Type, msgType)
+	}
+	if payload != nil {
+		if err := json.Unmarshal(msg.Payload, payload); err != nil {
+			return fmt.Errorf("%s: invalid %s: %w", p.role, msgType, err)
+		}
+	}
+	return nil
+}
+
+func (p *peer) close() {
+	p.closeOnce.Do(func() {
+		p.conn.Close()
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// report summarizes a run.
+type report struct {
+	opts     *options
+	target   string
+	chunks   int
+	elapsed  time.Duration
+	results  []pairResult
+	memory   memoryStats
+	setup    []time.Duration
+	transfer []time.Duration
+	latency  []time.Duration
+	bytes    int64
+	dropped  int // chunks sent but never received
+	errors   []error
+}
+
+func newReport(b *bench, results []pairResult, elapsed time.Duration, memory memoryStats) *report {
+	r := &report{
+		opts:    b.opts,
+		target:  b.target,
+		chunks:  b.chunks,
+		elapsed: elapsed,
+		results: results,
+		memory:  memory,
+	}
+	for _, res := range results {
+		r.bytes += res.bytes
+		r.dropped += res.sent - res.received
+		r.latency = append(r.latency, res.latencies...)
+		if res.err != nil {
+			r.errors = append(r.errors, res.err)
+			continue
+		}
+		r.setup = append(r.setup, res.setup)
+		r.transfer = append(r.transfer, res.transfer)
+	}
+	return r
+}
+
+func (r *report) failed() int {
+	return len(r.errors)
+}
+
+// maxErrors bounds how many pair failures are listed.
+const maxErrors = 5
+
+func (r *report) print(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	server := r.target
+	if r.opts.server == "" {
+		server += " (in-process)"
+	}
+	fmt.Fprintf(w, "Server\t%s\n", server)
+	fmt.Fprintf(w, "Pairs\t%d (%d completed, %d failed)\n", len(r.results), len(r.results)-r.failed(), r.failed())
+	fmt.Fprintf(w, "File\t%s in %d chunks of %s, window %d\n",
+		formatBytes(float64(r.opts.size)), r.chunks, formatBytes(float64(r.opts.chunk)), r.opts.window)
+	fmt.Fprintf(w, "Elapsed\t%s\n", r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Relayed\t%s\n", formatBytes(float64(r.bytes)))
+	fmt.Fprintf(w, "Throughput\t%s/s total\n", formatBytes(float64(r.bytes)/r.elapsed.Seconds()))
+	if len(r.transfer) > 0 {
+		perPair := make([]time.Duration, len(r.transfer))
+		copy(perPair, r.transfer)
+		slices.Sort(perPair)
+		median := percentile(perPair, 50)
+		fmt.Fprintf(w, "\t%s/s per pair (median)\n", formatBytes(float64(r.opts.size)/median.Seconds()))
+	}
+	fmt.Fprintf(w, "Setup\t%s\n", formatPercentiles(r.setup))
+	fmt.Fprintf(w, "Transfer\t%s\n", formatPercentiles(r.transfer))
+	fmt.Fprintf(w, "Chunk latency\t%s\n", formatPercentiles(r.latency))
+	fmt.Fprintf(w, "Dropped\t%d of %d chunks\n", r.dropped, len(r.results)*r.chunks)
+
+	m := r.memory
+	label := "Memory"
+	if r.opts.server == "" {
+		label = "Memory (incl. server)"
+	}
+	fmt.Fprintf(w, "%s\tpeak heap %s, peak sys %s\n", label, formatBytes(float64(m.peakHeap)), formatBytes(float64(m.peakSys)))
+	fmt.Fprintf(w, "\t%s allocated, %d GCs, peak %d goroutines\n", formatBytes(float64(m.allocated)), m.gcs, m.peakGoroutines)
+	w.Flush()
+
+	if len(r.errors) > 0 {
+		fmt.Fprintln(out, "\nFailures:")
+		for i, err := range r.errors {
+			if i == maxErrors {
+				fmt.Fprintf(out, "  ... and %d more\n", len(r.errors)-maxErrors)
+				break
+			}
+			fmt.Fprintf(out, "  %v\n", err)
+		}
+	}
+}
+
+// formatPercentiles sorts d and renders its percentiles.
+func formatPercentiles(d []time.Duration) string {
+	if len(d) == 0 {
+		return "-"
+	}
+	slices.Sort(d)
+	return fmt.Sprintf("p50 %s  p90 %s  p99 %s  max %s",
+		round(percentile(d, 50)), round(percentile(d, 90)), round(percentile(d, 99)), round(d[len(d)-1]))
+}
+
+// percentile returns the pth percentile of sorted by the nearest-rank
+// method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// round trims a duration to three significant digits or so.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+// memoryStats is the process's memory use over a run.
+type memoryStats struct {
+	peakHeap       uint64 // bytes of heap in use
+	peakSys        uint64 // bytes obtained from the OS
+	allocated      uint64 // bytes allocated during the run
+	gcs            uint32
+	peakGoroutines int
+}
+
+// sampler polls the runtime's memory statistics until stopped.
+type sampler struct {
+	stats memoryStats
+	start runtime.MemStats
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+// sampleInterval is how often memory is sampled. Reading the statistics
+// stops the world briefly, so it is kept coarse.
+const sampleInterval = 100 * time.Millisecond
+
+func startSampler() *sampler {
+	s := &sampler{quit: make(chan struct{}), done: make(chan struct{})}
+	runtime.ReadMemStats(&s.start)
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+		for {
+			s.sample()
+			select {
+			case <-ticker.C:
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *sampler) sample() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	s.stats.peakHeap = max(s.stats.peakHeap, m.HeapInuse)
+	s.stats.peakSys = max(s.stats.peakSys, m.Sys)
+	s.stats.allocated = m.TotalAlloc - s.start.TotalAlloc
+	s.stats.gcs = m.NumGC - s.start.NumGC
+	s.stats.peakGoroutines = max(s.stats.peakGoroutines, runtime.NumGoroutine())
+}
+
+// stop takes a last sample and returns the statistics.
+func (s *sampler) stop() memoryStats {
+	close(s.quit)
+	<-s.done
+	s.sample()
+	return s.stats
+}
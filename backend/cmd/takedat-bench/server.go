@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"time"
+
+	"takedat/internal/api"
+	"takedat/internal/config"
+	"takedat/internal/session"
+	"takedat/internal/websocket"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// messageOverhead is room for a chunk message's JSON around its data.
+const messageOverhead = 4096
+
+// server is a relay running inside the bench process.
+type server struct {
+	URL    string
+	http   *http.Server
+	cancel context.CancelFunc
+}
+
+// startServer starts the session API and the WebSocket hub on a loopback
+// port with the default configuration. Per-IP quotas and rate limits are
+// off since every pair comes from the same address, and request logging is
+// left out. The message size limit is raised if a chunk would not fit.
+func startServer(opts *options) (*server, error) {
+	cfg := config.Default()
+	if need := int64(base64.StdEncoding.EncodedLen(int(opts.chunk))) + messageOverhead; need > cfg.WSMaxMessageSize {
+		cfg.WSMaxMessageSize = need
+	}
+
+	sessions := session.NewManager(session.Options{
+		TTL:             opts.timeout + time.Minute,
+		CleanupInterval: cfg.SessionCleanupInterval,
+	})
+	hub := websocket.NewHub(sessions, websocket.Options{
+		EnableCompression: cfg.WSCompression,
+		Compressions:      cfg.ChunkCompressions,
+		MaxStreams:        cfg.MaxStreams,
+		WriteWait:         cfg.WSWriteWait,
+		PongWait:          cfg.WSPongWait,
+		PingPeriod:        cfg.WSPingPeriod,
+		RegisterWait:      cfg.WSRegisterWait,
+		MaxMessageSize:    cfg.WSMaxMessageSize,
+		ReadBufferSize:    cfg.WSReadBufferSize,
+		WriteBufferSize:   cfg.WSWriteBufferSize,
+		SendQueue:         cfg.WSSendQueue,
+		ReplayBuffer:      cfg.WSReplayBuffer,
+		ReplayGrace:       cfg.WSReplayGrace,
+	})
+	go hub.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go sessions.StartCleanup(ctx)
+
+	handler := api.NewHandler(cfg, sessions, nil, nil, hub)
+	r := chi.NewRouter()
+	r.Post("/api/sessions", handler.CreateSession)
+	r.Get("/ws/{code}", hub.HandleWebSocket)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	srv := &http.Server{Handler: r}
+	go srv.Serve(ln)
+
+	return &server{URL: "http://" + ln.Addr().String(), http: srv, cancel: cancel}, nil
+}
+
+func (s *server) Close() {
+	s.cancel()
+	s.http.Close()
+}
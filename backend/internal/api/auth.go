@@ -0,0 +1,72 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"takedat/internal/auth"
+)
+
+// authenticate resolves the bearer token, if any, to an API key or a user
+// and stores the result in the request context. A token that does not
+// check out is rejected outright rather than treated as anonymous. keys and
+// verifier are nil when the corresponding kind of credential is disabled.
+func authenticate(keys *auth.Keystore, verifier *auth.Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := auth.BearerToken(r)
+			if token == "" || (keys == nil && verifier == nil) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var p auth.Principal
+			var err error
+			switch {
+			case auth.IsAPIKey(token) && keys != nil:
+				p.Key, err = keys.Authenticate(token)
+			case !auth.IsAPIKey(token) && verifier != nil:
+				p.User, err = verifier.Verify(token)
+			default:
+				err = auth.ErrInvalidToken
+			}
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid or expired credentials")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+// requireScope rejects requests whose credentials lack scope. Anonymous
+// requests are let through unless required is set.
+func requireScope(required bool, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := auth.FromContext(r.Context()).Authorize(required, scope); err != nil {
+				writeAuthError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// notFound hides a route that is switched off.
+func notFound(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Not found")
+	})
+}
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrAuthRequired):
+		writeError(w, http.StatusUnauthorized, "AUTH_REQUIRED", "Authentication is required")
+	case errors.Is(err, auth.ErrInsufficientScope):
+		writeError(w, http.StatusForbidden, "INSUFFICIENT_SCOPE", "Credentials lack the required scope")
+	default:
+		writeError(w, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid or expired credentials")
+	}
+}
@@ -2,24 +2,52 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"takedat/internal/auth"
+	"takedat/internal/config"
+	"takedat/internal/realip"
 	"takedat/internal/session"
+	"takedat/internal/turn"
+	"takedat/internal/websocket"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type Handler struct {
-	sessions *session.Manager
+	sessions     *session.Manager
+	reservations *session.Reservations
+	turn         *turn.Server
+	hub          *websocket.Hub
+	publicURL    string
+	iceServers   []string
 }
 
-func NewHandler(sessions *session.Manager) *Handler {
-	return &Handler{sessions: sessions}
+// NewHandler creates the REST handler. reservations and turnServer are nil
+// when vanity codes or the embedded TURN relay are disabled.
+func NewHandler(cfg *config.Config, sessions *session.Manager, reservations *session.Reservations, turnServer *turn.Server, hub *websocket.Hub) *Handler {
+	return &Handler{
+		sessions:     sessions,
+		reservations: reservations,
+		turn:         turnServer,
+		hub:          hub,
+		publicURL:    cfg.PublicURL,
+		iceServers:   cfg.ICEServers,
+	}
 }
 
 type CreateSessionRequest struct {
 	FileName string `json:"fileName"`
 	FileSize int64  `json:"fileSize"`
 	MimeType string `json:"mimeType"`
+	// Code and OwnerToken bind the session to a reserved vanity code.
+	Code       string `json:"code,omitempty"`
+	OwnerToken string `json:"ownerToken,omitempty"`
+	// SpeedLimit caps the transfer's relay bandwidth in bytes per second.
+	SpeedLimit int64 `json:"speedLimit,omitempty"`
+	// Recipients restricts receiving to these emails, @domains or token
+	// subjects.
+	Recipients []string `json:"recipients,omitempty"`
 }
 
 type CreateSessionResponse struct {
@@ -29,11 +57,66 @@ type CreateSessionResponse struct {
 }
 
 type SessionInfoResponse struct {
-	SessionID string `json:"sessionId"`
-	FileName  string `json:"fileName"`
-	FileSize  int64  `json:"fileSize"`
-	MimeType  string `json:"mimeType"`
-	Status    string `json:"status"`
+	SessionID   string           `json:"sessionId"`
+	FileName    string           `json:"fileName"`
+	FileSize    int64            `json:"fileSize"`
+	MimeType    string           `json:"mimeType"`
+	Status      string           `json:"status"`
+	Transport   string           `json:"transport"`
+	Compression string           `json:"compression,omitempty"`
+	SpeedLimit  int64            `json:"speedLimit,omitempty"`
+	CreatedBy   *CreatorResponse `json:"createdBy,omitempty"`
+	Progress    ProgressResponse `json:"progress"`
+	Summary     *SummaryResponse `json:"summary,omitempty"`
+}
+
+// CreatorResponse tells the receiver who is sending. The subject stays
+// private.
+type CreatorResponse struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+type ProgressResponse struct {
+	BytesTransferred int64   `json:"bytesTransferred"` // file bytes, before compression
+	BytesRelayed     int64   `json:"bytesRelayed"`     // bytes sent, after compression
+	ChunksRelayed    int     `json:"chunksRelayed"`
+	ChunksAcked      int     `json:"chunksAcked"`
+	Percent          float64 `json:"percent"`
+	Throughput       float64 `json:"throughput"` // file bytes per second
+	ETA              int64   `json:"eta"`        // milliseconds
+	StartedAt        int64   `json:"startedAt,omitempty"`
+}
+
+type SummaryResponse struct {
+	TotalBytes   int64   `json:"totalBytes"`   // file bytes, before compression
+	BytesRelayed int64   `json:"bytesRelayed"` // bytes sent, after compression
+	TotalChunks  int     `json:"totalChunks"`
+	ChunksAcked  int     `json:"chunksAcked"`
+	Duration     int64   `json:"duration"`   // milliseconds
+	Throughput   float64 `json:"throughput"` // file bytes per second
+	CompletedAt  int64   `json:"completedAt"`
+}
+
+type ReserveCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type ReserveCodeResponse struct {
+	Code       string `json:"code"`
+	OwnerToken string `json:"ownerToken"`
+}
+
+// ICEServer mirrors the browser's RTCIceServer.
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+type ICEServersResponse struct {
+	ICEServers []ICEServer `json:"iceServers"`
+	ExpiresAt  int64       `json:"expiresAt,omitempty"` // when TURN credentials expire
 }
 
 type ErrorResponse struct {
@@ -58,13 +141,41 @@ func (h *Handler) CreateSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.SpeedLimit < 0 {
+		writeError(w, http.StatusBadRequest, "INVALID_FIELD", "speedLimit must not be negative")
+		return
+	}
+
+	recipients, err := session.NormalizeRecipients(req.Recipients)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_FIELD", err.Error())
+		return
+	}
+
 	sess, err := h.sessions.Create(session.CreateParams{
-		FileName: req.FileName,
-		FileSize: req.FileSize,
-		MimeType: req.MimeType,
+		FileName:   req.FileName,
+		FileSize:   req.FileSize,
+		MimeType:   req.MimeType,
+		VanityCode: req.Code,
+		OwnerToken: req.OwnerToken,
+		SpeedLimit: req.SpeedLimit,
+		OwnerIP:    realip.ClientIP(r),
+		Owner:      ownerFromPrincipal(auth.FromContext(r.Context())),
+		Recipients: recipients,
 	})
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "CREATE_FAILED", "Failed to create session")
+		switch {
+		case errors.Is(err, session.ErrReservationNotFound):
+			writeError(w, http.StatusNotFound, "RESERVATION_NOT_FOUND", "Code is not reserved")
+		case errors.Is(err, session.ErrNotOwner):
+			writeError(w, http.StatusForbidden, "NOT_OWNER", "Invalid owner token for this code")
+		case errors.Is(err, session.ErrCodeInUse):
+			writeError(w, http.StatusConflict, "CODE_IN_USE", "Code is bound to an active transfer")
+		case errors.Is(err, session.ErrQuotaExceeded):
+			writeError(w, http.StatusTooManyRequests, "QUOTA_EXCEEDED", "Too many active sessions from this address")
+		default:
+			writeError(w, http.StatusInternalServerError, "CREATE_FAILED", "Failed to create session")
+		}
 		return
 	}
 
@@ -76,33 +187,96 @@ func (h *Handler) CreateSession(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetSession(w http.ResponseWriter, r *http.Request) {
-	code := chi.URLParam(r, "code")
+	sess, ok := h.lookupSession(w, chi.URLParam(r, "code"))
+	if !ok || !h.checkRecipient(w, r, sess) {
+		return
+	}
+
+	resp := SessionInfoResponse{
+		SessionID:   sess.ID,
+		FileName:    sess.FileName,
+		FileSize:    sess.FileSize,
+		MimeType:    sess.MimeType,
+		Status:      string(sess.GetStatus()),
+		Transport:   string(sess.GetTransport()),
+		Compression: sess.GetCompression(),
+		SpeedLimit:  sess.SpeedLimit,
+		Progress:    newProgressResponse(sess.Progress(), sess.FileSize),
+	}
+	if sess.Owner != nil {
+		resp.CreatedBy = &CreatorResponse{Name: sess.Owner.Name, Email: sess.Owner.Email}
+	}
+	if summary := sess.Summary(); summary != nil {
+		resp.Summary = &SummaryResponse{
+			TotalBytes:   summary.TotalBytes,
+			BytesRelayed: summary.BytesRelayed,
+			TotalChunks:  summary.TotalChunks,
+			ChunksAcked:  summary.ChunksAcked,
+			Duration:     summary.Duration.Milliseconds(),
+			Throughput:   summary.Throughput,
+			CompletedAt:  summary.CompletedAt.UnixMilli(),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// lookupSession finds the session for code, writing the error response and
+// returning false if there is none.
+func (h *Handler) lookupSession(w http.ResponseWriter, code string) (*session.Session, bool) {
 	if code == "" {
 		writeError(w, http.StatusBadRequest, "MISSING_CODE", "Code is required")
-		return
+		return nil, false
 	}
 
 	sess, err := h.sessions.GetByCode(code)
 	if err != nil {
 		if err == session.ErrSessionNotFound {
 			writeError(w, http.StatusNotFound, "SESSION_NOT_FOUND", "Session not found")
-			return
+			return nil, false
 		}
 		if err == session.ErrSessionExpired {
 			writeError(w, http.StatusGone, "SESSION_EXPIRED", "Session has expired")
-			return
+			return nil, false
 		}
 		writeError(w, http.StatusInternalServerError, "GET_FAILED", "Failed to get session")
-		return
+		return nil, false
 	}
 
-	writeJSON(w, http.StatusOK, SessionInfoResponse{
-		SessionID: sess.ID,
-		FileName:  sess.FileName,
-		FileSize:  sess.FileSize,
-		MimeType:  sess.MimeType,
-		Status:    string(sess.Status),
-	})
+	return sess, true
+}
+
+// checkRecipient enforces the session's recipient allowlist, writing the
+// error response and returning false if the caller is not on it.
+func (h *Handler) checkRecipient(w http.ResponseWriter, r *http.Request, sess *session.Session) bool {
+	err := h.hub.CheckRecipient(auth.FromContext(r.Context()), sess)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, auth.ErrAuthRequired):
+		writeError(w, http.StatusUnauthorized, "AUTH_REQUIRED", "Sign in to receive this file")
+	default:
+		writeError(w, http.StatusForbidden, "NOT_A_RECIPIENT", "You are not a recipient of this file")
+	}
+	return false
+}
+
+func newProgressResponse(p session.Progress, fileSize int64) ProgressResponse {
+	resp := ProgressResponse{
+		BytesTransferred: p.BytesTransferred,
+		BytesRelayed:     p.BytesRelayed,
+		ChunksRelayed:    p.ChunksRelayed,
+		ChunksAcked:      p.ChunksAcked,
+		Throughput:       p.Throughput,
+		ETA:              p.ETA.Milliseconds(),
+	}
+	if fileSize > 0 {
+		resp.Percent = min(float64(p.BytesTransferred)/float64(fileSize)*100, 100)
+	}
+	if !p.StartedAt.IsZero() {
+		resp.StartedAt = p.StartedAt.UnixMilli()
+	}
+	return resp
 }
 
 func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
@@ -112,16 +286,143 @@ func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the creator may delete an owned session. Deleting a session that
+	// is already gone still succeeds.
+	if sess, err := h.sessions.GetByCode(code); err == nil && sess.Owner != nil {
+		if !sess.OwnedBy(auth.FromContext(r.Context()).Subject()) {
+			writeError(w, http.StatusForbidden, "NOT_OWNER", "Only the session's creator can delete it")
+			return
+		}
+	}
+
 	h.sessions.Delete(code)
 	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
+func (h *Handler) ReserveCode(w http.ResponseWriter, r *http.Request) {
+	if h.reservations == nil {
+		writeError(w, http.StatusNotFound, "RESERVATIONS_DISABLED", "Vanity codes are disabled")
+		return
+	}
+
+	var req ReserveCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request body")
+		return
+	}
+
+	token, err := h.reservations.Reserve(req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, session.ErrInvalidVanityCode):
+			writeError(w, http.StatusBadRequest, "INVALID_CODE", err.Error())
+		case errors.Is(err, session.ErrCodeTaken):
+			writeError(w, http.StatusConflict, "CODE_TAKEN", "Code is already reserved")
+		default:
+			writeError(w, http.StatusInternalServerError, "RESERVE_FAILED", "Failed to reserve code")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, ReserveCodeResponse{
+		Code:       session.NormalizeVanityCode(req.Code),
+		OwnerToken: token,
+	})
+}
+
+func (h *Handler) ReleaseCode(w http.ResponseWriter, r *http.Request) {
+	if h.reservations == nil {
+		writeError(w, http.StatusNotFound, "RESERVATIONS_DISABLED", "Vanity codes are disabled")
+		return
+	}
+
+	code := chi.URLParam(r, "code")
+	if err := h.reservations.Release(code, r.Header.Get("X-Owner-Token")); err != nil {
+		switch {
+		case errors.Is(err, session.ErrReservationNotFound):
+			writeError(w, http.StatusNotFound, "RESERVATION_NOT_FOUND", "Code is not reserved")
+		case errors.Is(err, session.ErrNotOwner):
+			writeError(w, http.StatusForbidden, "NOT_OWNER", "Invalid owner token for this code")
+		default:
+			writeError(w, http.StatusInternalServerError, "RELEASE_FAILED", "Failed to release code")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// ICEServers returns the ICE servers peers should use to establish a direct
+// WebRTC connection for a session. When the embedded TURN relay is enabled
+// it includes short-lived credentials bound to the session. The role query
+// parameter names the side asking, the receiver by default.
+func (h *Handler) ICEServers(w http.ResponseWriter, r *http.Request) {
+	role := r.URL.Query().Get("role")
+	if role == "" {
+		role = "receiver"
+	}
+	if role != "sender" && role != "receiver" {
+		writeError(w, http.StatusBadRequest, "INVALID_ROLE", "role must be sender or receiver")
+		return
+	}
+
+	sess, ok := h.lookupSession(w, chi.URLParam(r, "code"))
+	if !ok {
+		return
+	}
+	// TURN credentials let the holder relay traffic through the server, so
+	// they are only handed to callers who may join the session.
+	if err := h.hub.CheckJoin(auth.FromContext(r.Context()), role, sess); err != nil {
+		switch {
+		case errors.Is(err, session.ErrNotOwner):
+			writeError(w, http.StatusForbidden, "NOT_OWNER", "Only the session's creator can send it")
+		case errors.Is(err, session.ErrNotRecipient):
+			writeError(w, http.StatusForbidden, "NOT_A_RECIPIENT", "You are not a recipient of this file")
+		default:
+			writeAuthError(w, err)
+		}
+		return
+	}
+
+	resp := ICEServersResponse{ICEServers: []ICEServer{}}
+	if len(h.iceServers) > 0 {
+		resp.ICEServers = append(resp.ICEServers, ICEServer{URLs: h.iceServers})
+	}
+
+	if h.turn != nil {
+		creds, err := h.turn.Credentials(sess.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "TURN_FAILED", "Failed to create TURN credentials")
+			return
+		}
+		resp.ICEServers = append(resp.ICEServers, ICEServer{
+			URLs:       creds.URLs,
+			Username:   creds.Username,
+			Credential: creds.Password,
+		})
+		resp.ExpiresAt = creds.ExpiresAt.UnixMilli()
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{
 		"status": "ok",
 	})
 }
 
+// ownerFromPrincipal records who created a session, or nil if anonymous.
+func ownerFromPrincipal(p auth.Principal) *session.Owner {
+	switch {
+	case p.User != nil:
+		return &session.Owner{Subject: p.User.Subject, Email: p.User.Email, Name: p.User.Name}
+	case p.Key != nil:
+		return &session.Owner{Subject: p.Subject(), Name: p.Key.Name}
+	}
+	return nil
+}
+
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
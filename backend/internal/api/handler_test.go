@@ -0,0 +1,438 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"takedat/internal/auth"
+	"takedat/internal/config"
+	"takedat/internal/session"
+	"takedat/internal/websocket"
+)
+
+type handlerTest struct {
+	router   http.Handler
+	sessions *session.Manager
+}
+
+func newHandlerTest(t *testing.T, cfg *config.Config, opts session.Options) *handlerTest {
+	t.Helper()
+
+	reservations, err := session.NewReservations("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.TTL == 0 {
+		opts.TTL = time.Minute
+	}
+	opts.Reservations = reservations
+
+	sessions := session.NewManager(opts)
+	hub := websocket.NewHub(sessions, websocket.Options{})
+	return &handlerTest{
+		router:   NewRouter(RouterConfig{Config: cfg, Sessions: sessions, Reservations: reservations, Hub: hub}),
+		sessions: sessions,
+	}
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out, if given.
+func (h *handlerTest) do(t *testing.T, method, target, body string, header http.Header, out any) int {
+	t.Helper()
+
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.RemoteAddr = "192.0.2.1:1234"
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.router.ServeHTTP(rec, req)
+
+	if out != nil {
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("%s %s: Content-Type = %q", method, target, ct)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decode %q: %v", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func (h *handlerTest) create(t *testing.T, body string) CreateSessionResponse {
+	t.Helper()
+
+	var resp CreateSessionResponse
+	if status := h.do(t, http.MethodPost, "/api/sessions", body, nil, &resp); status != http.StatusCreated {
+		t.Fatalf("create %s: status = %d", body, status)
+	}
+	return resp
+}
+
+func TestHealth(t *testing.T) {
+	h := newHandlerTest(t, &config.Config{}, session.Options{})
+
+	var resp map[string]string
+	if status := h.do(t, http.MethodGet, "/api/health", "", nil, &resp); status != http.StatusOK || resp["status"] != "ok" {
+		t.Errorf("health: got %d %v", status, resp)
+	}
+}
+
+func TestCreateSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"valid", `{"fileName":"a.txt","fileSize":10,"mimeType":"text/plain"}`, http.StatusCreated, ""},
+		{"with recipients", `{"fileName":"a.txt","fileSize":10,"recipients":["Bob@Example.com","@corp.example"]}`, http.StatusCreated, ""},
+		{"speed limit", `{"fileName":"a.txt","fileSize":10,"speedLimit":1024}`, http.StatusCreated, ""},
+		{"malformed body", `{"fileName":`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"missing file name", `{"fileSize":10}`, http.StatusBadRequest, "MISSING_FIELD"},
+		{"zero size", `{"fileName":"a.txt","fileSize":0}`, http.StatusBadRequest, "INVALID_FIELD"},
+		{"negative speed limit", `{"fileName":"a.txt","fileSize":10,"speedLimit":-1}`, http.StatusBadRequest, "INVALID_FIELD"},
+		{"invalid recipient", `{"fileName":"a.txt","fileSize":10,"recipients":["bob@@example.com"]}`, http.StatusBadRequest, "INVALID_FIELD"},
+		{"unreserved vanity code", `{"fileName":"a.txt","fileSize":10,"code":"MY-FILES"}`, http.StatusNotFound, "RESERVATION_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		h := newHandlerTest(t, &config.Config{}, session.Options{})
+
+		if tt.status == http.StatusCreated {
+			var resp CreateSessionResponse
+			status := h.do(t, http.MethodPost, "/api/sessions", tt.body, nil, &resp)
+			if status != tt.status {
+				t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+				continue
+			}
+			sess, err := h.sessions.GetByCode(resp.Code)
+			if err != nil {
+				t.Errorf("%s: created session not found: %v", tt.name, err)
+				continue
+			}
+			if resp.SessionID != sess.ID || resp.ExpiresAt != sess.ExpiresAt.UnixMilli() {
+				t.Errorf("%s: response %+v does not match session %s", tt.name, resp, sess.ID)
+			}
+			continue
+		}
+
+		var resp ErrorResponse
+		status := h.do(t, http.MethodPost, "/api/sessions", tt.body, nil, &resp)
+		if status != tt.status || resp.Code != tt.code {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, status, resp.Code, tt.status, tt.code)
+		}
+	}
+}
+
+func TestCreateSessionQuota(t *testing.T) {
+	h := newHandlerTest(t, &config.Config{}, session.Options{Quotas: session.Quotas{MaxSessionsPerIP: 1}})
+
+	h.create(t, `{"fileName":"a.txt","fileSize":10}`)
+
+	var resp ErrorResponse
+	status := h.do(t, http.MethodPost, "/api/sessions", `{"fileName":"b.txt","fileSize":10}`, nil, &resp)
+	if status != http.StatusTooManyRequests || resp.Code != "QUOTA_EXCEEDED" {
+		t.Errorf("second session: got %d %s, want 429 QUOTA_EXCEEDED", status, resp.Code)
+	}
+}
+
+func TestGetSession(t *testing.T) {
+	h := newHandlerTest(t, &config.Config{}, session.Options{})
+	created := h.create(t, `{"fileName":"a.txt","fileSize":2048,"mimeType":"text/plain","speedLimit":512}`)
+
+	sess, err := h.sessions.GetByCode(created.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Half the file, compressed to a quarter of its size.
+	sess.RecordChunk(1024, 512)
+
+	tests := []struct {
+		name   string
+		code   string
+		status int
+		err    string
+	}{
+		{"exact code", created.Code, http.StatusOK, ""},
+		{"lower case code", strings.ToLower(created.Code), http.StatusOK, ""},
+		{"unknown code", "ZZZ-ZZZ", http.StatusNotFound, "SESSION_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		if tt.status != http.StatusOK {
+			var resp ErrorResponse
+			status := h.do(t, http.MethodGet, "/api/sessions/"+tt.code, "", nil, &resp)
+			if status != tt.status || resp.Code != tt.err {
+				t.Errorf("%s: got %d %s, want %d %s", tt.name, status, resp.Code, tt.status, tt.err)
+			}
+			continue
+		}
+
+		var resp SessionInfoResponse
+		if status := h.do(t, http.MethodGet, "/api/sessions/"+tt.code, "", nil, &resp); status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+			continue
+		}
+		if resp.SessionID != created.SessionID || resp.FileName != "a.txt" || resp.FileSize != 2048 ||
+			resp.MimeType != "text/plain" || resp.SpeedLimit != 512 {
+			t.Errorf("%s: got %+v", tt.name, resp)
+		}
+		if resp.Status != string(session.StatusTransferring) || resp.Transport != string(session.TransportRelay) {
+			t.Errorf("%s: status %q transport %q", tt.name, resp.Status, resp.Transport)
+		}
+		if resp.Progress.BytesTransferred != 1024 || resp.Progress.BytesRelayed != 512 ||
+			resp.Progress.ChunksRelayed != 1 || resp.Progress.Percent != 50 {
+			t.Errorf("%s: progress = %+v", tt.name, resp.Progress)
+		}
+		if resp.CreatedBy != nil || resp.Summary != nil {
+			t.Errorf("%s: unexpected creator %v or summary %v", tt.name, resp.CreatedBy, resp.Summary)
+		}
+	}
+}
+
+func TestGetSessionExpired(t *testing.T) {
+	h := newHandlerTest(t, &config.Config{}, session.Options{TTL: time.Nanosecond})
+	created := h.create(t, `{"fileName":"a.txt","fileSize":10}`)
+	time.Sleep(time.Millisecond)
+
+	var resp ErrorResponse
+	status := h.do(t, http.MethodGet, "/api/sessions/"+created.Code, "", nil, &resp)
+	if status != http.StatusGone || resp.Code != "SESSION_EXPIRED" {
+		t.Errorf("expired session: got %d %s, want 410 SESSION_EXPIRED", status, resp.Code)
+	}
+}
+
+func TestGetSessionRecipients(t *testing.T) {
+	h := newHandlerTest(t, &config.Config{}, session.Options{})
+	created := h.create(t, `{"fileName":"a.txt","fileSize":10,"recipients":["bob@example.com"]}`)
+
+	for _, target := range []string{"/api/sessions/" + created.Code, "/api/sessions/" + created.Code + "/ice-servers"} {
+		var resp ErrorResponse
+		status := h.do(t, http.MethodGet, target, "", nil, &resp)
+		if status != http.StatusUnauthorized || resp.Code != "AUTH_REQUIRED" {
+			t.Errorf("%s anonymously: got %d %s, want 401 AUTH_REQUIRED", target, status, resp.Code)
+		}
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	h := newHandlerTest(t, &config.Config{}, session.Options{})
+	created := h.create(t, `{"fileName":"a.txt","fileSize":10}`)
+
+	var resp map[string]bool
+	if status := h.do(t, http.MethodDelete, "/api/sessions/"+created.Code, "", nil, &resp); status != http.StatusOK || !resp["success"] {
+		t.Errorf("delete: got %d %v", status, resp)
+	}
+	if status := h.do(t, http.MethodGet, "/api/sessions/"+created.Code, "", nil, nil); status != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want 404", status)
+	}
+
+	// Deleting is idempotent.
+	if status := h.do(t, http.MethodDelete, "/api/sessions/"+created.Code, "", nil, nil); status != http.StatusOK {
+		t.Errorf("second delete: status = %d, want 200", status)
+	}
+}
+
+func TestDeleteSessionOwner(t *testing.T) {
+	keys, err := auth.NewKeystore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerToken, _, err := keys.Create("owner", []string{auth.ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, _, err := keys.Create("other", []string{auth.ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := session.NewManager(session.Options{TTL: time.Minute})
+	hub := websocket.NewHub(sessions, websocket.Options{})
+	h := &handlerTest{
+		router:   NewRouter(RouterConfig{Config: &config.Config{}, Sessions: sessions, Hub: hub, Keys: keys}),
+		sessions: sessions,
+	}
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+
+	var created CreateSessionResponse
+	if status := h.do(t, http.MethodPost, "/api/sessions", `{"fileName":"a.txt","fileSize":10}`, bearer(ownerToken), &created); status != http.StatusCreated {
+		t.Fatalf("create: status = %d", status)
+	}
+
+	for name, header := range map[string]http.Header{"anonymous": nil, "other key": bearer(otherToken)} {
+		var resp ErrorResponse
+		status := h.do(t, http.MethodDelete, "/api/sessions/"+created.Code, "", header, &resp)
+		if status != http.StatusForbidden || resp.Code != "NOT_OWNER" {
+			t.Errorf("delete as %s: got %d %s, want 403 NOT_OWNER", name, status, resp.Code)
+		}
+	}
+	if _, err := sessions.GetByCode(created.Code); err != nil {
+		t.Fatalf("session gone after refused deletes: %v", err)
+	}
+
+	var resp map[string]bool
+	if status := h.do(t, http.MethodDelete, "/api/sessions/"+created.Code, "", bearer(ownerToken), &resp); status != http.StatusOK || !resp["success"] {
+		t.Errorf("delete as owner: got %d %v", status, resp)
+	}
+	if _, err := sessions.GetByCode(created.Code); err != session.ErrSessionNotFound {
+		t.Errorf("get after owner delete: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestReservations(t *testing.T) {
+	h := newHandlerTest(t, &config.Config{}, session.Options{})
+
+	var reserved ReserveCodeResponse
+	if status := h.do(t, http.MethodPost, "/api/reservations", `{"code":"team files"}`, nil, &reserved); status != http.StatusCreated {
+		t.Fatalf("reserve: status = %d", status)
+	}
+	if reserved.Code != "TEAM-FILES" || reserved.OwnerToken == "" {
+		t.Fatalf("reserve: got %+v", reserved)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		header http.Header
+		status int
+		code   string
+	}{
+		{"taken", http.MethodPost, "/api/reservations", `{"code":"TEAM-FILES"}`, nil, http.StatusConflict, "CODE_TAKEN"},
+		{"invalid", http.MethodPost, "/api/reservations", `{"code":"1-bad"}`, nil, http.StatusBadRequest, "INVALID_CODE"},
+		{"random code shape", http.MethodPost, "/api/reservations", `{"code":"ABC-DEF"}`, nil, http.StatusBadRequest, "INVALID_CODE"},
+		{"bind with wrong token", http.MethodPost, "/api/sessions",
+			`{"fileName":"a.txt","fileSize":10,"code":"team-files","ownerToken":"wrong"}`, nil, http.StatusForbidden, "NOT_OWNER"},
+		{"bind", http.MethodPost, "/api/sessions",
+			`{"fileName":"a.txt","fileSize":10,"code":"team-files","ownerToken":"` + reserved.OwnerToken + `"}`, nil, http.StatusCreated, "TEAM-FILES"},
+		{"release with wrong token", http.MethodDelete, "/api/reservations/TEAM-FILES", "",
+			http.Header{"X-Owner-Token": {"wrong"}}, http.StatusForbidden, "NOT_OWNER"},
+		{"release", http.MethodDelete, "/api/reservations/team-files", "",
+			http.Header{"X-Owner-Token": {reserved.OwnerToken}}, http.StatusOK, ""},
+		{"release again", http.MethodDelete, "/api/reservations/TEAM-FILES", "",
+			http.Header{"X-Owner-Token": {reserved.OwnerToken}}, http.StatusNotFound, "RESERVATION_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		var resp ErrorResponse
+		status := h.do(t, tt.method, tt.target, tt.body, tt.header, &resp)
+		if status != tt.status || resp.Code != tt.code {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, status, resp.Code, tt.status, tt.code)
+		}
+	}
+
+	if _, err := h.sessions.GetByCode("team-files"); err != nil {
+		t.Errorf("session bound to vanity code: %v", err)
+	}
+}
+
+func TestReservationsDisabled(t *testing.T) {
+	sessions := session.NewManager(session.Options{TTL: time.Minute})
+	hub := websocket.NewHub(sessions, websocket.Options{})
+	h := &handlerTest{router: NewRouter(RouterConfig{Config: &config.Config{}, Sessions: sessions, Hub: hub}), sessions: sessions}
+
+	var resp ErrorResponse
+	if status := h.do(t, http.MethodPost, "/api/reservations", `{"code":"TEAM-FILES"}`, nil, &resp); status != http.StatusNotFound || resp.Code != "RESERVATIONS_DISABLED" {
+		t.Errorf("reserve: got %d %s, want 404 RESERVATIONS_DISABLED", status, resp.Code)
+	}
+}
+
+func TestICEServers(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers []string
+		want    int
+	}{
+		{"none", nil, 0},
+		{"stun", []string{"stun:stun.example.com:3478"}, 1},
+	}
+	for _, tt := range tests {
+		h := newHandlerTest(t, &config.Config{ICEServers: tt.servers}, session.Options{})
+		created := h.create(t, `{"fileName":"a.txt","fileSize":10}`)
+
+		var resp ICEServersResponse
+		status := h.do(t, http.MethodGet, "/api/sessions/"+created.Code+"/ice-servers", "", nil, &resp)
+		if status != http.StatusOK || len(resp.ICEServers) != tt.want {
+			t.Errorf("%s: got %d %+v, want %d servers", tt.name, status, resp.ICEServers, tt.want)
+			continue
+		}
+		if tt.want > 0 && resp.ICEServers[0].URLs[0] != tt.servers[0] {
+			t.Errorf("%s: URLs = %v", tt.name, resp.ICEServers[0].URLs)
+		}
+	}
+
+	h := newHandlerTest(t, &config.Config{}, session.Options{})
+	if status := h.do(t, http.MethodGet, "/api/sessions/ZZZ-ZZZ/ice-servers", "", nil, nil); status != http.StatusNotFound {
+		t.Errorf("unknown session: status = %d, want 404", status)
+	}
+}
+
+func TestICEServersAuth(t *testing.T) {
+	keys, err := auth.NewKeystore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{}
+	for name, scope := range map[string]string{"owner": auth.ScopeCreate, "other": auth.ScopeCreate, "receiver": auth.ScopeReceive} {
+		if tokens[name], _, err = keys.Create(name, []string{scope}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessions := session.NewManager(session.Options{TTL: time.Minute})
+	hub := websocket.NewHub(sessions, websocket.Options{RequireSenderAuth: true, RequireReceiverAuth: true})
+	h := &handlerTest{
+		router:   NewRouter(RouterConfig{Config: &config.Config{RequireAPIKey: true}, Sessions: sessions, Hub: hub, Keys: keys}),
+		sessions: sessions,
+	}
+	bearer := func(name string) http.Header {
+		if name == "" {
+			return nil
+		}
+		return http.Header{"Authorization": {"Bearer " + tokens[name]}}
+	}
+
+	var created CreateSessionResponse
+	if status := h.do(t, http.MethodPost, "/api/sessions", `{"fileName":"a.txt","fileSize":10}`, bearer("owner"), &created); status != http.StatusCreated {
+		t.Fatalf("create: status = %d", status)
+	}
+
+	tests := []struct {
+		key    string
+		role   string
+		status int
+		code   string
+	}{
+		{"", "", http.StatusUnauthorized, "AUTH_REQUIRED"},
+		{"", "sender", http.StatusUnauthorized, "AUTH_REQUIRED"},
+		{"receiver", "", http.StatusOK, ""},
+		{"receiver", "sender", http.StatusForbidden, "INSUFFICIENT_SCOPE"},
+		{"owner", "sender", http.StatusOK, ""},
+		{"other", "sender", http.StatusForbidden, "NOT_OWNER"},
+		{"other", "receiver", http.StatusForbidden, "INSUFFICIENT_SCOPE"},
+		{"owner", "admin", http.StatusBadRequest, "INVALID_ROLE"},
+	}
+	for _, tt := range tests {
+		target := "/api/sessions/" + created.Code + "/ice-servers"
+		if tt.role != "" {
+			target += "?role=" + tt.role
+		}
+		var resp ErrorResponse
+		status := h.do(t, http.MethodGet, target, "", bearer(tt.key), &resp)
+		if status != tt.status || resp.Code != tt.code {
+			t.Errorf("key %q role %q: got %d %s, want %d %s", tt.key, tt.role, status, resp.Code, tt.status, tt.code)
+		}
+	}
+}
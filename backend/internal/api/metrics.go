@@ -0,0 +1,35 @@
+package api
+
+import "net/http"
+
+type MetricsResponse struct {
+	ActiveSessions    int                     `json:"activeSessions"`
+	ConnectedSessions int                     `json:"connectedSessions"`
+	ActivePairs       int                     `json:"activePairs"`
+	BytesRelayedToday int64                   `json:"bytesRelayedToday"`
+	QuotaRejections   QuotaRejectionsResponse `json:"quotaRejections"`
+}
+
+// QuotaRejectionsResponse counts requests refused by each server-wide limit.
+type QuotaRejectionsResponse struct {
+	Sessions uint64 `json:"sessions"` // sessions per IP
+	Pairs    uint64 `json:"pairs"`    // concurrent pairs
+	Bytes    uint64 `json:"bytes"`    // chunks over the daily bytes per IP
+}
+
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	sessions := h.sessions.Stats()
+	hub := h.hub.Stats()
+
+	writeJSON(w, http.StatusOK, MetricsResponse{
+		ActiveSessions:    sessions.ActiveSessions,
+		ConnectedSessions: hub.Sessions,
+		ActivePairs:       hub.ActivePairs,
+		BytesRelayedToday: sessions.BytesRelayedToday,
+		QuotaRejections: QuotaRejectionsResponse{
+			Sessions: sessions.SessionsRejected,
+			Pairs:    hub.PairsRejected,
+			Bytes:    sessions.BytesRejected,
+		},
+	})
+}
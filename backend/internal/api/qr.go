@@ -0,0 +1,132 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 1024
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// SessionQRPNG renders a QR code of the session's receive URL as PNG.
+func (h *Handler) SessionQRPNG(w http.ResponseWriter, r *http.Request) {
+	qr, size, ok := h.sessionQR(w, r)
+	if !ok {
+		return
+	}
+
+	png, err := qr.PNG(size)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "QR_FAILED", "Failed to render QR code")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+// SessionQRSVG renders a QR code of the session's receive URL as SVG.
+func (h *Handler) SessionQRSVG(w http.ResponseWriter, r *http.Request) {
+	qr, size, ok := h.sessionQR(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(renderSVG(qr.Bitmap(), size)))
+}
+
+// sessionQR validates the request and encodes the receive URL. The size and
+// level query parameters select the image size in pixels and the error
+// correction level (L, M, Q or H).
+func (h *Handler) sessionQR(w http.ResponseWriter, r *http.Request) (*qrcode.QRCode, int, bool) {
+	size := defaultQRSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minQRSize || n > maxQRSize {
+			writeError(w, http.StatusBadRequest, "INVALID_SIZE",
+				fmt.Sprintf("size must be between %d and %d", minQRSize, maxQRSize))
+			return nil, 0, false
+		}
+		size = n
+	}
+
+	level := qrcode.Medium
+	if v := r.URL.Query().Get("level"); v != "" {
+		l, ok := qrLevels[strings.ToUpper(v)]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "INVALID_LEVEL", "level must be one of L, M, Q, H")
+			return nil, 0, false
+		}
+		level = l
+	}
+
+	sess, ok := h.lookupSession(w, chi.URLParam(r, "code"))
+	if !ok {
+		return nil, 0, false
+	}
+
+	qr, err := qrcode.New(receiveURL(h.publicURL, r, sess.Code), level)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "QR_FAILED", "Failed to render QR code")
+		return nil, 0, false
+	}
+
+	return qr, size, true
+}
+
+// receiveURL builds the link a receiver opens to download the file, which
+// the frontend answers with the receive tab and the code filled in. Without
+// a configured public URL it is derived from the request. X-Forwarded-Proto
+// only survives the realip middleware on requests from trusted proxies.
+func receiveURL(publicURL string, r *http.Request, code string) string {
+	base := strings.TrimRight(publicURL, "/")
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		if proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + "/receive/" + url.PathEscape(code)
+}
+
+// renderSVG draws bitmap as an SVG of size pixels, with one path covering all
+// dark modules.
+func renderSVG(bitmap [][]bool, size int) string {
+	n := len(bitmap)
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		size, size, n, n, n, n, path.String())
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"takedat/internal/config"
+	"takedat/internal/session"
+
+	"github.com/makiuchi-d/gozxing"
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// decodeQR reads the text encoded in a QR code image.
+func decodeQR(t *testing.T, img image.Image) string {
+	t.Helper()
+
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := zxqrcode.NewQRCodeReader().Decode(bmp, nil)
+	if err != nil {
+		t.Fatalf("decode QR code: %v", err)
+	}
+	return result.GetText()
+}
+
+var (
+	svgViewBox = regexp.MustCompile(`viewBox="0 0 (\d+) (\d+)"`)
+	svgModule  = regexp.MustCompile(`M(\d+) (\d+)h1v1h-1z`)
+)
+
+// rasterizeSVG draws the modules of an SVG from renderSVG, with a quiet
+// zone, so the decoder can read it.
+func rasterizeSVG(t *testing.T, svg string) image.Image {
+	t.Helper()
+
+	m := svgViewBox.FindStringSubmatch(svg)
+	if m == nil {
+		t.Fatalf("no viewBox in %.100s", svg)
+	}
+	n, _ := strconv.Atoi(m[1])
+
+	const scale, quiet = 4, 4
+	size := (n + 2*quiet) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for _, mod := range svgModule.FindAllStringSubmatch(svg, -1) {
+		x, _ := strconv.Atoi(mod[1])
+		y, _ := strconv.Atoi(mod[2])
+		for dy := 0; dy < scale; dy++ {
+			for dx := 0; dx < scale; dx++ {
+				img.SetGray((x+quiet)*scale+dx, (y+quiet)*scale+dy, color.Gray{})
+			}
+		}
+	}
+	return img
+}
+
+func TestSessionQR(t *testing.T) {
+	tests := []struct {
+		name      string
+		publicURL string
+		proto     string // X-Forwarded-Proto from an untrusted client
+		format    string
+		want      string
+	}{
+		{"png from request", "", "", "png", "http://takedat.example/receive/"},
+		{"svg from request", "", "", "svg", "http://takedat.example/receive/"},
+		{"public URL", "https://files.example.com/", "", "png", "https://files.example.com/receive/"},
+		{"forwarded proto ignored", "", "https", "svg", "http://takedat.example/receive/"},
+	}
+	for _, tt := range tests {
+		h := newHandlerTest(t, &config.Config{PublicURL: tt.publicURL}, session.Options{})
+		created := h.create(t, `{"fileName":"a.txt","fileSize":10}`)
+
+		req := httptest.NewRequest(http.MethodGet, "http://takedat.example/api/sessions/"+created.Code+"/qr."+tt.format, nil)
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		rec := httptest.NewRecorder()
+		h.router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, body %s", tt.name, rec.Code, rec.Body)
+			continue
+		}
+
+		var img image.Image
+		switch tt.format {
+		case "png":
+			if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+				t.Errorf("%s: Content-Type = %q", tt.name, ct)
+			}
+			var err error
+			if img, err = png.Decode(bytes.NewReader(rec.Body.Bytes())); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if b := img.Bounds(); b.Dx() != defaultQRSize {
+				t.Errorf("%s: width = %d, want %d", tt.name, b.Dx(), defaultQRSize)
+			}
+		case "svg":
+			if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml" {
+				t.Errorf("%s: Content-Type = %q", tt.name, ct)
+			}
+			img = rasterizeSVG(t, rec.Body.String())
+		}
+
+		if got, want := decodeQR(t, img), tt.want+created.Code; got != want {
+			t.Errorf("%s: QR code = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestSessionQRErrors(t *testing.T) {
+	h := newHandlerTest(t, &config.Config{}, session.Options{})
+	created := h.create(t, `{"fileName":"a.txt","fileSize":10}`)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		code   string
+	}{
+		{"size", "/qr.png?size=512", http.StatusOK, ""},
+		{"level", "/qr.svg?level=h", http.StatusOK, ""},
+		{"size too small", "/qr.png?size=10", http.StatusBadRequest, "INVALID_SIZE"},
+		{"size too large", "/qr.svg?size=4096", http.StatusBadRequest, "INVALID_SIZE"},
+		{"size not a number", "/qr.png?size=big", http.StatusBadRequest, "INVALID_SIZE"},
+		{"bad level", "/qr.png?level=X", http.StatusBadRequest, "INVALID_LEVEL"},
+	}
+	for _, tt := range tests {
+		var resp ErrorResponse
+		var out any
+		if tt.code != "" {
+			out = &resp
+		}
+		status := h.do(t, http.MethodGet, "/api/sessions/"+created.Code+tt.target, "", nil, out)
+		if status != tt.status || resp.Code != tt.code {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, status, resp.Code, tt.status, tt.code)
+		}
+	}
+
+	var resp ErrorResponse
+	if status := h.do(t, http.MethodGet, "/api/sessions/ZZZ-ZZZ/qr.png", "", nil, &resp); status != http.StatusNotFound || resp.Code != "SESSION_NOT_FOUND" {
+		t.Errorf("unknown session: got %d %s, want 404 SESSION_NOT_FOUND", status, resp.Code)
+	}
+}
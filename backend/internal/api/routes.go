@@ -1,12 +1,16 @@
 package api
 
 import (
+	"io/fs"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
+	"takedat/internal/auth"
 	"takedat/internal/config"
+	"takedat/internal/origin"
+	"takedat/internal/realip"
 	"takedat/internal/session"
+	"takedat/internal/turn"
+	"takedat/internal/web"
 	"takedat/internal/websocket"
 
 	"github.com/go-chi/chi/v5"
@@ -14,66 +18,99 @@ import (
 	"github.com/go-chi/cors"
 )
 
-func NewRouter(cfg *config.Config, sessions *session.Manager, hub *websocket.Hub) *chi.Mux {
+// RouterConfig holds the configuration and services the router serves.
+type RouterConfig struct {
+	Config       *config.Config
+	Sessions     *session.Manager
+	Reservations *session.Reservations
+	// TURN issues relay credentials; nil when the TURN server is disabled.
+	TURN *turn.Server
+	Hub  *websocket.Hub
+	// Keys and Verifier are nil when API keys or user tokens are disabled.
+	Keys     *auth.Keystore
+	Verifier *auth.Verifier
+	// Origins lists the browser origins allowed to call the API besides
+	// our own. Nil allows no cross-origin browser requests.
+	Origins *origin.Matcher
+	// Proxies resolves client addresses behind trusted reverse proxies.
+	// Nil trusts no forwarding headers.
+	Proxies *realip.Resolver
+}
+
+// NewRouter builds the HTTP routes.
+func NewRouter(rc RouterConfig) *chi.Mux {
+	cfg, keys := rc.Config, rc.Keys
 	r := chi.NewRouter()
 
 	// Middleware
+	r.Use(rc.Proxies.Handler)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.RealIP)
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowOriginFunc: func(r *http.Request, o string) bool {
+			return rc.Origins.Allowed(o)
+		},
 		AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Content-Type"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Owner-Token"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
+	r.Use(authenticate(keys, rc.Verifier))
+
+	handler := NewHandler(cfg, rc.Sessions, rc.Reservations, rc.TURN, rc.Hub)
 
-	handler := NewHandler(sessions)
+	// Metrics need an admin API key once keys are enabled; users never
+	// get the admin scope. Without keys they are only served if
+	// public_metrics allows it.
+	metrics := requireScope(keys != nil, auth.ScopeAdmin)
+	if keys == nil && !cfg.PublicMetrics {
+		metrics = notFound
+	}
+	creator := requireScope(cfg.RequireAPIKey || cfg.RequireSenderAuth, auth.ScopeCreate)
 
-	// REST API routes
+	// REST API routes. Session details and QR codes are open to anyone
+	// holding the code, as the receive page shows them before connecting;
+	// the recipient allowlist still applies to the details. Joining, and
+	// the ICE servers and TURN credentials for it, are checked per role by
+	// the hub like the WebSocket upgrade.
 	r.Route("/api", func(r chi.Router) {
 		r.Get("/health", handler.Health)
-		r.Post("/sessions", handler.CreateSession)
+		r.With(metrics).Get("/metrics", handler.Metrics)
+		r.With(creator).Post("/sessions", handler.CreateSession)
 		r.Get("/sessions/{code}", handler.GetSession)
-		r.Delete("/sessions/{code}", handler.DeleteSession)
+		r.Get("/sessions/{code}/qr.png", handler.SessionQRPNG)
+		r.Get("/sessions/{code}/qr.svg", handler.SessionQRSVG)
+		r.Get("/sessions/{code}/ice-servers", handler.ICEServers)
+		r.With(creator).Delete("/sessions/{code}", handler.DeleteSession)
+		r.With(creator).Post("/reservations", handler.ReserveCode)
+		r.With(creator).Delete("/reservations/{code}", handler.ReleaseCode)
 	})
 
-	// WebSocket route
-	r.Get("/ws/{code}", hub.HandleWebSocket)
+	// WebSocket route, over HTTP/1.1 Upgrade or HTTP/2 extended CONNECT
+	r.Get("/ws/{code}", rc.Hub.HandleWebSocket)
+	r.Method(http.MethodConnect, "/ws/{code}", http.HandlerFunc(rc.Hub.HandleWebSocket))
 
-	// Serve static files if STATIC_DIR is set
-	if staticDir := cfg.StaticDir; staticDir != "" {
-		fileServer(r, staticDir)
+	// Serve the frontend: from STATIC_DIR during development, otherwise
+	// the build embedded in the binary
+	if frontend := frontendFS(cfg.StaticDir); frontend != nil {
+		files := newStaticFiles(frontend)
+		r.Get("/*", files.ServeHTTP)
+		r.Head("/*", files.ServeHTTP)
 	}
 
 	return r
 }
 
-// fileServer serves static files and falls back to index.html for SPA routing
-func fileServer(r chi.Router, staticDir string) {
-	fs := http.Dir(staticDir)
-
-	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
-
-		// Try to serve the file directly
-		filePath := filepath.Join(staticDir, path)
-		if _, err := os.Stat(filePath); err == nil {
-			// Check if it's a file (not directory)
-			if info, _ := os.Stat(filePath); !info.IsDir() {
-				http.FileServer(fs).ServeHTTP(w, r)
-				return
-			}
+// frontendFS returns the frontend build to serve: staticDir when set,
+// otherwise the embedded build, or nil if there is none.
+func frontendFS(staticDir string) fs.FS {
+	if staticDir != "" {
+		dir, err := newDirFS(staticDir)
+		if err != nil {
+			log.Printf("Not serving static files: %v", err)
+			return nil
 		}
-
-		// For paths that look like assets, return 404
-		if strings.HasPrefix(path, "/assets/") {
-			http.NotFound(w, r)
-			return
-		}
-
-		// Otherwise serve index.html for SPA routing
-		http.ServeFile(w, r, filepath.Join(staticDir, "index.html"))
-	})
+		return dir
+	}
+	return web.FS()
 }
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"takedat/internal/config"
+	"takedat/internal/origin"
+	"takedat/internal/realip"
+	"takedat/internal/session"
+	"takedat/internal/websocket"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+func newOriginTestServer(t *testing.T) (*httptest.Server, *session.Manager) {
+	t.Helper()
+
+	origins, err := origin.New([]string{"https://app.example.com", "https://*.corp.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := session.NewManager(session.Options{TTL: time.Minute})
+	hub := websocket.NewHub(sessions, websocket.Options{MaxStreams: 1, Origins: origins})
+	go hub.Run()
+
+	srv := httptest.NewServer(NewRouter(RouterConfig{Config: &config.Config{}, Sessions: sessions, Hub: hub, Origins: origins}))
+	t.Cleanup(srv.Close)
+	return srv, sessions
+}
+
+func TestCORSOrigins(t *testing.T) {
+	srv, _ := newOriginTestServer(t)
+
+	tests := []struct {
+		origin string
+		want   string
+	}{
+		{"https://app.example.com", "https://app.example.com"},
+		{"https://files.corp.example", "https://files.corp.example"},
+		{"https://evil.example", ""},
+		{"http://app.example.com", ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodOptions, srv.URL+"/api/sessions", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("origin %q: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestCORSWithoutOrigins(t *testing.T) {
+	sessions := session.NewManager(session.Options{TTL: time.Minute})
+	hub := websocket.NewHub(sessions, websocket.Options{})
+	srv := httptest.NewServer(NewRouter(RouterConfig{Config: &config.Config{}, Sessions: sessions, Hub: hub}))
+	t.Cleanup(srv.Close)
+
+	req, _ := http.NewRequest(http.MethodOptions, srv.URL+"/api/sessions", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusInternalServerError {
+		t.Fatal("preflight failed without an origin matcher")
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+	}
+}
+
+func TestWebSocketOrigins(t *testing.T) {
+	srv, sessions := newOriginTestServer(t)
+
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{srv.URL, true},
+		{"https://app.example.com", true},
+		{"https://evil.example", false},
+		{"https://corp.example", false},
+	}
+	for _, tt := range tests {
+		sess, err := sessions.Create(session.CreateParams{FileName: "a.txt", FileSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/" + sess.Code + "?role=sender"
+
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+
+		conn, resp, err := gorilla.DefaultDialer.Dial(wsURL, header)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("origin %q: dial failed: %v", tt.origin, err)
+				continue
+			}
+			conn.Close()
+			continue
+		}
+
+		if err == nil {
+			conn.Close()
+			t.Errorf("origin %q: dial succeeded, want rejection", tt.origin)
+			continue
+		}
+		if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("origin %q: got %v, want 403", tt.origin, err)
+		}
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	proxies, err := realip.New([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.NewManager(session.Options{TTL: time.Minute, Quotas: session.Quotas{MaxSessionsPerIP: 1}})
+	hub := websocket.NewHub(sessions, websocket.Options{})
+	h := &handlerTest{router: NewRouter(RouterConfig{Config: &config.Config{}, Sessions: sessions, Hub: hub, Proxies: proxies}), sessions: sessions}
+
+	create := func(remote, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/sessions", strings.NewReader(`{"fileName":"a.txt","fileSize":1}`))
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		h.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name         string
+		remote       string
+		forwardedFor string
+		status       int
+	}{
+		{"client", "192.0.2.1:1234", "203.0.113.1", http.StatusCreated},
+		// A client cannot claim to be someone else to get a fresh quota.
+		{"spoofed header", "192.0.2.1:1234", "203.0.113.2", http.StatusTooManyRequests},
+		// Clients behind the proxy are told apart by the header.
+		{"via proxy", "10.0.0.1:1234", "203.0.113.3", http.StatusCreated},
+		{"via proxy again", "10.0.0.2:1234", "203.0.113.3", http.StatusTooManyRequests},
+		{"another client via proxy", "10.0.0.1:1234", "203.0.113.4", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if status := create(tt.remote, tt.forwardedFor); status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+		}
+	}
+}
+
+func TestMetricsAccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    *config.Config
+		status int
+	}{
+		{"off by default", &config.Config{}, http.StatusNotFound},
+		{"public", &config.Config{PublicMetrics: true}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		h := newHandlerTest(t, tt.cfg, session.Options{})
+		if status := h.do(t, http.MethodGet, "/api/metrics", "", nil, nil); status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+		}
+	}
+}
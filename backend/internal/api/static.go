@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache policies. Vite puts a content hash in every file name under
+// /assets/, so those never change; everything else, index.html in
+// particular, must be revalidated to pick up new deployments.
+const (
+	cacheImmutable   = "public, max-age=31536000, immutable"
+	cacheRevalidate  = "no-cache"
+	hashedAssetsPath = "assets/"
+)
+
+// encodings are the precompressed variants looked for next to each file,
+// in order of preference.
+var encodings = []struct {
+	name string // Content-Encoding token
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+var errNotFile = errors.New("not a regular file")
+
+// staticFiles serves the frontend build and falls back to index.html for
+// client-side routes. Files are served with strong ETags, and with a .br
+// or .gz variant instead when one exists and the client accepts it.
+//
+// Only regular files reachable by a clean path without dot segments are
+// served, so requests cannot reach outside the build, into dotfiles or
+// into directory listings. Each file is opened once and checked through
+// its open handle.
+type staticFiles struct {
+	fsys  fs.FS
+	etags sync.Map // etagKey -> string
+}
+
+type etagKey struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func newStaticFiles(fsys fs.FS) *staticFiles {
+	return &staticFiles{fsys: fsys}
+}
+
+func (s *staticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	setSecurityHeaders(w, r)
+
+	name, ok := staticName(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, info, err := s.open(name)
+	// Missing assets are real 404s; any other missing path is a
+	// client-side route for the SPA.
+	if errors.Is(err, fs.ErrNotExist) && !strings.HasPrefix(name, hashedAssetsPath) {
+		name = "index.html"
+		f, info, err = s.open(name)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errNotFile) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+
+	if strings.HasPrefix(name, hashedAssetsPath) {
+		w.Header().Set("Cache-Control", cacheImmutable)
+	} else {
+		w.Header().Set("Cache-Control", cacheRevalidate)
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	served, servedInfo := f, info
+	for _, enc := range encodings {
+		if !acceptsEncoding(r, enc.name) {
+			continue
+		}
+		vf, vinfo, err := s.open(name + enc.ext)
+		if err != nil {
+			continue
+		}
+		defer vf.Close()
+		served, servedInfo = vf, vinfo
+		name += enc.ext
+		w.Header().Set("Content-Encoding", enc.name)
+		break
+	}
+
+	if err := s.serveFile(w, r, name, served, servedInfo); err != nil {
+		w.Header().Del("Content-Encoding")
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+// staticName maps a URL path to a file name in the build. It refuses
+// paths with hidden segments, such as /.env or /.git/config.
+func staticName(urlPath string) (string, bool) {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return "index.html", true
+	}
+	if !fs.ValidPath(name) || strings.ContainsAny(name, "\\\x00") {
+		return "", false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// open opens name and checks through the handle that it is a regular
+// file.
+func (s *staticFiles) open(name string) (fs.File, fs.FileInfo, error) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, errNotFile
+	}
+	return f, info, nil
+}
+
+// serveFile writes the file's contents, answering conditional and range
+// requests.
+func (s *staticFiles) serveFile(w http.ResponseWriter, r *http.Request, name string, f fs.File, info fs.FileInfo) error {
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+
+	etag, err := s.etag(name, info, content)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", etag)
+
+	// Embedded files have no modification time; ServeContent then leaves
+	// Last-Modified out and relies on the ETag.
+	http.ServeContent(w, r, name, info.ModTime(), content)
+	return nil
+}
+
+// etag returns a strong ETag for the file, hashing its contents the first
+// time each version of it is served.
+func (s *staticFiles) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	key := etagKey{name: name, size: info.Size(), modTime: info.ModTime()}
+	if etag, ok := s.etags.Load(key); ok {
+		return etag.(string), nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	s.etags.Store(key, etag)
+	return etag, nil
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding allows
+// coding. Quality values other than zero are treated alike.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(header, ",") {
+			token, params, _ := strings.Cut(item, ";")
+			if !strings.EqualFold(strings.TrimSpace(token), coding) {
+				continue
+			}
+			q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !ok {
+				return true
+			}
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+	}
+	return false
+}
+
+// setSecurityHeaders locks the app down to its own origin. Scripts and
+// styles come only from the build, the app talks only to this server over
+// HTTP and WebSockets, and it may not be framed. Share codes appear in
+// receive URLs, so no Referer is sent when leaving the app.
+func setSecurityHeaders(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Content-Security-Policy", "default-src 'self'; "+
+		"script-src 'self'; "+
+		"style-src 'self'; "+
+		"img-src 'self' data: blob:; "+
+		"connect-src 'self' ws://"+r.Host+" wss://"+r.Host+"; "+
+		"object-src 'none'; "+
+		"base-uri 'self'; "+
+		"form-action 'self'; "+
+		"frame-ancestors 'none'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("X-Frame-Options", "DENY")
+}
+
+// dirFS serves a directory on disk. Unlike os.DirFS it refuses symlinks
+// that lead outside the directory.
+type dirFS struct {
+	root string // absolute, symlinks resolved
+}
+
+func newDirFS(dir string) (*dirFS, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFS{root: root}, nil
+}
+
+func (d *dirFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) || strings.ContainsAny(name, "\\:") {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Join(d.root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if resolved != d.root && !strings.HasPrefix(resolved, d.root+string(filepath.Separator)) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return os.Open(resolved)
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const (
+	testIndex = "<!doctype html><title>app</title>"
+	testAsset = "console.log('app')"
+)
+
+func testBuild() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":              {Data: []byte(testIndex)},
+		"vite.svg":                {Data: []byte("<svg/>")},
+		"assets/app-1a2b3c.js":    {Data: []byte(testAsset)},
+		"assets/app-1a2b3c.js.gz": {Data: []byte("gzip bytes")},
+		"assets/app-1a2b3c.js.br": {Data: []byte("brotli bytes")},
+		".env":                    {Data: []byte("SECRET=1")},
+		".git/config":             {Data: []byte("[core]")},
+		"icons/logo.svg":          {Data: []byte("<svg/>")},
+	}
+}
+
+func serveStatic(t *testing.T, h http.Handler, target string, header http.Header) (*http.Response, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.example"+target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestStaticFiles(t *testing.T) {
+	h := newStaticFiles(testBuild())
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		body   string
+		cache  string
+	}{
+		{"root", "/", http.StatusOK, testIndex, cacheRevalidate},
+		{"index", "/index.html", http.StatusOK, testIndex, cacheRevalidate},
+		{"spa route", "/receive/ABC-DEF", http.StatusOK, testIndex, cacheRevalidate},
+		{"public file", "/vite.svg", http.StatusOK, "<svg/>", cacheRevalidate},
+		{"hashed asset", "/assets/app-1a2b3c.js", http.StatusOK, testAsset, cacheImmutable},
+		{"missing asset", "/assets/missing.js", http.StatusNotFound, "", ""},
+		{"asset directory", "/assets/", http.StatusNotFound, "", ""},
+		{"directory", "/icons", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		resp, body := serveStatic(t, h, tt.target, nil)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if body != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.name, body, tt.body)
+		}
+		if got := resp.Header.Get("Cache-Control"); got != tt.cache {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.name, got, tt.cache)
+		}
+	}
+}
+
+func TestStaticFilesTraversal(t *testing.T) {
+	h := newStaticFiles(testBuild())
+
+	for _, target := range []string{
+		"/.env",
+		"/.git/config",
+		"/%2eenv",
+		"/assets/../.env",
+		"/assets/%2e%2e/.env",
+		"/..%2f.env",
+		"/assets/..%5c.env",
+	} {
+		resp, body := serveStatic(t, h, target, nil)
+		if strings.Contains(body, "SECRET") || strings.Contains(body, "[core]") {
+			t.Errorf("%s: leaked a hidden file", target)
+		}
+		if resp.StatusCode == http.StatusOK && body != testIndex {
+			t.Errorf("%s: served %q", target, body)
+		}
+	}
+
+	// Dot-dot segments are cleaned against the root rather than escaping
+	// it, which lands on a client-side route.
+	resp, body := serveStatic(t, h, "/../../etc/passwd", nil)
+	if resp.StatusCode != http.StatusOK || body != testIndex {
+		t.Errorf("/../../etc/passwd: got %d %q, want the SPA index", resp.StatusCode, body)
+	}
+}
+
+func TestStaticFilesSymlinkEscape(t *testing.T) {
+	base := t.TempDir()
+	secret := filepath.Join(base, "secret.txt")
+	if err := os.WriteFile(secret, []byte("top secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	build := filepath.Join(base, "dist")
+	if err := os.MkdirAll(filepath.Join(build, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(build, "index.html"), []byte(testIndex), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(build, "assets", "leak.js")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	dir, err := newDirFS(build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newStaticFiles(dir)
+
+	resp, body := serveStatic(t, h, "/assets/leak.js", nil)
+	if resp.StatusCode != http.StatusNotFound || strings.Contains(body, "top secret") {
+		t.Errorf("symlink out of the build: got %d %q, want 404", resp.StatusCode, body)
+	}
+
+	resp, body = serveStatic(t, h, "/", nil)
+	if resp.StatusCode != http.StatusOK || body != testIndex {
+		t.Errorf("index from disk: got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestStaticFilesSecurityHeaders(t *testing.T) {
+	h := newStaticFiles(testBuild())
+
+	for _, target := range []string{"/", "/assets/app-1a2b3c.js", "/assets/missing.js"} {
+		resp, _ := serveStatic(t, h, target, nil)
+
+		csp := resp.Header.Get("Content-Security-Policy")
+		for _, directive := range []string{"default-src 'self'", "frame-ancestors 'none'", "wss://app.example"} {
+			if !strings.Contains(csp, directive) {
+				t.Errorf("%s: CSP %q lacks %q", target, csp, directive)
+			}
+		}
+		if got := resp.Header.Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("%s: X-Content-Type-Options = %q", target, got)
+		}
+		if got := resp.Header.Get("Referrer-Policy"); got != "no-referrer" {
+			t.Errorf("%s: Referrer-Policy = %q", target, got)
+		}
+	}
+}
+
+func TestStaticFilesPrecompressed(t *testing.T) {
+	h := newStaticFiles(testBuild())
+
+	tests := []struct {
+		accept   string
+		encoding string
+		body     string
+	}{
+		{"", "", testAsset},
+		{"gzip", "gzip", "gzip bytes"},
+		{"gzip, deflate, br", "br", "brotli bytes"},
+		{"br;q=0, gzip;q=0.5", "gzip", "gzip bytes"},
+		{"identity", "", testAsset},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.accept != "" {
+			header.Set("Accept-Encoding", tt.accept)
+		}
+		resp, body := serveStatic(t, h, "/assets/app-1a2b3c.js", header)
+
+		if got := resp.Header.Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", tt.accept, got, tt.encoding)
+		}
+		if body != tt.body {
+			t.Errorf("Accept-Encoding %q: body = %q, want %q", tt.accept, body, tt.body)
+		}
+		if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/javascript") {
+			t.Errorf("Accept-Encoding %q: Content-Type = %q", tt.accept, got)
+		}
+		if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary = %q", tt.accept, got)
+		}
+	}
+}
+
+func TestStaticFilesETag(t *testing.T) {
+	h := newStaticFiles(testBuild())
+
+	resp, _ := serveStatic(t, h, "/assets/app-1a2b3c.js", nil)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	resp, body := serveStatic(t, h, "/assets/app-1a2b3c.js", http.Header{"If-None-Match": {etag}})
+	if resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Errorf("If-None-Match: got %d %q, want 304", resp.StatusCode, body)
+	}
+
+	resp, _ = serveStatic(t, h, "/assets/app-1a2b3c.js", http.Header{"Accept-Encoding": {"gzip"}})
+	if resp.Header.Get("ETag") == etag {
+		t.Error("compressed and identity responses share an ETag")
+	}
+}
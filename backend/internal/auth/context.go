@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type contextKey struct{}
+
+// Principal is whoever a request authenticated as: an API key, a user with
+// a verified token, or neither for anonymous requests.
+type Principal struct {
+	Key  *Key
+	User *Identity
+}
+
+// Anonymous reports whether the request carried no credentials.
+func (p Principal) Anonymous() bool {
+	return p.Key == nil && p.User == nil
+}
+
+// Authorize checks that p may act with scope. API keys are limited to their
+// scopes; users may create and receive but not administer. Anonymous
+// requests pass unless required is set.
+func (p Principal) Authorize(required bool, scope string) error {
+	switch {
+	case p.Key != nil:
+		if !p.Key.HasScope(scope) {
+			return ErrInsufficientScope
+		}
+	case p.User != nil:
+		if scope == ScopeAdmin {
+			return ErrInsufficientScope
+		}
+	case required:
+		return ErrAuthRequired
+	}
+	return nil
+}
+
+// Subject identifies p for recipient allowlists: the token subject for
+// users, apikey:<id> for API keys and empty for anonymous requests.
+func (p Principal) Subject() string {
+	switch {
+	case p.User != nil:
+		return p.User.Subject
+	case p.Key != nil:
+		return "apikey:" + p.Key.ID
+	}
+	return ""
+}
+
+// Email returns the user's email, if known.
+func (p Principal) Email() string {
+	if p.User != nil {
+		return p.User.Email
+	}
+	return ""
+}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, which is anonymous if
+// there is none.
+func FromContext(ctx context.Context) Principal {
+	p, _ := ctx.Value(contextKey{}).(Principal)
+	return p
+}
+
+// BearerToken extracts the token from an Authorization: Bearer header.
+// Browsers cannot set headers on WebSocket handshakes, so upgrade requests,
+// including HTTP/2 extended CONNECT ones, may pass it in the access_token
+// query parameter instead.
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get(":protocol") == "websocket" {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// IsAPIKey reports whether token looks like one of our API keys rather than
+// a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, keyPrefix)
+}
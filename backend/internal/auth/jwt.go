@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+const (
+	// jwksRefreshInterval is how often keys from a JWKS URL are refetched.
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefresh limits refetches triggered by tokens signed with an
+	// unknown key ID.
+	jwksMinRefresh = time.Minute
+	// tokenLeeway tolerates clock skew between us and the issuer.
+	tokenLeeway = 30 * time.Second
+)
+
+// signingMethods are the JWS algorithms accepted. Symmetric algorithms are
+// left out since the verifier only ever holds public keys.
+var signingMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// OIDCConfig configures JWT verification. Exactly one of JWKSFile and
+// JWKSURL must be set; Issuer and Audience are checked when non-empty.
+type OIDCConfig struct {
+	JWKSFile string
+	JWKSURL  string
+	Issuer   string
+	Audience string
+}
+
+// Identity is the user a verified token belongs to. Email is only set when
+// the issuer has verified it.
+type Identity struct {
+	Subject string
+	Email   string
+	Name    string
+}
+
+type userClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// Verifier checks JWTs issued by an OIDC provider against its JWKS.
+type Verifier struct {
+	cfg       OIDCConfig
+	client    *http.Client
+	parser    *jwt.Parser
+	keys      map[string]crypto.PublicKey // kid -> key
+	fetchedAt time.Time
+	mu        sync.RWMutex
+}
+
+// NewVerifier loads the JWKS described by cfg.
+func NewVerifier(cfg OIDCConfig) (*Verifier, error) {
+	if (cfg.JWKSFile == "") == (cfg.JWKSURL == "") {
+		return nil, errors.New("exactly one of a JWKS file or URL is required")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokenLeeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	v := &Verifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		parser: jwt.NewParser(opts...),
+	}
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Verify checks token's signature and claims and returns its identity.
+func (v *Verifier) Verify(token string) (*Identity, error) {
+	var claims userClaims
+	if _, err := v.parser.ParseWithClaims(token, &claims, v.keyFunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	identity := &Identity{Subject: claims.Subject, Name: claims.Name}
+	// Many providers let users type in any address; only a verified one
+	// may stand for the user.
+	if claims.EmailVerified {
+		identity.Email = claims.Email
+	}
+	return identity, nil
+}
+
+// keyFunc picks the verification key by the token's key ID, refetching the
+// JWKS once if the ID is unknown or the keys are stale.
+func (v *Verifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if key, ok := v.lookup(kid); ok && !v.stale() {
+		return key, nil
+	}
+
+	v.mu.RLock()
+	canRefresh := time.Since(v.fetchedAt) >= jwksMinRefresh
+	v.mu.RUnlock()
+	if canRefresh {
+		if err := v.refresh(); err != nil {
+			log.Printf("Failed to refresh JWKS: %v", err)
+		}
+	}
+
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// lookup returns the key for kid. Tokens without a kid match the only key
+// if there is exactly one.
+func (v *Verifier) lookup(kid string) (crypto.PublicKey, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+func (v *Verifier) stale() bool {
+	if v.cfg.JWKSURL == "" {
+		return false
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return time.Since(v.fetchedAt) > jwksRefreshInterval
+}
+
+// refresh reloads the JWKS from its file or URL.
+func (v *Verifier) refresh() error {
+	var data []byte
+	var err error
+	if v.cfg.JWKSFile != "" {
+		data, err = os.ReadFile(v.cfg.JWKSFile)
+	} else {
+		data, err = v.fetch(v.cfg.JWKSURL)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetchedAt = time.Now()
+
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	return nil
+}
+
+func (v *Verifier) fetch(url string) ([]byte, error) {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWKS: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes the signing keys in a JSON Web Key Set. Keys of unknown
+// types are skipped so providers can add new ones without breaking us.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("parse JWKS key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
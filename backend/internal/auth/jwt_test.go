@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://issuer.example"
+	testAudience = "takedat"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// jwks renders the public halves of keys, by key ID, as a JWKS document.
+func jwks(t *testing.T, keys map[string]*rsa.PrivateKey) []byte {
+	t.Helper()
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// jwksServer serves a JWKS the test can swap out.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	body    []byte
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, body []byte) *jwksServer {
+	s := &jwksServer{body: body}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(s.body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body = body
+}
+
+func (s *jwksServer) fetched() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// validClaims returns claims the test verifier accepts, with overrides
+// applied; a nil override removes the claim.
+func validClaims(overrides jwt.MapClaims) jwt.MapClaims {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": "user-1",
+		"iss": testIssuer,
+		"aud": testAudience,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+	return claims
+}
+
+func TestVerify(t *testing.T) {
+	key, other := newRSAKey(t), newRSAKey(t)
+	srv := newJWKSServer(t, jwks(t, map[string]*rsa.PrivateKey{"k1": key}))
+	v, err := NewVerifier(OIDCConfig{JWKSURL: srv.URL, Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name  string
+		token string
+		want  *Identity // nil if the token is refused
+	}{
+		{"valid", sign(t, key, "k1", validClaims(nil)), &Identity{Subject: "user-1"}},
+		{"no key ID", sign(t, key, "", validClaims(nil)), &Identity{Subject: "user-1"}},
+		{"verified email", sign(t, key, "k1", validClaims(jwt.MapClaims{
+			"email": "a@example.com", "email_verified": true, "name": "A",
+		})), &Identity{Subject: "user-1", Email: "a@example.com", Name: "A"}},
+		{"unverified email", sign(t, key, "k1", validClaims(jwt.MapClaims{
+			"email": "a@example.com", "email_verified": false,
+		})), &Identity{Subject: "user-1"}},
+		{"email without verification", sign(t, key, "k1", validClaims(jwt.MapClaims{
+			"email": "a@example.com",
+		})), &Identity{Subject: "user-1"}},
+		{"expiry within leeway", sign(t, key, "k1", validClaims(jwt.MapClaims{
+			"exp": now.Add(-tokenLeeway / 2).Unix(),
+		})), &Identity{Subject: "user-1"}},
+
+		{"expired", sign(t, key, "k1", validClaims(jwt.MapClaims{
+			"exp": now.Add(-tokenLeeway - time.Minute).Unix(),
+		})), nil},
+		{"no expiry", sign(t, key, "k1", validClaims(jwt.MapClaims{"exp": nil})), nil},
+		{"issued in the future", sign(t, key, "k1", validClaims(jwt.MapClaims{
+			"iat": now.Add(time.Hour).Unix(),
+		})), nil},
+		{"wrong audience", sign(t, key, "k1", validClaims(jwt.MapClaims{"aud": "someone-else"})), nil},
+		{"no audience", sign(t, key, "k1", validClaims(jwt.MapClaims{"aud": nil})), nil},
+		{"wrong issuer", sign(t, key, "k1", validClaims(jwt.MapClaims{"iss": "https://evil.example"})), nil},
+		{"no subject", sign(t, key, "k1", validClaims(jwt.MapClaims{"sub": nil})), nil},
+		{"wrong key", sign(t, other, "k1", validClaims(nil)), nil},
+		{"unknown key ID", sign(t, other, "k2", validClaims(nil)), nil},
+		{"garbage", "not.a.token", nil},
+	}
+	for _, tt := range tests {
+		got, err := v.Verify(tt.token)
+		if tt.want == nil {
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("%s: err = %v, want ErrInvalidToken", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if *got != *tt.want {
+			t.Errorf("%s: identity = %+v, want %+v", tt.name, *got, *tt.want)
+		}
+	}
+}
+
+func TestVerifySymmetric(t *testing.T) {
+	key := newRSAKey(t)
+	srv := newJWKSServer(t, jwks(t, map[string]*rsa.PrivateKey{"k1": key}))
+	v, err := NewVerifier(OIDCConfig{JWKSURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An HMAC token keyed with the public modulus must not pass for one
+	// signed by the RSA key.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims(nil))
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(key.N.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(signed); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("HS256 token: err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	old, rotated := newRSAKey(t), newRSAKey(t)
+	srv := newJWKSServer(t, jwks(t, map[string]*rsa.PrivateKey{"old": old}))
+	v, err := NewVerifier(OIDCConfig{JWKSURL: srv.URL, Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken := sign(t, old, "old", validClaims(nil))
+	newToken := sign(t, rotated, "new", validClaims(nil))
+
+	// The provider publishes the new key before it signs with it.
+	srv.set(jwks(t, map[string]*rsa.PrivateKey{"old": old, "new": rotated}))
+
+	// Unknown key IDs refetch at most once per jwksMinRefresh.
+	if _, err := v.Verify(newToken); err == nil {
+		t.Error("new key accepted before the JWKS could be refetched")
+	}
+	if n := srv.fetched(); n != 1 {
+		t.Errorf("fetches = %d, want 1", n)
+	}
+
+	v.mu.Lock()
+	v.fetchedAt = v.fetchedAt.Add(-jwksMinRefresh)
+	v.mu.Unlock()
+	for _, token := range []string{newToken, oldToken} {
+		if _, err := v.Verify(token); err != nil {
+			t.Errorf("during rotation: %v", err)
+		}
+	}
+	if n := srv.fetched(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+
+	// Once the old key is retired, its tokens are refused after the
+	// keys go stale.
+	srv.set(jwks(t, map[string]*rsa.PrivateKey{"new": rotated}))
+	v.mu.Lock()
+	v.fetchedAt = v.fetchedAt.Add(-jwksRefreshInterval - time.Second)
+	v.mu.Unlock()
+	if _, err := v.Verify(oldToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("retired key: err = %v, want ErrInvalidToken", err)
+	}
+	if _, err := v.Verify(newToken); err != nil {
+		t.Errorf("after rotation: %v", err)
+	}
+}
+
+func TestVerifyJWKSFile(t *testing.T) {
+	key := newRSAKey(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks(t, map[string]*rsa.PrivateKey{"k1": key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerifier(OIDCConfig{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(sign(t, key, "k1", validClaims(nil))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewVerifierInvalid(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	empty := newJWKSServer(t, []byte(`{"keys":[{"kty":"RSA","kid":"enc","use":"enc"}]}`))
+	garbage := newJWKSServer(t, []byte(`not json`))
+
+	tests := []struct {
+		name string
+		cfg  OIDCConfig
+	}{
+		{"no source", OIDCConfig{}},
+		{"both sources", OIDCConfig{JWKSFile: "jwks.json", JWKSURL: empty.URL}},
+		{"missing file", OIDCConfig{JWKSFile: filepath.Join(t.TempDir(), "missing.json")}},
+		{"server error", OIDCConfig{JWKSURL: failing.URL}},
+		{"no signing keys", OIDCConfig{JWKSURL: empty.URL}},
+		{"not JSON", OIDCConfig{JWKSURL: garbage.URL}},
+	}
+	for _, tt := range tests {
+		if _, err := NewVerifier(tt.cfg); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
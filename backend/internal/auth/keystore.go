@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidKey        = errors.New("invalid API key")
+	ErrKeyNotFound       = errors.New("API key not found")
+	ErrAuthRequired      = errors.New("authentication required")
+	ErrInsufficientScope = errors.New("API key lacks required scope")
+	ErrInvalidScope      = errors.New("invalid scope")
+)
+
+// Scopes an API key may be granted. Admin implies every other scope.
+const (
+	ScopeCreate  = "create"  // create sessions and reserve codes
+	ScopeReceive = "receive" // join sessions as a receiver
+	ScopeAdmin   = "admin"   // server metrics and everything above
+)
+
+var knownScopes = map[string]bool{
+	ScopeCreate:  true,
+	ScopeReceive: true,
+	ScopeAdmin:   true,
+}
+
+// keyPrefix marks takedat API keys so they are easy to spot in logs and
+// secret scanners.
+const keyPrefix = "tdk_"
+
+// Key is a long-lived API key. Only the SHA-256 hash of the key is stored;
+// the key itself is shown once when it is created.
+type Key struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// HasScope reports whether k grants scope.
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// keystoreCheckInterval is how often authentication looks at the keystore
+// file for changes made by another process.
+const keystoreCheckInterval = 5 * time.Second
+
+// Keystore holds API keys in a JSON file. The file is re-read when it
+// changes on disk, so keys created or revoked with the keys subcommand take
+// effect within keystoreCheckInterval without restarting the server.
+type Keystore struct {
+	byID    map[string]*Key
+	path    string
+	info    os.FileInfo // of the file last read or written, nil if none
+	checked time.Time   // when refresh last looked at the file
+	mu      sync.RWMutex
+}
+
+// NewKeystore loads the keystore at path. A missing file is an empty
+// keystore.
+func NewKeystore(path string) (*Keystore, error) {
+	if path == "" {
+		return nil, errors.New("keystore path is required")
+	}
+
+	ks := &Keystore{
+		byID: make(map[string]*Key),
+		path: path,
+	}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// load reads the keystore file if it changed since the last read. Callers
+// must hold ks.mu for writing.
+func (ks *Keystore) load() error {
+	info, err := os.Stat(ks.path)
+	if errors.Is(err, os.ErrNotExist) {
+		ks.byID = make(map[string]*Key)
+		ks.info = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !changed(ks.info, info) {
+		return nil
+	}
+
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+
+	var list []*Key
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parse keystore: %w", err)
+	}
+
+	byID := make(map[string]*Key, len(list))
+	for _, key := range list {
+		byID[key.ID] = key
+	}
+	ks.byID = byID
+	ks.info = info
+	return nil
+}
+
+// changed reports whether the file now described by info differs from the
+// one last seen. Modification times alone miss writes made within the
+// file system's timestamp granularity, but every save replaces the file,
+// so a different file or size gives those away.
+func changed(last, info os.FileInfo) bool {
+	return last == nil ||
+		!os.SameFile(last, info) ||
+		!info.ModTime().Equal(last.ModTime()) ||
+		info.Size() != last.Size()
+}
+
+// refresh picks up changes made to the file by another process, looking at
+// the file at most once per keystoreCheckInterval. Until then callers only
+// share the read lock.
+func (ks *Keystore) refresh() {
+	ks.mu.RLock()
+	due := time.Since(ks.checked) >= keystoreCheckInterval
+	ks.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	// Another caller may have checked while we waited for the lock.
+	if time.Since(ks.checked) < keystoreCheckInterval {
+		return
+	}
+	ks.checked = time.Now()
+	if err := ks.load(); err != nil {
+		// Keep serving the keys we have rather than locking everyone out.
+		log.Printf("Failed to reload keystore: %v", err)
+	}
+}
+
+// Create adds a key with the given name and scopes and returns it along
+// with the secret token, which is not stored and cannot be recovered.
+func (ks *Keystore) Create(name string, scopes []string) (string, *Key, error) {
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, s := range scopes {
+		if !knownScopes[s] {
+			return "", nil, fmt.Errorf("%w: %q", ErrInvalidScope, s)
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	token := keyPrefix + id + "_" + secret
+
+	key := &Key{
+		ID:        id,
+		Name:      name,
+		Hash:      hashKey(token),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.load(); err != nil {
+		return "", nil, err
+	}
+	ks.byID[id] = key
+	if err := ks.save(); err != nil {
+		delete(ks.byID, id)
+		return "", nil, err
+	}
+
+	return token, key, nil
+}
+
+// Revoke deletes the key with id.
+func (ks *Keystore) Revoke(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.load(); err != nil {
+		return err
+	}
+	key, exists := ks.byID[id]
+	if !exists {
+		return ErrKeyNotFound
+	}
+	delete(ks.byID, id)
+	if err := ks.save(); err != nil {
+		ks.byID[id] = key
+		return err
+	}
+	return nil
+}
+
+// List returns all keys ordered by creation time.
+func (ks *Keystore) List() []*Key {
+	ks.refresh()
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]*Key, 0, len(ks.byID))
+	for _, key := range ks.byID {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// Authenticate returns the key matching token.
+func (ks *Keystore) Authenticate(token string) (*Key, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(token, keyPrefix), "_")
+	if !ok || !strings.HasPrefix(token, keyPrefix) {
+		return nil, ErrInvalidKey
+	}
+
+	ks.refresh()
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, exists := ks.byID[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashKey(token))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// save writes the keystore to disk atomically. Callers must hold ks.mu.
+func (ks *Keystore) save() error {
+	list := make([]*Key, 0, len(ks.byID))
+	for _, key := range ks.byID {
+		list = append(list, key)
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), ".keys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), ks.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(ks.path); err == nil {
+		ks.info = info
+	}
+	return nil
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func hashKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKeystore(t *testing.T) (*Keystore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	ks, err := NewKeystore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks, path
+}
+
+func TestKeystoreCreate(t *testing.T) {
+	ks, _ := newTestKeystore(t)
+
+	token, key, err := ks.Create("ci", []string{ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, keyPrefix+key.ID+"_") {
+		t.Errorf("token %q does not carry the key ID %s", token, key.ID)
+	}
+	if key.Name != "ci" || key.Hash == "" || strings.Contains(key.Hash, token) || key.CreatedAt.IsZero() {
+		t.Errorf("key = %+v", key)
+	}
+
+	got, err := ks.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != key.ID || !got.HasScope(ScopeCreate) || got.HasScope(ScopeReceive) {
+		t.Errorf("authenticated as %+v", got)
+	}
+
+	for _, bad := range []string{
+		"",
+		token[:len(token)-1] + "x",
+		strings.TrimPrefix(token, keyPrefix),
+		keyPrefix + key.ID,
+		keyPrefix + "0000000000000000_" + strings.Repeat("0", 64),
+	} {
+		if _, err := ks.Authenticate(bad); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Authenticate(%q) = %v, want ErrInvalidKey", bad, err)
+		}
+	}
+}
+
+func TestKeystoreCreateInvalid(t *testing.T) {
+	ks, path := newTestKeystore(t)
+
+	for _, scopes := range [][]string{nil, {"root"}, {ScopeCreate, "Create"}} {
+		if _, _, err := ks.Create("bad", scopes); !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("Create(%q) = %v, want ErrInvalidScope", scopes, err)
+		}
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("failed creates wrote the keystore: %v", err)
+	}
+}
+
+func TestKeyHasScope(t *testing.T) {
+	admin := &Key{Scopes: []string{ScopeAdmin}}
+	for _, scope := range []string{ScopeCreate, ScopeReceive, ScopeAdmin} {
+		if !admin.HasScope(scope) {
+			t.Errorf("admin key lacks %s", scope)
+		}
+	}
+	receive := &Key{Scopes: []string{ScopeReceive}}
+	if receive.HasScope(ScopeCreate) || receive.HasScope(ScopeAdmin) {
+		t.Error("receive key has more than receive")
+	}
+}
+
+func TestKeystoreRevoke(t *testing.T) {
+	ks, _ := newTestKeystore(t)
+	token, key, err := ks.Create("ci", []string{ScopeReceive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, _, err := ks.Create("other", []string{ScopeReceive})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Authenticate(token); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("revoked key: err = %v, want ErrInvalidKey", err)
+	}
+	if _, err := ks.Authenticate(kept); err != nil {
+		t.Errorf("other key: %v", err)
+	}
+	if err := ks.Revoke(key.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("revoke twice: err = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestKeystorePersistence(t *testing.T) {
+	ks, path := newTestKeystore(t)
+	first, _, err := ks.Create("first", []string{ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := ks.Create("second", []string{ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("keystore mode = %o, want 600", mode)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), first) {
+		t.Error("keystore file holds the secret token")
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".keys-*")); len(tmp) > 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+
+	reopened, err := NewKeystore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Authenticate(first); err != nil {
+		t.Errorf("after reopening: %v", err)
+	}
+	list := reopened.List()
+	if len(list) != 2 || list[0].Name != "first" || list[1].ID != second.ID || !list[1].HasScope(ScopeAdmin) {
+		t.Errorf("List() = %+v", list)
+	}
+}
+
+// expireCheck makes the next refresh look at the file again.
+func expireCheck(ks *Keystore) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.checked = time.Time{}
+}
+
+func TestKeystoreReload(t *testing.T) {
+	// server stands for the running server, cli for the keys subcommand
+	// writing the same file.
+	server, path := newTestKeystore(t)
+	cli, err := NewKeystore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, key, err := cli.Create("ci", []string{ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Authenticate(token); err != nil {
+		t.Errorf("created key not picked up: %v", err)
+	}
+
+	// Both processes write; neither loses the other's keys.
+	serverToken, _, err := server.Create("server", []string{ScopeReceive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.Authenticate(serverToken); err != nil {
+		t.Errorf("key created by the server not picked up: %v", err)
+	}
+	if n := len(cli.List()); n != 2 {
+		t.Errorf("cli lists %d keys, want 2", n)
+	}
+
+	if err := cli.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The file is only looked at once per check interval.
+	if _, err := server.Authenticate(token); err != nil {
+		t.Errorf("revoked key dropped before the next check: %v", err)
+	}
+	expireCheck(server)
+	if _, err := server.Authenticate(token); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("revoked key: err = %v, want ErrInvalidKey", err)
+	}
+
+	// A broken file keeps the last good keys in service.
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expireCheck(server)
+	if _, err := server.Authenticate(serverToken); err != nil {
+		t.Errorf("after a bad write: %v", err)
+	}
+	if _, err := NewKeystore(path); err == nil {
+		t.Error("NewKeystore accepted a corrupt file")
+	}
+
+	// A deleted file is an empty keystore.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	expireCheck(server)
+	if _, err := server.Authenticate(serverToken); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("after removing the file: err = %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestNewKeystore(t *testing.T) {
+	if _, err := NewKeystore(""); err == nil {
+		t.Error("NewKeystore accepted an empty path")
+	}
+	ks, _ := newTestKeystore(t)
+	if keys := ks.List(); len(keys) != 0 {
+		t.Errorf("missing file lists %d keys", len(keys))
+	}
+}
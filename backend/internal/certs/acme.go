@@ -0,0 +1,103 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig configures automatic certificates.
+type ACMEConfig struct {
+	// Domains are the host names certificates may be requested for.
+	Domains []string
+	// Email is given to the CA for expiry and problem notices.
+	Email string
+	// DirectoryURL is the CA's ACME directory. Empty selects Let's
+	// Encrypt production.
+	DirectoryURL string
+	// CAFile holds extra root certificates to trust when talking to the
+	// ACME server, for test CAs such as pebble.
+	CAFile string
+	// CacheDir stores the account key and issued certificates across
+	// restarts.
+	CacheDir string
+}
+
+// ACME obtains and renews certificates from an ACME CA. Challenges are
+// answered with TLS-ALPN-01 on the TLS listener, or HTTP-01 when
+// HTTPHandler serves port 80.
+type ACME struct {
+	manager *autocert.Manager
+	domains []string
+}
+
+// NewACME sets up an ACME client. Certificates are requested lazily on the
+// first handshake for each domain. Using it accepts the CA's terms of
+// service.
+func NewACME(cfg ACMEConfig) (*ACME, error) {
+	if len(cfg.Domains) == 0 {
+		return nil, errors.New("acme: at least one domain is required")
+	}
+	if cfg.CacheDir == "" {
+		return nil, errors.New("acme: cache directory is required")
+	}
+
+	client := &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("acme: read CA file: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("acme: no certificates in %s", cfg.CAFile)
+		}
+		client.HTTPClient = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+		}
+	}
+
+	return &ACME{
+		manager: &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.CacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+			Email:      cfg.Email,
+			Client:     client,
+		},
+		domains: cfg.Domains,
+	}, nil
+}
+
+// TLSConfig returns a server TLS configuration that fetches certificates
+// on demand.
+func (a *ACME) TLSConfig() *tls.Config {
+	cfg := a.manager.TLSConfig()
+	cfg.MinVersion = tls.VersionTLS12
+	return cfg
+}
+
+// Names returns the domains certificates may be requested for.
+func (a *ACME) Names() []string {
+	return a.domains
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes every other request to
+// fallback.
+func (a *ACME) HTTPHandler(fallback http.Handler) http.Handler {
+	return a.manager.HTTPHandler(fallback)
+}
@@ -0,0 +1,154 @@
+package certs
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewACMEInvalid(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificates here"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  ACMEConfig
+	}{
+		{"no domains", ACMEConfig{CacheDir: dir}},
+		{"no cache", ACMEConfig{Domains: []string{"takedat.example"}}},
+		{"missing CA file", ACMEConfig{Domains: []string{"takedat.example"}, CacheDir: dir, CAFile: filepath.Join(dir, "missing.pem")}},
+		{"empty CA file", ACMEConfig{Domains: []string{"takedat.example"}, CacheDir: dir, CAFile: empty}},
+	}
+	for _, tt := range tests {
+		if _, err := NewACME(tt.cfg); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+// unreachableCA returns a directory URL that fails every request, so
+// tests notice if the CA is contacted.
+func unreachableCA(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("CA contacted: %s %s", r.Method, r.URL)
+		http.Error(w, "unexpected", http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/dir"
+}
+
+func TestACMEHostPolicy(t *testing.T) {
+	a, err := NewACME(ACMEConfig{
+		Domains:      []string{"takedat.example"},
+		CacheDir:     t.TempDir(),
+		DirectoryURL: unreachableCA(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Certificates are never requested for other names.
+	if _, err := a.TLSConfig().GetCertificate(&tls.ClientHelloInfo{ServerName: "evil.example"}); err == nil {
+		t.Error("certificate issued for a name outside the domain list")
+	}
+
+	handler := a.HTTPHandler(RedirectHandler("443", a.Names()))
+	for _, tt := range []struct {
+		host, path string
+		status     int
+	}{
+		{"takedat.example", "/receive/ABC-123", http.StatusMovedPermanently},
+		{"evil.example", "/receive/ABC-123", http.StatusMisdirectedRequest},
+		// Challenges are answered over plain HTTP rather than redirected.
+		{"takedat.example", "/.well-known/acme-challenge/unknown-token", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+tt.path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s%s: status %d, want %d", tt.host, tt.path, rec.Code, tt.status)
+		}
+	}
+}
+
+// pebbleOrderLocation adds the order URL pebble leaves out of finalize
+// responses, which the acme client needs to poll the order until the
+// certificate is issued.
+type pebbleOrderLocation struct {
+	http.RoundTripper
+}
+
+func (p pebbleOrderLocation) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := p.RoundTripper.RoundTrip(req)
+	if err != nil || res.Header.Get("Location") != "" || !strings.Contains(req.URL.Path, "/finalize-order/") {
+		return res, err
+	}
+	order := *req.URL
+	order.Path = strings.Replace(order.Path, "/finalize-order/", "/my-order/", 1)
+	res.Header.Set("Location", order.String())
+	return res, nil
+}
+
+// TestACMEPebble obtains a certificate from a pebble test CA. Run pebble
+// with PEBBLE_VA_ALWAYS_VALID=1, since the CA cannot reach this test to
+// validate challenges, and point the test at it:
+//
+//	TAKEDAT_PEBBLE_DIRECTORY=https://localhost:14000/dir \
+//	TAKEDAT_PEBBLE_CA=test/certs/pebble.minica.pem go test ./internal/certs
+func TestACMEPebble(t *testing.T) {
+	directory := os.Getenv("TAKEDAT_PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("TAKEDAT_PEBBLE_DIRECTORY not set")
+	}
+
+	cache := t.TempDir()
+	cfg := ACMEConfig{
+		Domains:      []string{"takedat.test"},
+		Email:        "admin@takedat.test",
+		DirectoryURL: directory,
+		CAFile:       os.Getenv("TAKEDAT_PEBBLE_CA"),
+		CacheDir:     cache,
+	}
+	a, err := NewACME(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := a.manager.Client.HTTPClient
+	if client == nil {
+		client = &http.Client{Transport: http.DefaultTransport}
+		a.manager.Client.HTTPClient = client
+	}
+	client.Transport = pebbleOrderLocation{client.Transport}
+
+	hello := &tls.ClientHelloInfo{ServerName: "takedat.test"}
+	cert, err := a.TLSConfig().GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(cert.Leaf.DNSNames, "takedat.test") {
+		t.Errorf("certificate for %v, want takedat.test", cert.Leaf.DNSNames)
+	}
+
+	// The certificate outlives a restart without asking the CA again.
+	cfg.DirectoryURL = unreachableCA(t)
+	restarted, err := NewACME(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := restarted.TLSConfig().GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Error("certificate not reused from the cache")
+	}
+}
@@ -0,0 +1,116 @@
+// Package certs provides the server's TLS certificates, either from
+// certificate and key files that are reloaded when they change on disk or
+// from an ACME CA such as Let's Encrypt.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkInterval is how often the files are checked for changes. Checks
+// happen during handshakes, so an idle server does not poll.
+const checkInterval = 10 * time.Second
+
+// FileSource serves a certificate loaded from PEM files. Replacing the
+// files, for example after a certbot renewal, takes effect on the next
+// handshake after checkInterval without a restart.
+type FileSource struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	certInfo os.FileInfo
+	keyInfo  os.FileInfo
+	checked  time.Time
+	mu       sync.Mutex
+}
+
+// NewFileSource loads the certificate chain in certFile and its private
+// key in keyFile.
+func NewFileSource(certFile, keyFile string) (*FileSource, error) {
+	s := &FileSource{certFile: certFile, keyFile: keyFile}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the files if either changed since the last read. Callers must
+// hold s.mu or own s exclusively.
+func (s *FileSource) load() error {
+	certInfo, err := os.Stat(s.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(s.keyFile)
+	if err != nil {
+		return err
+	}
+	if !changed(s.certInfo, certInfo) && !changed(s.keyInfo, keyInfo) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("load certificate: %w", err)
+		}
+	}
+
+	if s.cert != nil {
+		log.Printf("Reloaded TLS certificate from %s", s.certFile)
+	}
+	s.cert = &cert
+	s.certInfo = certInfo
+	s.keyInfo = keyInfo
+	return nil
+}
+
+// changed reports whether the file now described by info differs from the
+// one last loaded. Renewals usually put new files in place, which a
+// modification time within the file system's granularity would miss.
+func changed(last, info os.FileInfo) bool {
+	return last == nil ||
+		!os.SameFile(last, info) ||
+		!info.ModTime().Equal(last.ModTime()) ||
+		info.Size() != last.Size()
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (s *FileSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := time.Now(); now.Sub(s.checked) >= checkInterval {
+		s.checked = now
+		if err := s.load(); err != nil {
+			// The files may be mid-update; keep serving the certificate
+			// we have and try again later.
+			log.Printf("Failed to reload TLS certificate: %v", err)
+		}
+	}
+	return s.cert, nil
+}
+
+// Names returns the DNS names the loaded certificate is valid for.
+func (s *FileSource) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cert.Leaf.DNSNames
+}
+
+// TLSConfig returns a server TLS configuration using s.
+func (s *FileSource) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.GetCertificate,
+	}
+}
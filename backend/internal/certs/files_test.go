@@ -0,0 +1,165 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for names with the given
+// serial number to certFile and keyFile. Like certbot, it puts new files
+// in place rather than rewriting them.
+func writeCert(t *testing.T, certFile, keyFile string, serial int64, names ...string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replace(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	replace(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func replace(t *testing.T, path string, data []byte) {
+	t.Helper()
+	tmp := path + ".new"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedSerial makes a TLS handshake with srv and returns the serial
+// number of the certificate it presented.
+func servedSerial(t *testing.T, srv *httptest.Server) int64 {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{
+		ServerName:         "takedat.example",
+		InsecureSkipVerify: true, // self-signed; only the serial matters
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+// expireCheck makes the next handshake look at the files again.
+func expireCheck(s *FileSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked = time.Time{}
+}
+
+func TestFileSourceReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, 1, "takedat.example", "*.files.example")
+
+	files, err := NewFileSource(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := files.Names(); !slices.Equal(names, []string{"takedat.example", "*.files.example"}) {
+		t.Errorf("Names() = %v", names)
+	}
+
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = files.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	if serial := servedSerial(t, srv); serial != 1 {
+		t.Fatalf("serving serial %d, want 1", serial)
+	}
+
+	// A renewal is picked up once the check interval has passed, not on
+	// every handshake.
+	writeCert(t, certFile, keyFile, 2, "takedat.example")
+	if serial := servedSerial(t, srv); serial != 1 {
+		t.Errorf("renewal picked up before the check interval: serial %d", serial)
+	}
+	expireCheck(files)
+	if serial := servedSerial(t, srv); serial != 2 {
+		t.Errorf("after renewal: serial %d, want 2", serial)
+	}
+	if names := files.Names(); !slices.Equal(names, []string{"takedat.example"}) {
+		t.Errorf("Names() after renewal = %v", names)
+	}
+
+	// Half-written files keep the current certificate in service.
+	replace(t, certFile, []byte("-----BEGIN CERTIFICATE-----\ntruncated"))
+	expireCheck(files)
+	if serial := servedSerial(t, srv); serial != 2 {
+		t.Errorf("after a bad write: serial %d, want 2", serial)
+	}
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	expireCheck(files)
+	if serial := servedSerial(t, srv); serial != 2 {
+		t.Errorf("with the key missing: serial %d, want 2", serial)
+	}
+
+	writeCert(t, certFile, keyFile, 3, "takedat.example")
+	expireCheck(files)
+	if serial := servedSerial(t, srv); serial != 3 {
+		t.Errorf("after fixing the files: serial %d, want 3", serial)
+	}
+}
+
+func TestNewFileSourceInvalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	otherCert, otherKey := filepath.Join(dir, "other.pem"), filepath.Join(dir, "other-key.pem")
+	writeCert(t, certFile, keyFile, 1, "takedat.example")
+	writeCert(t, otherCert, otherKey, 2, "takedat.example")
+
+	tests := []struct {
+		name          string
+		cert, keyFile string
+	}{
+		{"missing cert", filepath.Join(dir, "missing.pem"), keyFile},
+		{"missing key", certFile, filepath.Join(dir, "missing.pem")},
+		{"mismatched key", certFile, otherKey},
+		{"swapped", keyFile, certFile},
+	}
+	for _, tt := range tests {
+		if _, err := NewFileSource(tt.cert, tt.keyFile); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
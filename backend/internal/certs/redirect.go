@@ -0,0 +1,56 @@
+package certs
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RedirectHandler sends plain HTTP requests to the same URL over HTTPS on
+// httpsPort. Only hosts matching names, the domains the server holds
+// certificates for, are redirected so the handler cannot be used to bounce
+// clients to arbitrary sites. Names may start with a "*." wildcard label.
+func RedirectHandler(httpsPort string, names []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			http.Error(w, "Missing Host header", http.StatusBadRequest)
+			return
+		}
+		if !matchName(names, host) {
+			http.Error(w, "Unknown host", http.StatusMisdirectedRequest)
+			return
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// matchName reports whether host is one of names, ignoring case and a
+// trailing dot. A wildcard name matches exactly one label in its place.
+func matchName(names []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(name, "*"); ok && strings.HasPrefix(suffix, ".") {
+			label, rest, _ := strings.Cut(host, ".")
+			if label != "" && "."+rest == suffix {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package certs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	names := []string{"takedat.example", "*.files.example", "Upper.Example"}
+
+	tests := []struct {
+		port     string
+		host     string
+		target   string
+		status   int
+		location string
+	}{
+		{"443", "takedat.example", "/receive/ABC-123?x=1", http.StatusMovedPermanently, "https://takedat.example/receive/ABC-123?x=1"},
+		{"443", "takedat.example:80", "/", http.StatusMovedPermanently, "https://takedat.example/"},
+		{"8443", "takedat.example", "/", http.StatusMovedPermanently, "https://takedat.example:8443/"},
+		{"443", "TAKEDAT.example.", "/", http.StatusMovedPermanently, "https://TAKEDAT.example./"},
+		{"443", "upper.example", "/", http.StatusMovedPermanently, "https://upper.example/"},
+		{"443", "eu.files.example", "/", http.StatusMovedPermanently, "https://eu.files.example/"},
+
+		{"443", "evil.example", "/", http.StatusMisdirectedRequest, ""},
+		{"443", "takedat.example.evil.example", "/", http.StatusMisdirectedRequest, ""},
+		{"443", "files.example", "/", http.StatusMisdirectedRequest, ""},
+		{"443", "a.b.files.example", "/", http.StatusMisdirectedRequest, ""},
+		{"443", "[2001:db8::1]", "/", http.StatusMisdirectedRequest, ""},
+		{"443", "", "/", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://placeholder"+tt.target, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		RedirectHandler(tt.port, names).ServeHTTP(rec, req)
+
+		if rec.Code != tt.status || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s%s on %s: got %d %q, want %d %q", tt.host, tt.target, tt.port,
+				rec.Code, rec.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+}
+
+func TestRedirectHandlerIPv6(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://placeholder/", nil)
+	req.Host = "[2001:db8::1]:80"
+	rec := httptest.NewRecorder()
+	RedirectHandler("443", []string{"2001:db8::1"}).ServeHTTP(rec, req)
+
+	if want := "https://[2001:db8::1]/"; rec.Header().Get("Location") != want {
+		t.Errorf("Location = %q, want %q", rec.Header().Get("Location"), want)
+	}
+}
@@ -1,63 +1,203 @@
+// Package config loads the server configuration. Settings come from
+// built-in defaults, then an optional YAML or TOML file, then environment
+// variables and finally command-line flags, each layer overriding the one
+// before it.
+//
+// Every setting has a single key, such as session_ttl. The same key is used
+// in config files, upper-cased for the environment (SESSION_TTL) and with
+// dashes for flags (--session-ttl).
+//
+// Settings tagged reload:"true" can be changed on a running server, see
+// Reloaded; the rest need a restart.
 package config
 
 import (
+	"fmt"
 	"os"
-	"strconv"
 	"time"
 )
 
 type Config struct {
-	Port           string
-	Host           string
-	SessionTTL     time.Duration
-	ChunkSize      int
-	MaxFileSize    int64
-	AllowedOrigins []string
-	StaticDir      string
+	Port           string        `key:"port" usage:"HTTP listen port"`
+	Host           string        `key:"host" usage:"HTTP listen address"`
+	SessionTTL     time.Duration `key:"session_ttl" reload:"true" usage:"how long a session stays valid"`
+	ChunkSize      int           `key:"chunk_size" usage:"chunk size suggested to clients in bytes"`
+	MaxFileSize    int64         `key:"max_file_size" usage:"largest file that may be offered in bytes"`
+	AllowedOrigins []string      `key:"allowed_origins" reload:"true" usage:"browser origins allowed besides our own, *.domain wildcards allowed"`
+	StaticDir      string        `key:"static_dir" usage:"directory with a frontend build to serve instead of the embedded one"`
+	PublicURL      string        `key:"public_url" usage:"base URL for receive links, derived from requests if empty"`
+	TrustedProxies []string      `key:"trusted_proxies" usage:"CIDRs of reverse proxies whose X-Forwarded-* headers are honoured"`
+	PublicMetrics  bool          `key:"public_metrics" usage:"serve /api/metrics to anyone when API keys are off"`
+	ICEServers     []string      `key:"ice_servers" usage:"STUN URLs offered to peers for direct WebRTC transfers, none by default"`
+	CodeFormat     string        `key:"code_format" usage:"share code format: alphabet or words"`
+	CodeWords      int           `key:"code_words" usage:"number of words in words codes"`
+	// SessionCleanupInterval is how often expired sessions are swept.
+	SessionCleanupInterval time.Duration `key:"session_cleanup_interval" usage:"how often expired sessions are removed and their peers disconnected"`
+	// VanityCodes enables reserving named codes; ReservationsFile persists
+	// them across restarts when set.
+	VanityCodes      bool   `key:"vanity_codes" usage:"allow reserving vanity codes"`
+	ReservationsFile string `key:"reservations_file" usage:"file that persists vanity code reservations"`
+	// WSCompression enables permessage-deflate; ChunkCompressions lists the
+	// chunk compression algorithms peers may negotiate.
+	WSCompression     bool     `key:"ws_compression" usage:"negotiate permessage-deflate"`
+	ChunkCompressions []string `key:"chunk_compressions" usage:"chunk compression algorithms peers may negotiate"`
+	// MaxStreams caps the parallel connections per side of a session.
+	MaxStreams int `key:"max_streams" usage:"parallel connections per side of a session"`
+	// WebSocket connection tunables.
+	WSWriteWait       time.Duration `key:"ws_write_wait" usage:"time allowed to write a WebSocket message"`
+	WSPongWait        time.Duration `key:"ws_pong_wait" usage:"time allowed between pongs before a client is dropped"`
+	WSPingPeriod      time.Duration `key:"ws_ping_period" usage:"how often clients are pinged, 0 for 9/10 of ws_pong_wait"`
+	WSRegisterWait    time.Duration `key:"ws_register_wait" usage:"time allowed to send the register message"`
+	WSMaxMessageSize  int64         `key:"ws_max_message_size" usage:"largest WebSocket message accepted in bytes"`
+	WSReadBufferSize  int           `key:"ws_read_buffer_size" usage:"WebSocket read buffer size in bytes"`
+	WSWriteBufferSize int           `key:"ws_write_buffer_size" usage:"WebSocket write buffer size in bytes"`
+	WSSendQueue       int           `key:"ws_send_queue" usage:"outgoing messages buffered per client"`
+	WSReplayBuffer    int           `key:"ws_replay_buffer" usage:"unacknowledged messages kept per side of a session"`
+	WSReplayGrace     time.Duration `key:"ws_replay_grace" usage:"how long replay buffers outlive the last connection"`
+	// Relay bandwidth limits in bytes per second, zero for unlimited.
+	RateLimitGlobal  int64 `key:"rate_limit_global" reload:"true" usage:"server-wide relay bandwidth in bytes/s, 0 for unlimited"`
+	RateLimitSession int64 `key:"rate_limit_session" reload:"true" usage:"per-session relay bandwidth in bytes/s, 0 for unlimited"`
+	RateLimitIP      int64 `key:"rate_limit_ip" reload:"true" usage:"per-IP relay bandwidth in bytes/s, 0 for unlimited"`
+	// Quotas, zero for unlimited.
+	MaxSessionsPerIP   int   `key:"max_sessions_per_ip" reload:"true" usage:"live sessions one IP may create, 0 for unlimited"`
+	MaxConcurrentPairs int   `key:"max_concurrent_pairs" reload:"true" usage:"sessions with both peers connected at once, 0 for unlimited"`
+	MaxDailyBytesPerIP int64 `key:"max_daily_bytes_per_ip" reload:"true" usage:"bytes one IP may relay per day, 0 for unlimited"`
+	// APIKeysFile enables API keys stored in that file. RequireAPIKey
+	// makes creating and joining sessions require credentials.
+	APIKeysFile   string `key:"api_keys_file" usage:"file holding hashed API keys"`
+	RequireAPIKey bool   `key:"require_api_key" usage:"require credentials to create or join sessions"`
+	// OIDC enables user tokens verified against the provider's JWKS, read
+	// from a file or URL. RequireSenderAuth refuses anonymous senders while
+	// receivers may stay anonymous.
+	OIDCIssuer        string `key:"oidc_issuer" usage:"expected token issuer"`
+	OIDCAudience      string `key:"oidc_audience" usage:"expected token audience"`
+	OIDCJWKSFile      string `key:"oidc_jwks_file" usage:"file with the provider's JWKS"`
+	OIDCJWKSURL       string `key:"oidc_jwks_url" usage:"URL of the provider's JWKS"`
+	RequireSenderAuth bool   `key:"require_sender_auth" usage:"require credentials to send"`
+	// TURN enables the embedded TURN relay for peers that cannot connect
+	// directly.
+	TURNEnabled       bool          `key:"turn_enabled" usage:"run the embedded TURN relay"`
+	TURNListenAddr    string        `key:"turn_listen_addr" usage:"TURN listen address"`
+	TURNPublicIP      string        `key:"turn_public_ip" usage:"public IP advertised in TURN relay addresses, required with turn_enabled"`
+	TURNRealm         string        `key:"turn_realm" usage:"TURN realm"`
+	TURNSecret        string        `key:"turn_secret" secret:"true" usage:"secret signing TURN credentials, random if empty"`
+	TURNCredentialTTL time.Duration `key:"turn_credential_ttl" usage:"lifetime of TURN credentials"`
+	TURNMinPort       int           `key:"turn_min_port" usage:"lowest relay port, 0 for any"`
+	TURNMaxPort       int           `key:"turn_max_port" usage:"highest relay port, 0 for any"`
+	TURNAllowedPeers  []string      `key:"turn_allowed_peers" usage:"private CIDRs the TURN relay may reach, e.g. 10.0.0.0/8"`
+	// TLS serves HTTPS from certificate files, reloaded when they change on
+	// disk, or from certificates obtained from an ACME CA.
+	// HTTPRedirectAddr additionally listens for plain HTTP, redirecting to
+	// HTTPS and answering ACME HTTP-01 challenges.
+	TLSCertFile      string   `key:"tls_cert_file" usage:"PEM certificate chain, enables HTTPS"`
+	TLSKeyFile       string   `key:"tls_key_file" usage:"PEM private key for tls_cert_file"`
+	HTTPRedirectAddr string   `key:"http_redirect_addr" usage:"plain HTTP listen address redirecting the certificate domains to HTTPS, e.g. :80"`
+	ACMEDomains      []string `key:"acme_domains" usage:"domains to obtain certificates for over ACME, enables HTTPS"`
+	ACMEEmail        string   `key:"acme_email" usage:"contact email for the ACME account"`
+	ACMEDirectoryURL string   `key:"acme_directory_url" usage:"ACME directory URL, Let's Encrypt if empty"`
+	ACMECAFile       string   `key:"acme_ca_file" usage:"extra root certificates trusted for the ACME server"`
+	ACMECacheDir     string   `key:"acme_cache_dir" usage:"directory storing the ACME account and certificates"`
+	// HTTP2 serves HTTP/2 alongside HTTP/1.1 over TLS. H2C serves
+	// cleartext HTTP/2 for proxies that speak it to the backend. HTTP3Addr
+	// adds a QUIC listener, announced to clients with Alt-Svc.
+	HTTP2     bool   `key:"http2" usage:"serve HTTP/2 over TLS"`
+	H2C       bool   `key:"h2c" usage:"serve cleartext HTTP/2 when TLS is off"`
+	HTTP3Addr string `key:"http3_addr" usage:"UDP listen address for HTTP/3, e.g. :443"`
+
+	// ConfigFile is the file the configuration was read from, if any.
+	ConfigFile string
+	// PrintConfig asks the server to print the configuration and exit.
+	PrintConfig bool
 }
 
-func Load() *Config {
+// Default returns the built-in configuration.
+func Default() *Config {
 	return &Config{
-		Port:           getEnv("PORT", "8080"),
-		Host:           getEnv("HOST", "0.0.0.0"),
-		SessionTTL:     getDuration("SESSION_TTL", 10*time.Minute),
-		ChunkSize:      getInt("CHUNK_SIZE", 64*1024),
-		MaxFileSize:    getInt64("MAX_FILE_SIZE", 5*1024*1024*1024), // 5GB
-		AllowedOrigins: []string{"http://localhost:5173", "http://localhost:3000", "*"},
-		StaticDir:      getEnv("STATIC_DIR", ""),
+		Port:                   "8080",
+		Host:                   "0.0.0.0",
+		SessionTTL:             10 * time.Minute,
+		ChunkSize:              64 * 1024,
+		MaxFileSize:            5 * 1024 * 1024 * 1024, // 5GB
+		AllowedOrigins:         []string{"http://localhost:5173", "http://localhost:3000"},
+		CodeFormat:             "alphabet",
+		CodeWords:              3,
+		SessionCleanupInterval: time.Minute,
+		VanityCodes:            true,
+		ChunkCompressions:      []string{"zstd", "gzip"},
+		MaxStreams:             4,
+		WSWriteWait:            10 * time.Second,
+		WSPongWait:             60 * time.Second,
+		WSRegisterWait:         10 * time.Second,
+		WSMaxMessageSize:       512 * 1024, // 512KB
+		WSReadBufferSize:       1024,
+		WSWriteBufferSize:      1024,
+		WSSendQueue:            256,
+		WSReplayBuffer:         64,
+		WSReplayGrace:          30 * time.Second,
+		TURNListenAddr:         "0.0.0.0:3478",
+		TURNRealm:              "takedat",
+		TURNCredentialTTL:      10 * time.Minute,
+		ACMECacheDir:           "acme",
+		HTTP2:                  true,
 	}
 }
 
-func getEnv(key, fallback string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return fallback
+// TLSEnabled reports whether the server serves HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.ACMEDomains) > 0
 }
 
-func getInt(key string, fallback int) int {
-	if value := os.Getenv(key); value != "" {
-		if i, err := strconv.Atoi(value); err == nil {
-			return i
+// LoadKeysFile returns the API keystore file set in the config file named
+// by CONFIG_FILE or in the environment. No other setting is read or
+// validated, so keys can be managed while the rest of the configuration is
+// broken or incomplete.
+func LoadKeysFile() (string, error) {
+	cfg := Default()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path, "api_keys_file"); err != nil {
+			return "", err
 		}
 	}
-	return fallback
+	if err := cfg.loadEnv("api_keys_file"); err != nil {
+		return "", err
+	}
+	return cfg.APIKeysFile, nil
 }
 
-func getInt64(key string, fallback int64) int64 {
-	if value := os.Getenv(key); value != "" {
-		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
-			return i
-		}
+// Load builds the configuration from the defaults, the config file named
+// by --config or CONFIG_FILE, the environment and the command-line args,
+// then validates it.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs, flags := newFlagSet(cfg)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
 	}
-	return fallback
-}
 
-func getDuration(key string, fallback time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if d, err := time.ParseDuration(value); err == nil {
-			return d
+	cfg.ConfigFile = os.Getenv("CONFIG_FILE")
+	if flags.configFile != "" {
+		cfg.ConfigFile = flags.configFile
+	}
+	cfg.PrintConfig = flags.printConfig
+
+	if cfg.ConfigFile != "" {
+		if err := cfg.loadFile(cfg.ConfigFile); err != nil {
+			return nil, err
 		}
 	}
-	return fallback
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := flags.apply(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
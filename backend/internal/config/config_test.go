@@ -0,0 +1,305 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// clearEnv hides any configuration in the test's environment.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for _, s := range Default().settings() {
+		t.Setenv(s.env(), "")
+	}
+}
+
+// writeFile writes a config file named name in a temporary directory.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// get returns the setting key of c formatted as in Print.
+func get(t *testing.T, c *Config, key string) string {
+	t.Helper()
+	for _, s := range c.settings() {
+		if s.key == key {
+			return formatValue(s)
+		}
+	}
+	t.Fatalf("unknown setting %q", key)
+	return ""
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Load() = %+v, want the defaults", cfg)
+	}
+	if len(cfg.ICEServers) != 0 {
+		t.Errorf("ICE servers offered by default: %v", cfg.ICEServers)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // file name and content, separated by a newline
+		env  map[string]string
+		args []string
+		want map[string]string // key -> value formatted as in Print
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{"port": `"8080"`, "session_ttl": `"10m0s"`, "ice_servers": "[]", "turn_public_ip": `""`},
+		},
+		{
+			name: "turn relay",
+			args: []string{"--turn-enabled", "--turn-public-ip", "203.0.113.7"},
+			want: map[string]string{"turn_enabled": "true", "turn_public_ip": `"203.0.113.7"`},
+		},
+		{
+			name: "yaml file over defaults",
+			file: "config.yaml\nport: 9000\nsession_ttl: 5m\nice_servers: [\"stun:stun.example.com:3478\"]\n",
+			want: map[string]string{"port": `"9000"`, "session_ttl": `"5m0s"`, "ice_servers": `["stun:stun.example.com:3478"]`},
+		},
+		{
+			name: "toml file over defaults",
+			file: "config.toml\nport = \"9000\"\nvanity_codes = false\nmax_streams = 2\n",
+			want: map[string]string{"port": `"9000"`, "vanity_codes": "false", "max_streams": "2"},
+		},
+		{
+			name: "file list as string",
+			file: "config.yml\nallowed_origins: https://a.example, https://b.example\n",
+			want: map[string]string{"allowed_origins": `["https://a.example", "https://b.example"]`},
+		},
+		{
+			name: "file null clears a list",
+			file: "config.yaml\nallowed_origins: null\n",
+			want: map[string]string{"allowed_origins": "[]"},
+		},
+		{
+			name: "env over file",
+			file: "config.yaml\nport: 9000\nchunk_size: 1024\n",
+			env:  map[string]string{"PORT": "9100"},
+			want: map[string]string{"port": `"9100"`, "chunk_size": "1024"},
+		},
+		{
+			name: "empty env ignored",
+			file: "config.yaml\nport: 9000\n",
+			env:  map[string]string{"PORT": ""},
+			want: map[string]string{"port": `"9000"`},
+		},
+		{
+			name: "env list",
+			env:  map[string]string{"CHUNK_COMPRESSIONS": " gzip, ,none "},
+			want: map[string]string{"chunk_compressions": `["gzip", "none"]`},
+		},
+		{
+			name: "flag over env and file",
+			file: "config.yaml\nport: 9000\nrate_limit_ip: 10\n",
+			env:  map[string]string{"PORT": "9100", "RATE_LIMIT_IP": "20"},
+			args: []string{"--port", "9200"},
+			want: map[string]string{"port": `"9200"`, "rate_limit_ip": "20"},
+		},
+		{
+			name: "bool flag",
+			env:  map[string]string{"HTTP2": "true"},
+			args: []string{"--http2=false", "--vanity-codes=false", "--ws-compression"},
+			want: map[string]string{"http2": "false", "vanity_codes": "false", "ws_compression": "true"},
+		},
+		{
+			name: "flag list",
+			args: []string{"-trusted-proxies", "10.0.0.0/8,192.0.2.1"},
+			want: map[string]string{"trusted_proxies": `["10.0.0.0/8", "192.0.2.1"]`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				name, content, _ := strings.Cut(tt.file, "\n")
+				args = append([]string{"--config", writeFile(t, name, content)}, args...)
+			}
+
+			cfg, err := Load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if got := get(t, cfg, key); got != want {
+					t.Errorf("%s = %s, want %s", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileSource(t *testing.T) {
+	clearEnv(t)
+	fromEnv := writeFile(t, "env.yaml", "port: 9000\n")
+	fromFlag := writeFile(t, "flag.yaml", "port: 9100\n")
+	t.Setenv("CONFIG_FILE", fromEnv)
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9000" || cfg.ConfigFile != fromEnv {
+		t.Errorf("CONFIG_FILE: port %s from %s", cfg.Port, cfg.ConfigFile)
+	}
+
+	cfg, err = Load([]string{"--config", fromFlag, "--print-config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9100" || cfg.ConfigFile != fromFlag || !cfg.PrintConfig {
+		t.Errorf("--config: port %s from %s, print %v", cfg.Port, cfg.ConfigFile, cfg.PrintConfig)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // file name and content, separated by a newline
+		env  map[string]string
+		args []string
+		want []string // substrings of the error
+	}{
+		// Parsing
+		{name: "env duration", env: map[string]string{"SESSION_TTL": "soon"}, want: []string{`SESSION_TTL: invalid duration "soon"`}},
+		{name: "env integer", env: map[string]string{"MAX_STREAMS": "four"}, want: []string{`MAX_STREAMS: invalid integer "four"`}},
+		{name: "env boolean", env: map[string]string{"HTTP2": "maybe"}, want: []string{`HTTP2: invalid boolean "maybe"`}},
+		{name: "env overflow", env: map[string]string{"CHUNK_SIZE": "99999999999999999999"}, want: []string{"CHUNK_SIZE: invalid integer"}},
+		{name: "flag integer", args: []string{"--chunk-size", "big"}, want: []string{`--chunk-size: invalid integer "big"`}},
+		{name: "unknown flag", args: []string{"--no-such-flag"}, want: []string{"no-such-flag"}},
+		{name: "extra argument", args: []string{"serve"}, want: []string{`unexpected argument "serve"`}},
+		{name: "file missing", args: []string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}, want: []string{"read config file"}},
+		{name: "file format", file: "config.json\n{}", want: []string{"unsupported format"}},
+		{name: "file syntax", file: "config.yaml\nport: [\n", want: []string{"parse config file"}},
+		{name: "file unknown key", file: "config.yaml\nprot: 9000\n", want: []string{`unknown setting "prot"`}},
+		{name: "file table", file: "config.toml\n[port]\nvalue = 1\n", want: []string{"port: expected a value, not a table"}},
+		{name: "file list for scalar", file: "config.yaml\nport: [1, 2]\n", want: []string{"port: expected a single value, not a list"}},
+		{name: "file value", file: "config.yaml\nws_pong_wait: 60\n", want: []string{`ws_pong_wait: invalid duration "60"`}},
+		{
+			name: "every parse error reported",
+			env:  map[string]string{"SESSION_TTL": "soon", "MAX_STREAMS": "four"},
+			want: []string{"SESSION_TTL", "MAX_STREAMS"},
+		},
+
+		// Validation
+		{name: "port", args: []string{"--port", "0"}, want: []string{`port: must be a port number, got "0"`}},
+		{name: "ttl", env: map[string]string{"SESSION_TTL": "0s"}, want: []string{"session_ttl: must be positive"}},
+		{name: "code format", args: []string{"--code-format", "emoji"}, want: []string{"code_format:"}},
+		{name: "ice server", args: []string{"--ice-servers", "https://stun.example.com"}, want: []string{"ice_servers:"}},
+		{name: "origin", args: []string{"--allowed-origins", "example.com"}, want: []string{"allowed_origins:"}},
+		{name: "trusted proxy", args: []string{"--trusted-proxies", "10.0.0.0/33"}, want: []string{"trusted_proxies:"}},
+		{name: "public url", args: []string{"--public-url", "ftp://example.com"}, want: []string{"public_url:"}},
+		{name: "compression", args: []string{"--chunk-compressions", "brotli"}, want: []string{`chunk_compressions: unknown algorithm "brotli"`}},
+		{name: "ping period", args: []string{"--ws-pong-wait", "10s", "--ws-ping-period", "10s"}, want: []string{"ws_ping_period:"}},
+		{name: "message size", args: []string{"--chunk-size", "1048576"}, want: []string{"ws_max_message_size: must fit"}},
+		{name: "negative limit", env: map[string]string{"RATE_LIMIT_IP": "-1"}, want: []string{"rate_limit_ip: must be at least 0"}},
+		{name: "auth required", args: []string{"--require-api-key"}, want: []string{"require_api_key: needs"}},
+		{name: "jwks sources", args: []string{"--oidc-jwks-file", "jwks.json", "--oidc-jwks-url", "https://issuer.example/jwks"}, want: []string{"oidc_jwks_url: set either"}},
+		{name: "turn peers", args: []string{"--turn-enabled", "--turn-public-ip", "203.0.113.7", "--turn-allowed-peers", "10.0.0.1"}, want: []string{"turn_allowed_peers:"}},
+		{name: "turn public ip missing", args: []string{"--turn-enabled"}, want: []string{"turn_public_ip: is required"}},
+		{name: "turn public ip loopback", args: []string{"--turn-enabled", "--turn-public-ip", "127.0.0.1"}, want: []string{`turn_public_ip: must be reachable by peers, got "127.0.0.1"`}},
+		{name: "turn public ip unspecified", args: []string{"--turn-enabled", "--turn-public-ip", "::"}, want: []string{"turn_public_ip: must be reachable"}},
+		{name: "turn ports", args: []string{"--turn-min-port", "50000"}, want: []string{"turn_min_port:"}},
+		{name: "tls pair", args: []string{"--tls-cert-file", "cert.pem"}, want: []string{"tls_cert_file:"}},
+		{name: "acme directory", args: []string{"--acme-domains", "example.com", "--acme-directory-url", "http://ca.example"}, want: []string{"acme_directory_url:"}},
+		{name: "redirect without tls", args: []string{"--http-redirect-addr", ":80"}, want: []string{"http_redirect_addr: needs"}},
+		{name: "h2c with tls", args: []string{"--h2c", "--acme-domains", "example.com"}, want: []string{"h2c:"}},
+		{name: "http3 without tls", args: []string{"--http3-addr", ":443"}, want: []string{"http3_addr: needs"}},
+		{
+			name: "every validation error reported",
+			args: []string{"--port", "99999", "--max-streams", "0"},
+			want: []string{"port:", "max_streams:"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				name, content, _ := strings.Cut(tt.file, "\n")
+				args = append([]string{"--config", writeFile(t, name, content)}, args...)
+			}
+
+			_, err := Load(args)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadKeysFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // file name and content, separated by a newline
+		env  map[string]string
+		want string
+	}{
+		{name: "unset"},
+		{name: "file", file: "config.yaml\napi_keys_file: file.json\n", want: "file.json"},
+		{name: "env over file", file: "config.yaml\napi_keys_file: file.json\n", env: map[string]string{"API_KEYS_FILE": "env.json"}, want: "env.json"},
+		{
+			name: "other settings invalid",
+			file: "config.yaml\napi_keys_file: keys.json\nport: 0\nprot: 9000\nws_pong_wait: 60\n",
+			env:  map[string]string{"SESSION_TTL": "soon", "REQUIRE_API_KEY": "true"},
+			want: "keys.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if tt.file != "" {
+				name, content, _ := strings.Cut(tt.file, "\n")
+				t.Setenv("CONFIG_FILE", writeFile(t, name, content))
+			}
+
+			got, err := LoadKeysFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("LoadKeysFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	clearEnv(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", "port: [\n"))
+	if _, err := LoadKeysFile(); err == nil || !strings.Contains(err.Error(), "parse config file") {
+		t.Errorf("broken config file: error = %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Print writes the configuration as YAML that can be used as a config
+// file. Secrets are masked.
+func (c *Config) Print(w io.Writer) error {
+	if c.ConfigFile != "" {
+		if _, err := fmt.Fprintf(w, "# loaded from %s\n", c.ConfigFile); err != nil {
+			return err
+		}
+	}
+	for _, s := range c.settings() {
+		value := formatValue(s)
+		if s.secret && s.value.String() != "" {
+			value = `"********"`
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", s.key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatValue renders a setting as a YAML value.
+func formatValue(s setting) string {
+	v := s.value
+	switch {
+	case v.Type() == durationType:
+		return strconv.Quote(time.Duration(v.Int()).String())
+	case v.Kind() == reflect.String:
+		return strconv.Quote(v.String())
+	case v.Kind() == reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = strconv.Quote(v.Index(i).String())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
@@ -0,0 +1,40 @@
+package config
+
+import "reflect"
+
+// Change describes a setting that differs between two configurations.
+type Change struct {
+	Key      string
+	Old, New string // formatted as in Print, secrets masked
+	// Reloadable reports whether the change was applied by Reloaded.
+	Reloadable bool
+}
+
+// Reloaded returns a copy of running with the reloadable settings taken
+// from next, together with every setting that differs. Settings that need
+// a restart keep their running values, so they are reported again on the
+// next reload until the server is restarted.
+func Reloaded(running, next *Config) (*Config, []Change) {
+	merged := *running
+	merged.ConfigFile = next.ConfigFile
+
+	var changes []Change
+	nextSettings := next.settings()
+	for i, s := range merged.settings() {
+		n := nextSettings[i]
+		if reflect.DeepEqual(s.value.Interface(), n.value.Interface()) {
+			continue
+		}
+
+		c := Change{Key: s.key, Old: formatValue(s), New: formatValue(n), Reloadable: s.reload}
+		if s.secret {
+			c.Old, c.New = `"********"`, `"********"`
+		}
+		changes = append(changes, c)
+
+		if s.reload {
+			s.value.Set(n.value)
+		}
+	}
+	return &merged, changes
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is one configurable field of Config.
+type setting struct {
+	key    string
+	usage  string
+	secret bool
+	reload bool
+	value  reflect.Value
+}
+
+func (s setting) env() string  { return strings.ToUpper(s.key) }
+func (s setting) flag() string { return strings.ReplaceAll(s.key, "_", "-") }
+
+// settings lists the fields of c that carry a key tag, in declaration
+// order.
+func (c *Config) settings() []setting {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	var settings []setting
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("key")
+		if key == "" {
+			continue
+		}
+		settings = append(settings, setting{
+			key:    key,
+			usage:  f.Tag.Get("usage"),
+			secret: f.Tag.Get("secret") == "true",
+			reload: f.Tag.Get("reload") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return settings
+}
+
+// set parses raw into the setting's field.
+func (s setting) set(raw string) error {
+	v := s.value
+	raw = strings.TrimSpace(raw)
+
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(n)
+
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		list := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// setValue assigns a value decoded from a config file. Lists may be given
+// as arrays or comma-separated strings; everything else goes through the
+// same parsing as environment variables.
+func (s setting) setValue(value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		s.value.Set(reflect.Zero(s.value.Type()))
+		return nil
+	case []interface{}:
+		if s.value.Kind() != reflect.Slice {
+			return errors.New("expected a single value, not a list")
+		}
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = fmt.Sprint(item)
+		}
+		return s.set(strings.Join(items, ","))
+	case map[string]interface{}:
+		return errors.New("expected a value, not a table")
+	default:
+		return s.set(fmt.Sprint(value))
+	}
+}
+
+// loadFile applies a YAML or TOML config file, picked by its extension.
+// Unknown keys are rejected so typos do not go unnoticed. If keys are
+// given, every other entry in the file is ignored.
+func (c *Config) loadFile(path string, keys ...string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	byKey := make(map[string]setting)
+	for _, s := range c.settings() {
+		byKey[s.key] = s
+	}
+
+	var errs []error
+	for key, value := range values {
+		if len(keys) > 0 && !slices.Contains(keys, key) {
+			continue
+		}
+		s, ok := byKey[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, key))
+			continue
+		}
+		if err := s.setValue(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadEnv applies environment variables. Empty variables are ignored, as
+// are those for settings other than keys, if any are given.
+func (c *Config) loadEnv(keys ...string) error {
+	var errs []error
+	for _, s := range c.settings() {
+		if len(keys) > 0 && !slices.Contains(keys, s.key) {
+			continue
+		}
+		raw := os.Getenv(s.env())
+		if raw == "" {
+			continue
+		}
+		if err := s.set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.env(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flagValues holds command-line flags until the layers below them have
+// been applied.
+type flagValues struct {
+	configFile  string
+	printConfig bool
+	raw         map[string]string // key -> value as given
+}
+
+// rawFlag records a flag's value as given for later parsing.
+type rawFlag struct {
+	key    string
+	isBool bool
+	values *flagValues
+}
+
+func (f *rawFlag) String() string { return "" }
+
+func (f *rawFlag) Set(s string) error {
+	f.values.raw[f.key] = s
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool { return f.isBool }
+
+// newFlagSet registers a flag for every setting of cfg, plus --config and
+// --print-config.
+func newFlagSet(cfg *Config) (*flag.FlagSet, *flagValues) {
+	values := &flagValues{raw: make(map[string]string)}
+
+	fs := flag.NewFlagSet("takedat", flag.ContinueOnError)
+	fs.StringVar(&values.configFile, "config", "", "YAML or TOML config file (env CONFIG_FILE)")
+	fs.BoolVar(&values.printConfig, "print-config", false, "print the effective configuration and exit")
+
+	for _, s := range cfg.settings() {
+		usage := fmt.Sprintf("%s (env %s", s.usage, s.env())
+		if def := formatValue(s); def != "" && def != `""` && def != "[]" && !s.secret {
+			usage += ", default " + def
+		}
+		usage += ")"
+
+		fs.Var(&rawFlag{key: s.key, isBool: s.value.Kind() == reflect.Bool, values: values}, s.flag(), usage)
+	}
+
+	return fs, values
+}
+
+// apply parses the flags given on the command line into cfg.
+func (f *flagValues) apply(cfg *Config) error {
+	var errs []error
+	for _, s := range cfg.settings() {
+		raw, ok := f.raw[s.key]
+		if !ok {
+			continue
+		}
+		if err := s.set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %w", s.flag(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"takedat/internal/origin"
+	"takedat/internal/realip"
+	"takedat/internal/session"
+	"time"
+)
+
+// chunkCompressions are the algorithms chunk_compressions may list.
+var chunkCompressions = map[string]bool{"none": true, "gzip": true, "zstd": true}
+
+// Validate checks every setting and returns all problems found.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+	positive := func(key string, d time.Duration) {
+		if d <= 0 {
+			fail(key, "must be positive, got %s", d)
+		}
+	}
+	atLeast := func(key string, n, min int64) {
+		if n < min {
+			fail(key, "must be at least %d, got %d", min, n)
+		}
+	}
+
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		fail("port", "must be a port number, got %q", c.Port)
+	}
+
+	// Sessions
+	positive("session_ttl", c.SessionTTL)
+	positive("session_cleanup_interval", c.SessionCleanupInterval)
+	atLeast("chunk_size", int64(c.ChunkSize), 1)
+	atLeast("max_file_size", c.MaxFileSize, 1)
+	if _, err := session.NewCodeGenerator(c.CodeFormat, c.CodeWords); err != nil {
+		fail("code_format", "%v", err)
+	}
+
+	if c.StaticDir != "" {
+		if info, err := os.Stat(c.StaticDir); err != nil || !info.IsDir() {
+			fail("static_dir", "must be a directory, got %q", c.StaticDir)
+		}
+	}
+	if _, err := origin.New(c.AllowedOrigins); err != nil {
+		fail("allowed_origins", "%v", err)
+	}
+	if _, err := realip.New(c.TrustedProxies); err != nil {
+		fail("trusted_proxies", "%v", err)
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("public_url", "must be an http or https URL, got %q", c.PublicURL)
+		}
+	}
+	for _, s := range c.ICEServers {
+		scheme, _, _ := strings.Cut(s, ":")
+		if scheme != "stun" && scheme != "stuns" && scheme != "turn" && scheme != "turns" {
+			fail("ice_servers", "%q is not a stun: or turn: URL", s)
+		}
+	}
+
+	// WebSocket
+	for _, algo := range c.ChunkCompressions {
+		if !chunkCompressions[algo] {
+			fail("chunk_compressions", "unknown algorithm %q", algo)
+		}
+	}
+	atLeast("max_streams", int64(c.MaxStreams), 1)
+	positive("ws_write_wait", c.WSWriteWait)
+	positive("ws_pong_wait", c.WSPongWait)
+	if c.WSPingPeriod < 0 || (c.WSPingPeriod > 0 && c.WSPingPeriod >= c.WSPongWait) {
+		fail("ws_ping_period", "must be less than ws_pong_wait (%s), got %s", c.WSPongWait, c.WSPingPeriod)
+	}
+	positive("ws_register_wait", c.WSRegisterWait)
+	atLeast("ws_max_message_size", c.WSMaxMessageSize, 1024)
+	// Chunks travel base64 encoded inside JSON.
+	if need := int64(c.ChunkSize)*4/3 + 1024; c.WSMaxMessageSize < need {
+		fail("ws_max_message_size", "must fit a %d byte chunk, at least %d", c.ChunkSize, need)
+	}
+	atLeast("ws_read_buffer_size", int64(c.WSReadBufferSize), 1)
+	atLeast("ws_write_buffer_size", int64(c.WSWriteBufferSize), 1)
+	atLeast("ws_send_queue", int64(c.WSSendQueue), 1)
+	atLeast("ws_replay_buffer", int64(c.WSReplayBuffer), 1)
+	positive("ws_replay_grace", c.WSReplayGrace)
+
+	// Limits
+	atLeast("rate_limit_global", c.RateLimitGlobal, 0)
+	atLeast("rate_limit_session", c.RateLimitSession, 0)
+	atLeast("rate_limit_ip", c.RateLimitIP, 0)
+	atLeast("max_sessions_per_ip", int64(c.MaxSessionsPerIP), 0)
+	atLeast("max_concurrent_pairs", int64(c.MaxConcurrentPairs), 0)
+	atLeast("max_daily_bytes_per_ip", c.MaxDailyBytesPerIP, 0)
+
+	// Authentication
+	if c.OIDCJWKSFile != "" && c.OIDCJWKSURL != "" {
+		fail("oidc_jwks_url", "set either oidc_jwks_file or oidc_jwks_url, not both")
+	}
+	if c.OIDCJWKSURL != "" {
+		if u, err := url.Parse(c.OIDCJWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("oidc_jwks_url", "must be an http or https URL, got %q", c.OIDCJWKSURL)
+		}
+	}
+	noAuth := c.APIKeysFile == "" && c.OIDCJWKSFile == "" && c.OIDCJWKSURL == ""
+	if c.RequireAPIKey && noAuth {
+		fail("require_api_key", "needs api_keys_file or an OIDC JWKS")
+	}
+	if c.RequireSenderAuth && noAuth {
+		fail("require_sender_auth", "needs api_keys_file or an OIDC JWKS")
+	}
+
+	// TURN
+	if c.TURNEnabled {
+		if _, port, err := net.SplitHostPort(c.TURNListenAddr); err != nil || port == "" {
+			fail("turn_listen_addr", "must be host:port, got %q", c.TURNListenAddr)
+		}
+		// Peers dial the advertised address, so it must be reachable from
+		// outside this host.
+		switch ip := net.ParseIP(c.TURNPublicIP); {
+		case c.TURNPublicIP == "":
+			fail("turn_public_ip", "is required with turn_enabled")
+		case ip == nil:
+			fail("turn_public_ip", "must be an IP address, got %q", c.TURNPublicIP)
+		case ip.IsLoopback() || ip.IsUnspecified():
+			fail("turn_public_ip", "must be reachable by peers, got %q", c.TURNPublicIP)
+		}
+		positive("turn_credential_ttl", c.TURNCredentialTTL)
+		for _, cidr := range c.TURNAllowedPeers {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				fail("turn_allowed_peers", "must be CIDRs, got %q", cidr)
+			}
+		}
+	}
+	if c.TURNMinPort < 0 || c.TURNMaxPort > 65535 {
+		fail("turn_min_port", "relay ports must be between 0 and 65535")
+	} else if (c.TURNMinPort == 0) != (c.TURNMaxPort == 0) || c.TURNMinPort > c.TURNMaxPort {
+		fail("turn_min_port", "turn_min_port and turn_max_port must both be set with min <= max")
+	}
+
+	// TLS
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		fail("tls_cert_file", "tls_cert_file and tls_key_file must be set together")
+	}
+	if c.TLSCertFile != "" && len(c.ACMEDomains) > 0 {
+		fail("acme_domains", "set either tls_cert_file or acme_domains, not both")
+	}
+	if len(c.ACMEDomains) > 0 && c.ACMECacheDir == "" {
+		fail("acme_cache_dir", "is required with acme_domains")
+	}
+	if c.ACMEDirectoryURL != "" {
+		if u, err := url.Parse(c.ACMEDirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			fail("acme_directory_url", "must be an https URL, got %q", c.ACMEDirectoryURL)
+		}
+	}
+	if c.HTTPRedirectAddr != "" {
+		if !c.TLSEnabled() {
+			fail("http_redirect_addr", "needs tls_cert_file or acme_domains")
+		}
+		if _, port, err := net.SplitHostPort(c.HTTPRedirectAddr); err != nil || port == "" {
+			fail("http_redirect_addr", "must be host:port, got %q", c.HTTPRedirectAddr)
+		}
+	}
+
+	// HTTP versions
+	if c.H2C && c.TLSEnabled() {
+		fail("h2c", "is for cleartext servers, HTTPS negotiates HTTP/2 itself")
+	}
+	if c.HTTP3Addr != "" {
+		if !c.TLSEnabled() {
+			fail("http3_addr", "needs tls_cert_file or acme_domains")
+		}
+		if _, port, err := net.SplitHostPort(c.HTTP3Addr); err != nil || port == "" {
+			fail("http3_addr", "must be host:port, got %q", c.HTTP3Addr)
+		}
+	}
+
+	return errors.Join(errs...)
+}
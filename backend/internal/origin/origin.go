@@ -0,0 +1,133 @@
+// Package origin decides which browser origins may call the API and open
+// WebSockets.
+package origin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+var ErrInvalidPattern = errors.New("invalid origin pattern")
+
+// Matcher checks origins against a list of patterns. A pattern is an exact
+// origin ("https://app.example.com"), an origin with a wildcard in place of
+// the leftmost label ("https://*.example.com", matching any subdomain but
+// not example.com itself), or "*" to allow every origin.
+//
+// The patterns can be replaced with Update while the Matcher is in use. A
+// nil Matcher allows no origins, leaving only same-origin requests.
+type Matcher struct {
+	rules atomic.Pointer[rules]
+}
+
+type rules struct {
+	exact    map[string]bool
+	suffixes []wildcard
+	any      bool
+}
+
+type wildcard struct {
+	scheme string
+	suffix string // ".example.com" plus the port, if any
+}
+
+// New compiles patterns into a Matcher.
+func New(patterns []string) (*Matcher, error) {
+	m := &Matcher{}
+	if err := m.Update(patterns); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Update replaces the Matcher's patterns. On error the old patterns stay
+// in effect.
+func (m *Matcher) Update(patterns []string) error {
+	rs, err := compile(patterns)
+	if err != nil {
+		return err
+	}
+	m.rules.Store(rs)
+	return nil
+}
+
+func compile(patterns []string) (*rules, error) {
+	rs := &rules{exact: make(map[string]bool)}
+
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "*" {
+			rs.any = true
+			continue
+		}
+
+		u, err := url.Parse(p)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPattern, p)
+		}
+
+		host := u.Host
+		if rest, ok := strings.CutPrefix(host, "*."); ok {
+			if rest == "" || strings.Contains(rest, "*") {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidPattern, p)
+			}
+			rs.suffixes = append(rs.suffixes, wildcard{scheme: u.Scheme, suffix: "." + rest})
+			continue
+		}
+		if strings.Contains(host, "*") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPattern, p)
+		}
+		rs.exact[u.Scheme+"://"+host] = true
+	}
+
+	return rs, nil
+}
+
+// Allowed reports whether origin matches one of the patterns.
+func (m *Matcher) Allowed(origin string) bool {
+	if m == nil {
+		return false
+	}
+	rs := m.rules.Load()
+	if rs.any {
+		return true
+	}
+
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if rs.exact[u.Scheme+"://"+u.Host] {
+		return true
+	}
+
+	for _, w := range rs.suffixes {
+		if u.Scheme != w.scheme || !strings.HasSuffix(u.Host, w.suffix) {
+			continue
+		}
+		// The wildcard stands for exactly one or more whole labels.
+		if label := strings.TrimSuffix(u.Host, w.suffix); label != "" && !strings.ContainsAny(label, ":/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRequest reports whether a request may proceed. Requests without an
+// Origin header come from non-browser clients and same-origin requests are
+// from our own frontend, so both are allowed.
+func (m *Matcher) CheckRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return m.Allowed(origin)
+}
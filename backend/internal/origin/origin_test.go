@@ -0,0 +1,152 @@
+package origin
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	m, err := New([]string{
+		"http://localhost:5173",
+		"https://app.example.com",
+		"https://*.corp.example",
+		"https://*.staging.example:8443",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"http://localhost:5173", true},
+		{"HTTP://LOCALHOST:5173", true},
+		{"https://app.example.com", true},
+		{"https://files.corp.example", true},
+		{"https://a.b.corp.example", true},
+		{"https://web.staging.example:8443", true},
+
+		{"http://localhost:3000", false},
+		{"https://localhost:5173", false},
+		{"http://app.example.com", false},
+		{"https://evil.example.com", false},
+		{"https://app.example.com.evil.com", false},
+		{"https://corp.example", false},
+		{"https://evilcorp.example", false},
+		{"http://files.corp.example", false},
+		{"https://web.staging.example", false},
+		{"https://web.staging.example:9443", false},
+		{"null", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := m.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedAny(t *testing.T) {
+	m, err := New([]string{"*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Allowed("https://anything.example") {
+		t.Error("* should allow every origin")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, p := range []string{
+		"example.com",
+		"ftp://example.com",
+		"https://",
+		"https://example.com/path",
+		"https://*.",
+		"https://a.*.example.com",
+		"https://*.*.example.com",
+		"https://user@example.com",
+	} {
+		if _, err := New([]string{p}); !errors.Is(err, ErrInvalidPattern) {
+			t.Errorf("New(%q) error = %v, want ErrInvalidPattern", p, err)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	m, err := New([]string{"https://old.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Update([]string{"https://new.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if m.Allowed("https://old.example") || !m.Allowed("https://new.example") {
+		t.Error("Update did not replace the patterns")
+	}
+
+	if err := m.Update([]string{"https://newer.example", "bogus"}); !errors.Is(err, ErrInvalidPattern) {
+		t.Fatalf("Update error = %v, want ErrInvalidPattern", err)
+	}
+	if !m.Allowed("https://new.example") || m.Allowed("https://newer.example") {
+		t.Error("a failed Update should keep the old patterns")
+	}
+}
+
+func TestCheckRequest(t *testing.T) {
+	m, err := New([]string{"https://app.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   bool
+	}{
+		{"no origin", "api.example.com", "", true},
+		{"same origin", "api.example.com", "https://api.example.com", true},
+		{"allowed origin", "api.example.com", "https://app.example.com", true},
+		{"foreign origin", "api.example.com", "https://evil.example", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws/ABC-DEF", nil)
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := m.CheckRequest(r); got != tt.want {
+			t.Errorf("%s: CheckRequest = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNilMatcher(t *testing.T) {
+	var m *Matcher
+	if m.Allowed("https://app.example.com") {
+		t.Error("nil Matcher allowed an origin")
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://api.example.com", true},
+		{"https://app.example.com", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws/ABC-DEF", nil)
+		r.Host = "api.example.com"
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := m.CheckRequest(r); got != tt.want {
+			t.Errorf("origin %q: CheckRequest = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
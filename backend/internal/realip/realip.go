@@ -0,0 +1,129 @@
+// Package realip resolves the client address of requests that reach the
+// server through reverse proxies. Forwarding headers are only believed when
+// the connection comes from a trusted proxy; anyone else could set them to
+// dodge per-IP quotas and rate limits.
+package realip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var ErrInvalidCIDR = errors.New("invalid trusted proxy")
+
+// forwardingHeaders are removed from requests that do not come from a
+// trusted proxy, so handlers further down may rely on what is left.
+var forwardingHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+}
+
+// Resolver knows which peers are trusted proxies. A nil Resolver trusts
+// nobody.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// New returns a Resolver trusting proxies in the given CIDRs. Bare IP
+// addresses are accepted as single-host ranges.
+func New(cidrs []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, c)
+			}
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, c)
+		}
+		r.trusted = append(r.trusted, n)
+	}
+	return r, nil
+}
+
+// Trusted reports whether ip belongs to a trusted proxy.
+func (r *Resolver) Trusted(ip net.IP) bool {
+	if r == nil || ip == nil {
+		return false
+	}
+	for _, n := range r.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler sets the request's RemoteAddr to the client address when the
+// request comes from a trusted proxy and strips forwarding headers when it
+// does not.
+func (r *Resolver) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		peer := net.ParseIP(host(req.RemoteAddr))
+		if !r.Trusted(peer) {
+			for _, h := range forwardingHeaders {
+				req.Header.Del(h)
+			}
+		} else if ip := r.clientIP(req.Header, peer); !ip.Equal(peer) {
+			req.RemoteAddr = ip.String()
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// clientIP walks X-Forwarded-For from the nearest hop outwards and returns
+// the first address that is not a trusted proxy, falling back to X-Real-IP
+// and then to peer.
+func (r *Resolver) clientIP(header http.Header, peer net.IP) net.IP {
+	var hops []string
+	for _, v := range header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(header.Get("X-Real-Ip"))); ip != nil {
+			return ip
+		}
+		return peer
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // garbage from beyond the proxies; stop at the last good hop
+		}
+		client = ip
+		if !r.Trusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// ClientIP returns the address of the client that sent r. Behind Handler
+// this is the address resolved from trusted proxies' forwarding headers;
+// otherwise it is the connecting peer.
+func ClientIP(r *http.Request) string {
+	return host(r.RemoteAddr)
+}
+
+// host returns the host part of addr, or addr itself if it has no port.
+func host(addr string) string {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return h
+}
@@ -0,0 +1,120 @@
+package realip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	r, err := New([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		xff     []string
+		realIP  string
+		want    string
+		headers bool // forwarding headers kept
+	}{
+		{"direct client", "198.51.100.7:4000", nil, "", "198.51.100.7:4000", false},
+		{"spoofed by a client", "198.51.100.7:4000", []string{"203.0.113.9"}, "203.0.113.9", "198.51.100.7:4000", false},
+		{"trusted proxy", "10.1.2.3:4000", []string{"203.0.113.9"}, "", "203.0.113.9", true},
+		{"single trusted host", "192.0.2.1:4000", []string{"203.0.113.9"}, "", "203.0.113.9", true},
+		{"chain of proxies", "10.1.2.3:4000", []string{"203.0.113.9, 10.0.0.5", "10.0.0.6"}, "", "203.0.113.9", true},
+		{"client prepends a fake hop", "10.1.2.3:4000", []string{"1.1.1.1, 203.0.113.9"}, "", "203.0.113.9", true},
+		{"only proxies", "10.1.2.3:4000", []string{"10.0.0.5"}, "", "10.0.0.5", true},
+		{"garbage hop", "10.1.2.3:4000", []string{"junk, 10.0.0.5"}, "", "10.0.0.5", true},
+		{"X-Real-IP", "10.1.2.3:4000", nil, "203.0.113.9", "203.0.113.9", true},
+		{"no headers", "10.1.2.3:4000", nil, "", "10.1.2.3:4000", true},
+		{"untrusted neighbour of a trusted host", "192.0.2.2:4000", []string{"203.0.113.9"}, "", "192.0.2.2:4000", false},
+	}
+
+	for _, tt := range tests {
+		var got *http.Request
+		h := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got = req
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		for _, v := range tt.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		req.Header.Set("X-Forwarded-Proto", "https")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got.RemoteAddr != tt.want {
+			t.Errorf("%s: RemoteAddr = %q, want %q", tt.name, got.RemoteAddr, tt.want)
+		}
+		if kept := got.Header.Get("X-Forwarded-Proto") != ""; kept != tt.headers {
+			t.Errorf("%s: forwarding headers kept = %v, want %v", tt.name, kept, tt.headers)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r, err := New([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"direct client", "198.51.100.7:4000", "", "198.51.100.7"},
+		{"spoofed by a client", "198.51.100.7:4000", "203.0.113.9", "198.51.100.7"},
+		{"trusted proxy", "10.1.2.3:4000", "203.0.113.9", "203.0.113.9"},
+		{"IPv6 peer", "[2001:db8::1]:4000", "", "2001:db8::1"},
+		{"no port", "198.51.100.7", "", "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		var got string
+		h := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got = ClientIP(req)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNilResolver(t *testing.T) {
+	var r *Resolver
+	h := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.RemoteAddr != "10.1.2.3:4000" || req.Header.Get("X-Forwarded-For") != "" {
+			t.Errorf("nil resolver trusted headers: %s %v", req.RemoteAddr, req.Header)
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.2.3:4000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/33", "proxy.example.com", ""} {
+		if _, err := New([]string{cidr}); !errors.Is(err, ErrInvalidCIDR) {
+			t.Errorf("New(%q) error = %v, want %v", cidr, err, ErrInvalidCIDR)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package session
+
+import "time"
+
+// Clock tells the time sessions are created and expire by. Tests
+// substitute a fake to move time forward.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,225 @@
+package session
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCodeFormat = errors.New("invalid code format")
+
+// CodeGenerator produces share codes and maps user input back onto them.
+type CodeGenerator interface {
+	// Generate returns a new random code in canonical form.
+	Generate() (string, error)
+	// Normalize converts user input into the canonical form of a code,
+	// correcting case, separators and small typos where it can.
+	Normalize(input string) string
+}
+
+// NewCodeGenerator returns the generator for the given format: "alphabet"
+// (the default XXX-XXX codes) or "words" with the given number of words.
+func NewCodeGenerator(format string, words int) (CodeGenerator, error) {
+	switch format {
+	case "", "alphabet":
+		return AlphabetCodes{}, nil
+	case "words":
+		return NewWordCodes(words)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidCodeFormat, format)
+	}
+}
+
+// AlphabetCodes generates XXX-XXX codes from an alphabet without confusable
+// characters.
+type AlphabetCodes struct{}
+
+func (AlphabetCodes) Generate() (string, error) {
+	code := make([]byte, 6)
+	for i := range code {
+		n, err := randIntn(len(alphabet))
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n]
+	}
+
+	// Format as XXX-XXX
+	return string(code[:3]) + "-" + string(code[3:]), nil
+}
+
+func (AlphabetCodes) Normalize(input string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+
+	code := b.String()
+	if len(code) != 6 {
+		return strings.ToUpper(strings.TrimSpace(input))
+	}
+	return code[:3] + "-" + code[3:]
+}
+
+// WordCodes generates codes such as 7-purple-river-lamp: a number followed by
+// words from a fixed list. Each word adds 8 bits of entropy.
+type WordCodes struct {
+	words int
+	index map[string]struct{}
+}
+
+const (
+	minCodeWords = 2
+	maxCodeWords = 8
+	codeNumbers  = 100
+)
+
+func NewWordCodes(words int) (*WordCodes, error) {
+	if words < minCodeWords || words > maxCodeWords {
+		return nil, fmt.Errorf("%w: word count must be between %d and %d", ErrInvalidCodeFormat, minCodeWords, maxCodeWords)
+	}
+
+	index := make(map[string]struct{}, len(wordlist))
+	for _, w := range wordlist {
+		index[w] = struct{}{}
+	}
+
+	return &WordCodes{words: words, index: index}, nil
+}
+
+func (g *WordCodes) Generate() (string, error) {
+	n, err := randIntn(codeNumbers)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, g.words+1)
+	parts = append(parts, strconv.Itoa(n))
+	for i := 0; i < g.words; i++ {
+		w, err := randIntn(len(wordlist))
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, wordlist[w])
+	}
+
+	return strings.Join(parts, "-"), nil
+}
+
+// Normalize lowercases the input, accepts any run of spaces, dashes, dots or
+// underscores as a separator and replaces each unknown word with the only
+// list word within one edit of it.
+func (g *WordCodes) Normalize(input string) string {
+	fields := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return r == '-' || r == ' ' || r == '_' || r == '.'
+	})
+	if len(fields) == 0 {
+		return ""
+	}
+
+	for i, f := range fields {
+		if i == 0 {
+			continue
+		}
+		if _, ok := g.index[f]; ok {
+			continue
+		}
+		if w, ok := g.closest(f); ok {
+			fields[i] = w
+		}
+	}
+
+	return strings.Join(fields, "-")
+}
+
+// closest returns the single list word within edit distance one of s.
+func (g *WordCodes) closest(s string) (string, bool) {
+	var match string
+	for _, w := range wordlist {
+		if editDistance(s, w) <= 1 {
+			if match != "" {
+				return "", false // ambiguous
+			}
+			match = w
+		}
+	}
+	return match, match != ""
+}
+
+// editDistance computes the optimal string alignment distance between a and
+// b, counting adjacent transpositions as a single edit.
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+
+	return prev[len(b)]
+}
+
+func randIntn(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
+
+// wordlist holds 256 short, distinct, easy to pronounce words. No two words
+// are within one edit of each other so typo correction is unambiguous.
+var wordlist = [256]string{
+	"acid", "acorn", "actor", "agent", "alarm", "album", "alpha", "amber",
+	"anchor", "angle", "apple", "apron", "arena", "arrow", "atlas", "attic",
+	"autumn", "avocado", "badge", "bagel", "bakery", "balcony", "bamboo", "banjo",
+	"barrel", "basket", "beacon", "beaver", "bishop", "blanket", "blossom", "bonfire",
+	"border", "bottle", "bracket", "breeze", "bridge", "bronze", "bucket", "buffalo",
+	"bundle", "butter", "cabin", "cactus", "camera", "candle", "canoe", "canyon",
+	"carbon", "carpet", "castle", "cellar", "cement", "cherry", "chimney", "circus",
+	"citrus", "clover", "cobalt", "coconut", "comet", "copper", "coral", "cotton",
+	"cowboy", "crayon", "cricket", "crystal", "cuckoo", "dagger", "daisy", "dancer",
+	"delta", "desert", "diamond", "dolphin", "donkey", "dragon", "drum", "eagle",
+	"echo", "eclipse", "elbow", "emerald", "engine", "falcon", "feather", "fender",
+	"ferry", "fiddle", "finch", "flame", "flute", "forest", "fossil", "fountain",
+	"fox", "galaxy", "garden", "garlic", "gazelle", "ginger", "glacier", "goblin",
+	"gopher", "granite", "guitar", "hammer", "harbor", "harvest", "hazel", "helmet",
+	"hermit", "hockey", "honey", "horizon", "husky", "igloo", "indigo", "island",
+	"ivory", "jacket", "jaguar", "jasmine", "jelly", "jigsaw", "jungle", "kayak",
+	"kettle", "kitten", "koala", "ladder", "lagoon", "lamp", "lantern", "laptop",
+	"lattice", "lemon", "lentil", "lizard", "lobster", "magnet", "mammoth", "mango",
+	"maple", "marble", "meadow", "meerkat", "melon", "meteor", "mirror", "mosaic",
+	"muffin", "mustard", "napkin", "nebula", "nectar", "needle", "noodle", "nutmeg",
+	"oasis", "ocean", "olive", "onion", "orbit", "orchid", "otter", "oyster",
+	"paddle", "palace", "panda", "paper", "parrot", "peanut", "pebble", "pelican",
+	"pepper", "piano", "pickle", "pigeon", "pillow", "pirate", "planet", "pony",
+	"potato", "pretzel", "prism", "pumpkin", "purple", "puzzle", "quartz", "quiver",
+	"rabbit", "radar", "radish", "raven", "ribbon", "river", "robot", "rocket",
+	"salmon", "sandal", "satin", "scarf", "sequoia", "shadow", "sherbet", "silver",
+	"sketch", "sleigh", "sparrow", "spider", "spinach", "sponge", "squid", "statue",
+	"summit", "sunset", "swan", "tablet", "teapot", "temple", "thunder", "tiger",
+	"timber", "toffee", "tomato", "topaz", "tornado", "tractor", "trumpet", "tulip",
+	"tundra", "turtle", "tuxedo", "umbrella", "unicorn", "valley", "velvet", "violin",
+	"volcano", "voyage", "waffle", "walnut", "walrus", "wander", "weasel", "whistle",
+	"window", "wombat", "yacht", "yogurt", "zebra", "zenith", "zigzag", "zipper",
+}
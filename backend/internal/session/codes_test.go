@@ -0,0 +1,223 @@
+package session
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWordlist(t *testing.T) {
+	if !slices.IsSorted(wordlist[:]) {
+		t.Error("wordlist is not sorted")
+	}
+	for i, a := range wordlist {
+		for _, b := range wordlist[i+1:] {
+			if d := editDistance(a, b); d <= 1 {
+				t.Errorf("%q and %q are %d edit apart", a, b, d)
+			}
+		}
+	}
+}
+
+func TestNewCodeGenerator(t *testing.T) {
+	tests := []struct {
+		format string
+		words  int
+		err    bool
+	}{
+		{"", 0, false},
+		{"alphabet", 0, false},
+		{"words", minCodeWords, false},
+		{"words", maxCodeWords, false},
+		{"words", minCodeWords - 1, true},
+		{"words", maxCodeWords + 1, true},
+		{"emoji", 3, true},
+	}
+	for _, tt := range tests {
+		_, err := NewCodeGenerator(tt.format, tt.words)
+		if (err != nil) != tt.err {
+			t.Errorf("NewCodeGenerator(%q, %d) error = %v, want error %v", tt.format, tt.words, err, tt.err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidCodeFormat) {
+			t.Errorf("NewCodeGenerator(%q, %d) error = %v, want %v", tt.format, tt.words, err, ErrInvalidCodeFormat)
+		}
+	}
+}
+
+func TestWordCodesGenerate(t *testing.T) {
+	for _, words := range []int{minCodeWords, 3, maxCodeWords} {
+		g, err := NewWordCodes(words)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			code, err := g.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parts := strings.Split(code, "-")
+			if len(parts) != words+1 {
+				t.Fatalf("%d words: %q has %d parts", words, code, len(parts))
+			}
+			if n, err := strconv.Atoi(parts[0]); err != nil || n < 0 || n >= codeNumbers {
+				t.Fatalf("%d words: %q does not start with a number below %d", words, code, codeNumbers)
+			}
+			for _, w := range parts[1:] {
+				if _, ok := g.index[w]; !ok {
+					t.Fatalf("%d words: %q contains %q, which is not on the list", words, code, w)
+				}
+			}
+			if got := g.Normalize(code); got != code {
+				t.Fatalf("%d words: Normalize(%q) = %q", words, code, got)
+			}
+		}
+	}
+}
+
+func TestWordCodesNormalize(t *testing.T) {
+	g, err := NewWordCodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"canonical", "7-purple-river-lamp", "7-purple-river-lamp"},
+		{"upper case", "7-PURPLE-River-LAMP", "7-purple-river-lamp"},
+		{"spaces", "  7 purple  river lamp ", "7-purple-river-lamp"},
+		{"mixed separators", "7_purple.river--lamp", "7-purple-river-lamp"},
+		{"substitution", "7-purple-rivet-lamp", "7-purple-river-lamp"},
+		{"insertion", "7-purrple-river-lamp", "7-purple-river-lamp"},
+		{"deletion", "7-purple-rver-lamp", "7-purple-river-lamp"},
+		{"transposition", "7-puprle-river-lmap", "7-purple-river-lamp"},
+		{"typo in every word", "7-purpel-rivr-lamps", "7-purple-river-lamp"},
+		// mapple is one edit from both apple and maple.
+		{"ambiguous", "7-mapple-river-lamp", "7-mapple-river-lamp"},
+		{"two edits", "7-prupel-river-lamp", "7-prupel-river-lamp"},
+		// The number is never corrected, even if it looks like a word.
+		{"number", "lamb-purple-river-lamp", "lamb-purple-river-lamp"},
+		{"empty", " - ", ""},
+	}
+	for _, tt := range tests {
+		if got := g.Normalize(tt.input); got != tt.want {
+			t.Errorf("%s: Normalize(%q) = %q, want %q", tt.name, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"lamp", "lamp", 0},
+		{"", "lamp", 4},
+		{"lamp", "lamb", 1},
+		{"lamp", "lam", 1},
+		{"lamp", "clamp", 1},
+		{"lamp", "lmap", 1},
+		{"lamp", "mlap", 2},
+		{"river", "rvier", 1},
+		{"purple", "prupel", 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := editDistance(tt.b, tt.a); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+// sequenceCodes hands out the given codes in order, then repeats the last.
+type sequenceCodes struct {
+	AlphabetCodes
+	codes []string
+	calls int
+}
+
+func (g *sequenceCodes) Generate() (string, error) {
+	code := g.codes[min(g.calls, len(g.codes)-1)]
+	g.calls++
+	return code, nil
+}
+
+func TestManagerCodeCollisions(t *testing.T) {
+	g := &sequenceCodes{codes: []string{"AAA-AAA", "AAA-AAA", "AAA-AAA", "BBB-BBB"}}
+	m := NewManager(Options{TTL: time.Minute, Codes: g})
+
+	first, err := m.Create(CreateParams{FileName: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.Create(CreateParams{FileName: "b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Code != "AAA-AAA" || second.Code != "BBB-BBB" {
+		t.Errorf("codes = %s, %s, want AAA-AAA, BBB-BBB", first.Code, second.Code)
+	}
+	if g.calls != 4 {
+		t.Errorf("Generate called %d times, want 4", g.calls)
+	}
+
+	// Once every code the generator produces is taken, Create gives up.
+	g.calls = 0
+	if _, err := m.Create(CreateParams{FileName: "c.txt"}); !errors.Is(err, ErrCodeTaken) {
+		t.Errorf("exhausted: Create error = %v, want %v", err, ErrCodeTaken)
+	}
+	if g.calls != maxCodeAttempts {
+		t.Errorf("exhausted: Generate called %d times, want %d", g.calls, maxCodeAttempts)
+	}
+}
+
+// fixedWordCodes generates the same word code every time.
+type fixedWordCodes struct{ *WordCodes }
+
+func (fixedWordCodes) Generate() (string, error) {
+	return "7-purple-river-lamp", nil
+}
+
+func TestManagerLookupWordCodes(t *testing.T) {
+	g, err := NewWordCodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(Options{TTL: time.Minute, Codes: fixedWordCodes{g}})
+	sess, err := m.Create(CreateParams{FileName: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		err  error
+	}{
+		{"exact", "7-purple-river-lamp", nil},
+		{"spoken", "7 Purple River Lamp", nil},
+		{"typo", "7-purpel-river-lamp", nil},
+		{"wrong number", "8-purple-river-lamp", ErrSessionNotFound},
+		{"wrong word", "7-purple-ocean-lamp", ErrSessionNotFound},
+		{"missing word", "7-purple-river", ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		got, err := m.GetByCode(tt.code)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: GetByCode(%q) error = %v, want %v", tt.name, tt.code, err, tt.err)
+			continue
+		}
+		if err == nil && got != sess {
+			t.Errorf("%s: GetByCode(%q) returned another session", tt.name, tt.code)
+		}
+	}
+}
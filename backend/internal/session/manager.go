@@ -17,62 +17,216 @@ type Manager struct {
 	sessions map[string]*Session // code -> session
 	byID     map[string]*Session // id -> session
 	ttl      time.Duration
+	cleanup  time.Duration
+	clock    Clock
+	codes    CodeGenerator
+	reserved *Reservations
+	quotas   Quotas
+	usage    dailyUsage
+	counters quotaCounters
+	onExpire []func(*Session)
 	mu       sync.RWMutex
 }
 
-func NewManager(ttl time.Duration) *Manager {
+// maxCodeAttempts bounds how many random codes Create tries before giving up.
+// Collisions are rare in any reasonably sized code space, so hitting this
+// limit means the space is close to exhausted.
+const maxCodeAttempts = 1000
+
+// Options configures a Manager.
+type Options struct {
+	TTL time.Duration
+	// CleanupInterval is how often expired sessions are removed. Zero
+	// selects one minute.
+	CleanupInterval time.Duration
+	// Clock is what sessions are created and expire by. Nil selects the
+	// system clock.
+	Clock Clock
+	// Codes generates random share codes. Nil selects the default XXX-XXX
+	// format.
+	Codes CodeGenerator
+	// Reservations holds vanity codes. Nil disables vanity codes.
+	Reservations *Reservations
+	// Quotas limits per-IP usage.
+	Quotas Quotas
+}
+
+func NewManager(opts Options) *Manager {
+	codes := opts.Codes
+	if codes == nil {
+		codes = AlphabetCodes{}
+	}
+	cleanup := opts.CleanupInterval
+	if cleanup <= 0 {
+		cleanup = time.Minute
+	}
+	clock := opts.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
 	return &Manager{
 		sessions: make(map[string]*Session),
 		byID:     make(map[string]*Session),
-		ttl:      ttl,
+		ttl:      opts.TTL,
+		cleanup:  cleanup,
+		clock:    clock,
+		codes:    codes,
+		reserved: opts.Reservations,
+		quotas:   opts.Quotas,
 	}
 }
 
+// SetLimits replaces the session TTL and quotas. Live sessions keep their
+// expiry; the new values apply to sessions created from now on and to
+// quota checks.
+func (m *Manager) SetLimits(ttl time.Duration, quotas Quotas) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ttl = ttl
+	m.quotas = quotas
+}
+
 type CreateParams struct {
 	FileName string
 	FileSize int64
 	MimeType string
+	// VanityCode binds the session to a reserved code instead of a random
+	// one. OwnerToken must be the token returned when it was reserved.
+	VanityCode string
+	OwnerToken string
+	// SpeedLimit caps relay bandwidth in bytes per second; zero means
+	// only server limits apply.
+	SpeedLimit int64
+	// OwnerIP is the address the session was created from, counted
+	// against its per-IP session quota.
+	OwnerIP string
+	// Owner is the authenticated creator, nil if anonymous.
+	Owner *Owner
+	// Recipients is the normalized receiver allowlist.
+	Recipients []string
 }
 
 func (m *Manager) Create(params CreateParams) (*Session, error) {
+	session, replaced, err := m.create(params)
+	if replaced != nil {
+		m.notifyExpired([]*Session{replaced})
+	}
+	return session, err
+}
+
+// create adds a session and returns any session it replaced on a vanity
+// code.
+func (m *Manager) create(params CreateParams) (*Session, *Session, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Generate unique code
+	if limit := m.quotas.MaxSessionsPerIP; limit > 0 && params.OwnerIP != "" && m.sessionsFrom(params.OwnerIP) >= limit {
+		m.counters.sessionsRejected.Add(1)
+		return nil, nil, ErrQuotaExceeded
+	}
+
 	var code string
-	for i := 0; i < 10; i++ {
-		code = GenerateCode()
-		if _, exists := m.sessions[code]; !exists {
-			break
-		}
-		if i == 9 {
-			return nil, ErrCodeTaken
-		}
+	var replaced *Session
+	var err error
+	if params.VanityCode != "" {
+		code, replaced, err = m.bindVanityCode(params.VanityCode, params.OwnerToken)
+	} else {
+		code, err = m.generateCode()
+	}
+	if err != nil {
+		return nil, nil, err
 	}
 
-	now := time.Now()
+	now := m.clock.Now()
 	session := &Session{
-		ID:        GenerateID(),
-		Code:      code,
-		FileName:  params.FileName,
-		FileSize:  params.FileSize,
-		MimeType:  params.MimeType,
-		Status:    StatusCreated,
-		CreatedAt: now,
-		ExpiresAt: now.Add(m.ttl),
+		ID:         GenerateID(),
+		Code:       code,
+		FileName:   params.FileName,
+		FileSize:   params.FileSize,
+		MimeType:   params.MimeType,
+		SpeedLimit: params.SpeedLimit,
+		Status:     StatusCreated,
+		Transport:  TransportRelay,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(m.ttl),
+		Owner:      params.Owner,
+		Recipients: params.Recipients,
+		ownerIP:    params.OwnerIP,
+		clock:      m.clock,
 	}
 
 	m.sessions[code] = session
 	m.byID[session.ID] = session
 
-	return session, nil
+	return session, replaced, nil
 }
 
+// generateCode returns a code not used by any live session. Callers must
+// hold m.mu.
+func (m *Manager) generateCode() (string, error) {
+	for i := 0; i < maxCodeAttempts; i++ {
+		code, err := m.codes.Generate()
+		if err != nil {
+			return "", err
+		}
+		if _, exists := m.sessions[code]; exists {
+			continue
+		}
+		if m.reserved != nil && m.reserved.IsReserved(code) {
+			continue
+		}
+		return code, nil
+	}
+	return "", ErrCodeTaken
+}
+
+// bindVanityCode checks ownership of a reserved code and frees it for a new
+// session, replacing any previous session bound to it unless that session is
+// still transferring. The replaced session is returned so its listeners can
+// be told it is gone. Callers must hold m.mu.
+func (m *Manager) bindVanityCode(code, token string) (string, *Session, error) {
+	if m.reserved == nil {
+		return "", nil, ErrReservationNotFound
+	}
+
+	code = NormalizeVanityCode(code)
+	if err := m.reserved.Authorize(code, token); err != nil {
+		return "", nil, err
+	}
+
+	prev, exists := m.sessions[code]
+	if !exists {
+		return code, nil, nil
+	}
+	if !prev.IsExpired() && prev.GetStatus() == StatusTransferring {
+		return "", nil, ErrCodeInUse
+	}
+	delete(m.byID, prev.ID)
+	delete(m.sessions, code)
+
+	return code, prev, nil
+}
+
+// lookup finds a session by user-supplied code, trying the generator's
+// normalization first and vanity code normalization second. Callers must
+// hold m.mu.
+func (m *Manager) lookup(code string) (*Session, bool) {
+	if session, exists := m.sessions[m.codes.Normalize(code)]; exists {
+		return session, true
+	}
+	session, exists := m.sessions[NormalizeVanityCode(code)]
+	return session, exists
+}
+
+// GetByCode looks up a session by its share code. The code is normalized
+// first, so lookups tolerate case differences, missing separators and the
+// typos the code generator can correct.
 func (m *Manager) GetByCode(code string) (*Session, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	session, exists := m.sessions[code]
+	session, exists := m.lookup(code)
 	if !exists {
 		return nil, ErrSessionNotFound
 	}
@@ -104,14 +258,27 @@ func (m *Manager) Delete(code string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if session, exists := m.sessions[code]; exists {
+	if session, exists := m.lookup(code); exists {
 		delete(m.byID, session.ID)
-		delete(m.sessions, code)
+		delete(m.sessions, session.Code)
 	}
 }
 
+// OnExpire registers fn to be called with each session removed because it
+// expired or because its vanity code was bound to a new session. fn runs
+// after the session is gone, on the goroutine that removed it, so it may
+// call back into the manager.
+func (m *Manager) OnExpire(fn func(*Session)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onExpire = append(m.onExpire, fn)
+}
+
+// StartCleanup removes expired sessions every cleanup interval until ctx
+// is done.
 func (m *Manager) StartCleanup(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute)
+	ticker := time.NewTicker(m.cleanup)
 	defer ticker.Stop()
 
 	for {
@@ -126,12 +293,29 @@ func (m *Manager) StartCleanup(ctx context.Context) {
 
 func (m *Manager) cleanupExpired() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
+	var expired []*Session
 	for code, session := range m.sessions {
 		if session.IsExpired() {
 			delete(m.byID, session.ID)
 			delete(m.sessions, code)
+			expired = append(expired, session)
+		}
+	}
+	m.mu.Unlock()
+
+	m.notifyExpired(expired)
+}
+
+// notifyExpired calls the OnExpire listeners for sessions already removed.
+// Callers must not hold m.mu.
+func (m *Manager) notifyExpired(sessions []*Session) {
+	m.mu.RLock()
+	listeners := m.onExpire
+	m.mu.RUnlock()
+
+	for _, session := range sessions {
+		for _, fn := range listeners {
+			fn(session)
 		}
 	}
 }
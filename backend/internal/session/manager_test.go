@@ -0,0 +1,379 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock stands in for the wall clock so tests can move time forward.
+type fakeClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func TestManagerCreate(t *testing.T) {
+	clock := newFakeClock()
+
+	tests := []struct {
+		name   string
+		quotas Quotas
+		params []CreateParams
+		err    error // from the last Create
+	}{
+		{
+			name:   "anonymous",
+			params: []CreateParams{{FileName: "a.txt", FileSize: 10, MimeType: "text/plain"}},
+		},
+		{
+			name: "owner and recipients",
+			params: []CreateParams{{
+				FileName:   "a.txt",
+				FileSize:   10,
+				Owner:      &Owner{Subject: "user-1", Email: "a@example.com"},
+				Recipients: []string{"b@example.com"},
+			}},
+		},
+		{
+			name:   "under the per-IP limit",
+			quotas: Quotas{MaxSessionsPerIP: 2},
+			params: []CreateParams{
+				{FileName: "a.txt", FileSize: 1, OwnerIP: "192.0.2.1"},
+				{FileName: "b.txt", FileSize: 1, OwnerIP: "192.0.2.1"},
+			},
+		},
+		{
+			name:   "over the per-IP limit",
+			quotas: Quotas{MaxSessionsPerIP: 1},
+			params: []CreateParams{
+				{FileName: "a.txt", FileSize: 1, OwnerIP: "192.0.2.1"},
+				{FileName: "b.txt", FileSize: 1, OwnerIP: "192.0.2.1"},
+			},
+			err: ErrQuotaExceeded,
+		},
+		{
+			name:   "limit is per IP",
+			quotas: Quotas{MaxSessionsPerIP: 1},
+			params: []CreateParams{
+				{FileName: "a.txt", FileSize: 1, OwnerIP: "192.0.2.1"},
+				{FileName: "b.txt", FileSize: 1, OwnerIP: "192.0.2.2"},
+			},
+		},
+		{
+			name:   "vanity code without reservations",
+			params: []CreateParams{{FileName: "a.txt", FileSize: 1, VanityCode: "my-files"}},
+			err:    ErrReservationNotFound,
+		},
+	}
+	for _, tt := range tests {
+		m := NewManager(Options{Clock: clock, TTL: 10 * time.Minute, Quotas: tt.quotas})
+
+		var sess *Session
+		var err error
+		for _, params := range tt.params {
+			sess, err = m.Create(params)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: Create error = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		last := tt.params[len(tt.params)-1]
+		if len(sess.Code) != 7 || sess.Code[3] != '-' {
+			t.Errorf("%s: code %q is not in XXX-XXX format", tt.name, sess.Code)
+		}
+		if len(sess.ID) != 32 {
+			t.Errorf("%s: ID %q is not 32 hex digits", tt.name, sess.ID)
+		}
+		if sess.FileName != last.FileName || sess.FileSize != last.FileSize || sess.MimeType != last.MimeType {
+			t.Errorf("%s: file = %q %d %q, want %q %d %q", tt.name,
+				sess.FileName, sess.FileSize, sess.MimeType, last.FileName, last.FileSize, last.MimeType)
+		}
+		if sess.GetStatus() != StatusCreated || sess.GetTransport() != TransportRelay {
+			t.Errorf("%s: status %q transport %q", tt.name, sess.GetStatus(), sess.GetTransport())
+		}
+		if !sess.CreatedAt.Equal(clock.Now()) || !sess.ExpiresAt.Equal(clock.Now().Add(10*time.Minute)) {
+			t.Errorf("%s: created %v expires %v", tt.name, sess.CreatedAt, sess.ExpiresAt)
+		}
+		if sess.Owner != last.Owner || len(sess.Recipients) != len(last.Recipients) {
+			t.Errorf("%s: owner %v recipients %v", tt.name, sess.Owner, sess.Recipients)
+		}
+		if got := m.Stats().ActiveSessions; got != len(tt.params) {
+			t.Errorf("%s: ActiveSessions = %d, want %d", tt.name, got, len(tt.params))
+		}
+	}
+}
+
+func TestManagerCreateUniqueCodes(t *testing.T) {
+	m := NewManager(Options{TTL: time.Minute})
+
+	codes := make(map[string]bool)
+	for i := 0; i < 500; i++ {
+		sess, err := m.Create(CreateParams{FileName: "a.txt", FileSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if codes[sess.Code] {
+			t.Fatalf("code %s handed out twice", sess.Code)
+		}
+		codes[sess.Code] = true
+	}
+}
+
+func TestManagerLookup(t *testing.T) {
+	clock := newFakeClock()
+
+	m := NewManager(Options{Clock: clock, TTL: time.Minute})
+	sess, err := m.Create(CreateParams{FileName: "a.txt", FileSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		err  error
+	}{
+		{"exact", sess.Code, nil},
+		{"lower case", strings.ToLower(sess.Code), nil},
+		{"no separator", strings.ReplaceAll(sess.Code, "-", ""), nil},
+		{"spaces", " " + sess.Code[:3] + " " + sess.Code[4:] + " ", nil},
+		{"unknown", "ZZZ-ZZZ", ErrSessionNotFound},
+		{"truncated", sess.Code[:5], ErrSessionNotFound},
+		{"empty", "", ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		got, err := m.GetByCode(tt.code)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: GetByCode(%q) error = %v, want %v", tt.name, tt.code, err, tt.err)
+			continue
+		}
+		if err == nil && got != sess {
+			t.Errorf("%s: GetByCode(%q) returned another session", tt.name, tt.code)
+		}
+	}
+
+	if got, err := m.GetByID(sess.ID); err != nil || got != sess {
+		t.Errorf("GetByID = %v, %v", got, err)
+	}
+	if _, err := m.GetByID("missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("GetByID(missing) error = %v, want %v", err, ErrSessionNotFound)
+	}
+
+	m.Delete(strings.ToLower(sess.Code))
+	if _, err := m.GetByCode(sess.Code); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("after Delete: GetByCode error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if _, err := m.GetByID(sess.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("after Delete: GetByID error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestManagerExpiry(t *testing.T) {
+	clock := newFakeClock()
+
+	m := NewManager(Options{Clock: clock, TTL: time.Minute})
+	sess, err := m.Create(CreateParams{FileName: "a.txt", FileSize: 1, OwnerIP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		advance time.Duration
+		err     error
+		active  int
+	}{
+		{0, nil, 1},
+		{59 * time.Second, nil, 1},
+		{time.Second, nil, 1}, // expires strictly after ExpiresAt
+		{time.Nanosecond, ErrSessionExpired, 0},
+		{time.Hour, ErrSessionExpired, 0},
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		elapsed := clock.Now().Sub(sess.CreatedAt)
+
+		if _, err := m.GetByCode(sess.Code); !errors.Is(err, tt.err) {
+			t.Errorf("after %s: GetByCode error = %v, want %v", elapsed, err, tt.err)
+		}
+		if _, err := m.GetByID(sess.ID); !errors.Is(err, tt.err) {
+			t.Errorf("after %s: GetByID error = %v, want %v", elapsed, err, tt.err)
+		}
+		if got := m.Stats().ActiveSessions; got != tt.active {
+			t.Errorf("after %s: ActiveSessions = %d, want %d", elapsed, got, tt.active)
+		}
+	}
+
+	// Expired sessions no longer count against the per-IP limit.
+	m.SetLimits(time.Minute, Quotas{MaxSessionsPerIP: 1})
+	if _, err := m.Create(CreateParams{FileName: "b.txt", FileSize: 1, OwnerIP: "192.0.2.1"}); err != nil {
+		t.Errorf("Create after expiry: %v", err)
+	}
+}
+
+func TestManagerCleanup(t *testing.T) {
+	clock := newFakeClock()
+
+	m := NewManager(Options{Clock: clock, TTL: time.Minute})
+	var expired []*Session
+	m.OnExpire(func(s *Session) {
+		// Listeners may use the manager; the session is already gone.
+		if _, err := m.GetByID(s.ID); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("OnExpire: GetByID error = %v, want %v", err, ErrSessionNotFound)
+		}
+		expired = append(expired, s)
+	})
+
+	ttls := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}
+	var sessions []*Session
+	for _, ttl := range ttls {
+		m.SetLimits(ttl, Quotas{})
+		sess, err := m.Create(CreateParams{FileName: "a.txt", FileSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, sess)
+	}
+
+	for i := range ttls {
+		clock.Advance(sessions[i].ExpiresAt.Sub(clock.Now()))
+		m.cleanupExpired()
+		if len(expired) != i {
+			t.Errorf("at %s: %d sessions expired, want %d", ttls[i], len(expired), i)
+		}
+
+		clock.Advance(time.Nanosecond)
+		m.cleanupExpired()
+		if len(expired) != i+1 || expired[i] != sessions[i] {
+			t.Fatalf("just after %s: expired %d sessions, want session %d", ttls[i], len(expired), i)
+		}
+
+		for j, sess := range sessions {
+			_, err := m.GetByID(sess.ID)
+			if j <= i && !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("after %s: session %d error = %v, want %v", ttls[i], j, err, ErrSessionNotFound)
+			}
+			if j > i && err != nil {
+				t.Errorf("after %s: session %d error = %v, want it live", ttls[i], j, err)
+			}
+		}
+	}
+
+	// Deleted sessions are not reported as expired.
+	sess, err := m.Create(CreateParams{FileName: "a.txt", FileSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Delete(sess.Code)
+	clock.Advance(time.Hour)
+	m.cleanupExpired()
+	if len(expired) != len(ttls) {
+		t.Errorf("deleted session reported as expired")
+	}
+}
+
+func TestManagerStartCleanup(t *testing.T) {
+	clock := newFakeClock()
+
+	m := NewManager(Options{Clock: clock, TTL: time.Minute, CleanupInterval: time.Millisecond})
+	expired := make(chan *Session, 1)
+	m.OnExpire(func(s *Session) { expired <- s })
+
+	sess, err := m.Create(CreateParams{FileName: "a.txt", FileSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.StartCleanup(ctx)
+
+	select {
+	case s := <-expired:
+		t.Fatalf("session %s expired early", s.Code)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(2 * time.Minute)
+	select {
+	case s := <-expired:
+		if s != sess {
+			t.Errorf("expired session %s, want %s", s.Code, sess.Code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expired session not removed")
+	}
+	if _, err := m.GetByID(sess.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("after cleanup: GetByID error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestManagerDailyQuota(t *testing.T) {
+	clock := newFakeClock()
+
+	m := NewManager(Options{Clock: clock, TTL: time.Minute, Quotas: Quotas{MaxDailyBytesPerIP: 100}})
+
+	tests := []struct {
+		ip      string
+		n       int64
+		advance time.Duration
+		err     error
+	}{
+		{"192.0.2.1", 60, 0, nil},
+		{"192.0.2.1", 40, 0, nil},
+		{"192.0.2.1", 1, 0, ErrQuotaExceeded},
+		{"192.0.2.2", 100, 0, nil},
+		{"192.0.2.1", 100, 12 * time.Hour, nil}, // next UTC day
+	}
+	for i, tt := range tests {
+		clock.Advance(tt.advance)
+		if err := m.ChargeRelay(tt.ip, tt.n); !errors.Is(err, tt.err) {
+			t.Errorf("charge %d: ChargeRelay(%s, %d) error = %v, want %v", i, tt.ip, tt.n, err, tt.err)
+		}
+	}
+
+	stats := m.Stats()
+	if stats.BytesRejected != 1 || stats.BytesRelayedToday != 100 {
+		t.Errorf("Stats = %+v, want 1 rejection and 100 bytes today", stats)
+	}
+
+	// Refunded bytes can be charged again.
+	m.RefundRelay("192.0.2.1", 30)
+	if err := m.ChargeRelay("192.0.2.1", 30); err != nil {
+		t.Errorf("charge after refund: %v", err)
+	}
+	if err := m.ChargeRelay("192.0.2.1", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("charge past the refund: error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// Refunds never leave credit behind.
+	m.RefundRelay("192.0.2.2", 1000)
+	if err := m.ChargeRelay("192.0.2.2", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ChargeRelay("192.0.2.2", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("charge after refunding too much: error = %v, want %v", err, ErrQuotaExceeded)
+	}
+}
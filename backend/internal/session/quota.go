@@ -0,0 +1,138 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quotas limits how much of the server a single client IP may use. Zero
+// values mean unlimited.
+type Quotas struct {
+	// MaxSessionsPerIP caps the live sessions created from one IP.
+	MaxSessionsPerIP int
+	// MaxDailyBytesPerIP caps the bytes one IP may relay per UTC day.
+	MaxDailyBytesPerIP int64
+}
+
+// Stats is a snapshot of the manager's counters.
+type Stats struct {
+	ActiveSessions int
+	// SessionsRejected counts sessions refused for the per-IP limit.
+	SessionsRejected uint64
+	// BytesRejected counts chunks refused for the daily byte limit.
+	BytesRejected uint64
+	// BytesRelayedToday is the total charged across all IPs today.
+	BytesRelayedToday int64
+}
+
+// dailyUsage tracks bytes relayed per IP, reset at the start of each UTC
+// day.
+type dailyUsage struct {
+	day   string
+	bytes map[string]int64
+	mu    sync.Mutex
+}
+
+// charge adds n bytes to ip's usage for the day of now unless that would
+// exceed limit.
+func (u *dailyUsage) charge(now time.Time, ip string, n, limit int64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if today := now.UTC().Format(time.DateOnly); u.day != today {
+		u.day = today
+		u.bytes = make(map[string]int64)
+	}
+
+	if limit > 0 && u.bytes[ip]+n > limit {
+		return false
+	}
+	u.bytes[ip] += n
+	return true
+}
+
+// refund takes n bytes charged on the day of now back off ip's usage.
+// Charges from an earlier day were already reset.
+func (u *dailyUsage) refund(now time.Time, ip string, n int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.day != now.UTC().Format(time.DateOnly) {
+		return
+	}
+	u.bytes[ip] = max(u.bytes[ip]-n, 0)
+}
+
+func (u *dailyUsage) total(now time.Time) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.day != now.UTC().Format(time.DateOnly) {
+		return 0
+	}
+	var total int64
+	for _, n := range u.bytes {
+		total += n
+	}
+	return total
+}
+
+type quotaCounters struct {
+	sessionsRejected atomic.Uint64
+	bytesRejected    atomic.Uint64
+}
+
+// sessionsFrom counts the live sessions created from ip. Callers must hold
+// m.mu.
+func (m *Manager) sessionsFrom(ip string) int {
+	n := 0
+	for _, session := range m.sessions {
+		if session.ownerIP == ip && !session.IsExpired() {
+			n++
+		}
+	}
+	return n
+}
+
+// ChargeRelay records n bytes relayed on behalf of ip, returning
+// ErrQuotaExceeded if it would take ip over its daily limit.
+func (m *Manager) ChargeRelay(ip string, n int64) error {
+	m.mu.RLock()
+	limit := m.quotas.MaxDailyBytesPerIP
+	m.mu.RUnlock()
+
+	if !m.usage.charge(m.clock.Now(), ip, n, limit) {
+		m.counters.bytesRejected.Add(1)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// RefundRelay returns n bytes charged with ChargeRelay to ip's quota, for
+// data that ended up not being relayed.
+func (m *Manager) RefundRelay(ip string, n int64) {
+	m.usage.refund(m.clock.Now(), ip, n)
+}
+
+// Stats returns the manager's current counters.
+func (m *Manager) Stats() Stats {
+	m.mu.RLock()
+	active := 0
+	for _, session := range m.sessions {
+		if !session.IsExpired() {
+			active++
+		}
+	}
+	m.mu.RUnlock()
+
+	return Stats{
+		ActiveSessions:    active,
+		SessionsRejected:  m.counters.sessionsRejected.Load(),
+		BytesRejected:     m.counters.bytesRejected.Load(),
+		BytesRelayedToday: m.usage.total(m.clock.Now()),
+	}
+}
@@ -0,0 +1,78 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidRecipient = errors.New("invalid recipient")
+	ErrNotRecipient     = errors.New("not an allowed recipient")
+)
+
+// maxRecipients bounds the allowlist of a single session.
+const maxRecipients = 50
+
+// NormalizeRecipients validates a recipient allowlist and lowercases it.
+// Entries are email addresses ("alice@corp.com"), email domains
+// ("@corp.com") or token subjects, which are kept as given.
+func NormalizeRecipients(recipients []string) ([]string, error) {
+	if len(recipients) > maxRecipients {
+		return nil, fmt.Errorf("%w: at most %d recipients", ErrInvalidRecipient, maxRecipients)
+	}
+
+	normalized := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		r = strings.TrimSpace(r)
+		at := strings.LastIndex(r, "@")
+		switch {
+		case r == "":
+			return nil, fmt.Errorf("%w: empty entry", ErrInvalidRecipient)
+		case at == len(r)-1 || strings.Count(r, "@") > 1:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRecipient, r)
+		case at >= 0:
+			// Emails and domains compare case-insensitively.
+			r = strings.ToLower(r)
+		}
+		normalized = append(normalized, r)
+	}
+	return normalized, nil
+}
+
+// Restricted reports whether only named recipients may receive.
+func (s *Session) Restricted() bool {
+	return len(s.Recipients) > 0
+}
+
+// OwnedBy reports whether subject is the token subject of whoever created
+// s. Anonymous sessions are owned by no one.
+func (s *Session) OwnedBy(subject string) bool {
+	return subject != "" && s.Owner != nil && s.Owner.Subject == subject
+}
+
+// AllowsRecipient reports whether a receiver with the given token subject
+// and email may join. The owner is always allowed so the sender can check
+// on its own session.
+func (s *Session) AllowsRecipient(subject, email string) bool {
+	if !s.Restricted() {
+		return true
+	}
+	if s.OwnedBy(subject) {
+		return true
+	}
+
+	email = strings.ToLower(email)
+	_, domain, _ := strings.Cut(email, "@")
+	for _, r := range s.Recipients {
+		switch {
+		case subject != "" && r == subject:
+			return true
+		case email == "":
+			continue
+		case r == email, domain != "" && r == "@"+domain:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidVanityCode   = errors.New("invalid vanity code")
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrNotOwner            = errors.New("not the owner of this code")
+	ErrCodeInUse           = errors.New("code is bound to an active transfer")
+)
+
+const (
+	minVanityLength = 4
+	maxVanityLength = 24
+)
+
+// Reservation is a vanity code claimed by an owner. The owner token itself
+// is never stored, only its SHA-256 hash.
+type Reservation struct {
+	Code      string    `json:"code"`
+	TokenHash string    `json:"tokenHash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Reservations is a registry of vanity codes. Each reserved code outlives
+// the sessions bound to it: the owner re-binds it to a fresh session on every
+// upload. When a path is set the registry is persisted as JSON so
+// reservations also survive restarts.
+type Reservations struct {
+	byCode map[string]*Reservation
+	path   string
+	mu     sync.RWMutex
+}
+
+// NewReservations creates a registry, loading existing reservations from path
+// if it is non-empty and the file exists.
+func NewReservations(path string) (*Reservations, error) {
+	r := &Reservations{
+		byCode: make(map[string]*Reservation),
+		path:   path,
+	}
+
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Reservation
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse reservations: %w", err)
+	}
+	for _, res := range list {
+		r.byCode[res.Code] = res
+	}
+
+	return r, nil
+}
+
+// Reserve claims code and returns the owner token needed to bind sessions to
+// it or release it. The token is only returned once.
+func (r *Reservations) Reserve(code string) (string, error) {
+	code = NormalizeVanityCode(code)
+	if err := ValidateVanityCode(code); err != nil {
+		return "", err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.byCode[code]; exists {
+		return "", ErrCodeTaken
+	}
+
+	r.byCode[code] = &Reservation{
+		Code:      code,
+		TokenHash: hashToken(token),
+		CreatedAt: time.Now(),
+	}
+
+	if err := r.save(); err != nil {
+		delete(r.byCode, code)
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Authorize checks that token owns code.
+func (r *Reservations) Authorize(code, token string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res, exists := r.byCode[NormalizeVanityCode(code)]
+	if !exists {
+		return ErrReservationNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(res.TokenHash), []byte(hashToken(token))) != 1 {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// Release removes a reservation owned by token.
+func (r *Reservations) Release(code, token string) error {
+	if err := r.Authorize(code, token); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code = NormalizeVanityCode(code)
+	res := r.byCode[code]
+	delete(r.byCode, code)
+
+	if err := r.save(); err != nil {
+		r.byCode[code] = res
+		return err
+	}
+	return nil
+}
+
+// IsReserved reports whether code has been reserved.
+func (r *Reservations) IsReserved(code string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, exists := r.byCode[code]
+	return exists
+}
+
+// save writes the registry to disk atomically. Callers must hold r.mu.
+func (r *Reservations) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	list := make([]*Reservation, 0, len(r.byCode))
+	for _, res := range r.byCode {
+		list = append(list, res)
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".reservations-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// NormalizeVanityCode uppercases code and turns spaces and underscores into
+// dashes.
+func NormalizeVanityCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "-", "_", "-").Replace(code)
+}
+
+// ValidateVanityCode checks a normalized vanity code. Vanity codes are groups
+// of A-Z and 0-9 separated by single dashes and must start with a letter, so
+// they never collide with word codes. Codes that normalize onto one the
+// default generator could produce, such as ABCDEF or AB-CDEF for ABC-DEF,
+// are left for random codes: lookups try that normalization first and would
+// find the random session instead.
+//
+// Unlike random codes, vanity codes may use 0, 1, I, L and O, which the
+// random alphabet leaves out. Random codes avoid them because nobody can
+// tell which one a stranger meant; a vanity code is a name its owner picked,
+// and ruling them out would reject most names, HELLO and TEAM-FILES among
+// them. A code containing one of them never has the shape of a random code.
+func ValidateVanityCode(code string) error {
+	if len(code) < minVanityLength || len(code) > maxVanityLength {
+		return fmt.Errorf("%w: must be %d to %d characters", ErrInvalidVanityCode, minVanityLength, maxVanityLength)
+	}
+	if code[0] < 'A' || code[0] > 'Z' {
+		return fmt.Errorf("%w: must start with a letter", ErrInvalidVanityCode)
+	}
+
+	for _, group := range strings.Split(code, "-") {
+		if group == "" {
+			return fmt.Errorf("%w: empty group", ErrInvalidVanityCode)
+		}
+		for _, c := range group {
+			if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+				return fmt.Errorf("%w: invalid character %q", ErrInvalidVanityCode, c)
+			}
+		}
+	}
+
+	if isRandomCode(AlphabetCodes{}.Normalize(code)) || isWordCode(code) {
+		return fmt.Errorf("%w: conflicts with random code format", ErrInvalidVanityCode)
+	}
+
+	return nil
+}
+
+// isRandomCode reports whether code has the shape of a default random code.
+func isRandomCode(code string) bool {
+	if len(code) != 7 || code[3] != '-' {
+		return false
+	}
+	for i, c := range code {
+		if i != 3 && !strings.ContainsRune(alphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isWordCode reports whether code normalizes onto a code the word generator
+// could produce with any word count.
+func isWordCode(code string) bool {
+	fields := strings.Split((&WordCodes{}).Normalize(code), "-")
+	if len(fields) < minCodeWords+1 || len(fields) > maxCodeWords+1 {
+		return false
+	}
+	if n, err := strconv.Atoi(fields[0]); err != nil || n < 0 || n >= codeNumbers {
+		return false
+	}
+	for _, f := range fields[1:] {
+		if !slices.Contains(wordlist[:], f) {
+			return false
+		}
+	}
+	return true
+}
+
+func generateToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,130 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateVanityCode(t *testing.T) {
+	tests := []struct {
+		code  string
+		valid bool
+	}{
+		{"TEAM-FILES", true},
+		{"HELLO", true},
+		{"Q3-REPORT", true},
+		{"ABCD", true},
+		{"ABC", false},            // too short
+		{"7-PURPLE-RIVER", false}, // starts with a digit
+		{"TEAM--FILES", false},
+		{"TEAM-", false},
+		{"TEAM.FILES", false},
+		{"ABC-DEF", false}, // a random code
+		{"ABCDEF", false},  // normalizes to ABC-DEF
+		{"AB-CDEF", false},
+		{"A-B-C-D-E-F", false},
+		{"ABCDEFG", true},
+		// Characters left out of the random alphabet are allowed and
+		// keep the code clear of random ones.
+		{"ABCDEO", true},
+		{"ABCDE0", true},
+		{"ABC-DE1", true},
+		{"ABI-LOX", true},
+		{"FILE-10", true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateVanityCode(tt.code)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.code, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidVanityCode) {
+			t.Errorf("%s: error = %v, want %v", tt.code, err, ErrInvalidVanityCode)
+		}
+	}
+}
+
+// fixedCodes generates the same code every time.
+type fixedCodes struct{ AlphabetCodes }
+
+func (fixedCodes) Generate() (string, error) {
+	return "ABC-DEF", nil
+}
+
+// TestVanityCodeShadowing checks that a receiver typing a vanity code can
+// never be sent to an unrelated random session.
+func TestVanityCodeShadowing(t *testing.T) {
+	reservations, err := NewReservations("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(Options{TTL: time.Minute, Codes: fixedCodes{}, Reservations: reservations})
+
+	random, err := m.Create(CreateParams{FileName: "random.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reservations.Reserve("abcdef"); !errors.Is(err, ErrInvalidVanityCode) {
+		t.Fatalf("Reserve(abcdef) error = %v, want %v", err, ErrInvalidVanityCode)
+	}
+
+	token, err := reservations.Reserve("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vanity, err := m.Create(CreateParams{FileName: "vanity.txt", VanityCode: "hello", OwnerToken: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for code, want := range map[string]*Session{"abc def": random, "Hello": vanity} {
+		if got, err := m.GetByCode(code); err != nil || got != want {
+			t.Errorf("GetByCode(%q) = %v, %v, want %s", code, got, err, want.FileName)
+		}
+	}
+}
+
+func TestManagerRebindVanityCode(t *testing.T) {
+	reservations, err := NewReservations("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(Options{TTL: time.Minute, Reservations: reservations})
+	var replaced []*Session
+	m.OnExpire(func(s *Session) {
+		replaced = append(replaced, s)
+	})
+
+	token, err := reservations.Reserve("team-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func() (*Session, error) {
+		return m.Create(CreateParams{FileName: "a.txt", VanityCode: "TEAM-FILES", OwnerToken: token})
+	}
+
+	first, err := create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replaced) != 1 || replaced[0] != first {
+		t.Errorf("replaced = %v, want the first session", replaced)
+	}
+	if _, err := m.GetByID(first.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("GetByID(first) error = %v, want %v", err, ErrSessionNotFound)
+	}
+
+	// A session that is still transferring keeps the code.
+	second.RecordChunk(1, 1)
+	if _, err := create(); !errors.Is(err, ErrCodeInUse) {
+		t.Errorf("rebind while transferring: error = %v, want %v", err, ErrCodeInUse)
+	}
+	if len(replaced) != 1 {
+		t.Errorf("%d sessions replaced, want 1", len(replaced))
+	}
+}
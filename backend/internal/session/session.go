@@ -17,6 +17,22 @@ const (
 	StatusFailed       Status = "failed"
 )
 
+// Transport is how file data flows between the peers.
+type Transport string
+
+const (
+	TransportRelay  Transport = "relay"
+	TransportDirect Transport = "direct"
+)
+
+// Owner identifies who created a session, either a user from a verified
+// token or an API key.
+type Owner struct {
+	Subject string `json:"subject"`
+	Email   string `json:"email,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
 type Session struct {
 	ID        string    `json:"id"`
 	Code      string    `json:"code"`
@@ -26,7 +42,24 @@ type Session struct {
 	Status    Status    `json:"status"`
 	CreatedAt time.Time `json:"createdAt"`
 	ExpiresAt time.Time `json:"expiresAt"`
-	mu        sync.RWMutex
+	Transport Transport `json:"transport"`
+	// Compression is the chunk compression algorithm negotiated for the
+	// transfer, empty until the sender has sent file metadata.
+	Compression string `json:"compression,omitempty"`
+	// SpeedLimit is the owner's cap on relay bandwidth in bytes per
+	// second, zero if uncapped.
+	SpeedLimit int64 `json:"speedLimit,omitempty"`
+	// Owner is nil for sessions created anonymously.
+	Owner *Owner `json:"owner,omitempty"`
+	// Recipients limits who may receive, see AllowsRecipient. Empty means
+	// anyone with the code.
+	Recipients []string `json:"recipients,omitempty"`
+	ownerIP    string
+	clock      Clock
+	messageIDs map[string]uint64 // last reliable message ID per role
+	stats      transferStats
+	summary    *Summary
+	mu         sync.RWMutex
 }
 
 func (s *Session) SetStatus(status Status) {
@@ -41,24 +74,67 @@ func (s *Session) GetStatus() Status {
 	return s.Status
 }
 
+func (s *Session) SetTransport(transport Transport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Transport = transport
+}
+
+func (s *Session) GetTransport() Transport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Transport
+}
+
+func (s *Session) SetCompression(compression string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Compression = compression
+}
+
+func (s *Session) GetCompression() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Compression
+}
+
+// NextMessageID returns the next ID for reliable messages sent to role.
+// The counter lives on the session rather than with the relay's connection
+// state so that IDs keep increasing after both peers have left and the
+// relay has forgotten their connections.
+func (s *Session) NextMessageID(role string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.messageIDs == nil {
+		s.messageIDs = make(map[string]uint64)
+	}
+	s.messageIDs[role]++
+	return s.messageIDs[role]
+}
+
+// IsExpired reports whether the session has outlived its TTL by the clock
+// of the manager that created it.
 func (s *Session) IsExpired() bool {
-	return time.Now().After(s.ExpiresAt)
+	return s.now().After(s.ExpiresAt)
+}
+
+// now returns the time by the session's clock, falling back to the system
+// clock for sessions not created by a Manager.
+func (s *Session) now() time.Time {
+	if s.clock == nil {
+		return SystemClock{}.Now()
+	}
+	return s.clock.Now()
 }
 
 // Code generation - excludes confusable characters (0, O, I, L, 1)
 const alphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
 
+// GenerateCode returns a random code in the default XXX-XXX format.
 func GenerateCode() string {
-	bytes := make([]byte, 6)
-	rand.Read(bytes)
-
-	code := make([]byte, 6)
-	for i := 0; i < 6; i++ {
-		code[i] = alphabet[int(bytes[i])%len(alphabet)]
-	}
-
-	// Format as XXX-XXX
-	return string(code[:3]) + "-" + string(code[3:])
+	code, _ := AlphabetCodes{}.Generate()
+	return code
 }
 
 func GenerateID() string {
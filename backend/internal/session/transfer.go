@@ -0,0 +1,147 @@
+package session
+
+import "time"
+
+// Progress is a point-in-time snapshot of a transfer as observed by the relay.
+type Progress struct {
+	BytesTransferred int64 // file data received, before any compression
+	BytesRelayed     int64 // chunk data as relayed, after any compression
+	ChunksRelayed    int
+	ChunksAcked      int
+	StartedAt        time.Time
+	UpdatedAt        time.Time
+	Throughput       float64       // file bytes per second
+	ETA              time.Duration // zero when unknown or complete
+}
+
+// Summary records the outcome of a finished transfer.
+type Summary struct {
+	TotalBytes   int64 // file data, before any compression
+	BytesRelayed int64 // chunk data as relayed, after any compression
+	TotalChunks  int
+	ChunksAcked  int
+	StartedAt    time.Time
+	CompletedAt  time.Time
+	Duration     time.Duration
+	Throughput   float64 // file bytes per second
+}
+
+type transferStats struct {
+	bytesTransferred int64
+	bytesRelayed     int64
+	chunksRelayed    int
+	chunksAcked      int
+	startedAt        time.Time
+	updatedAt        time.Time
+}
+
+// RecordChunk accounts for a chunk relayed to the receiver: size bytes of
+// the file, sent as relayed bytes once compressed.
+func (s *Session) RecordChunk(size, relayed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.stats.startedAt.IsZero() {
+		s.stats.startedAt = now
+	}
+	s.stats.bytesTransferred += size
+	s.stats.bytesRelayed += relayed
+	s.stats.chunksRelayed++
+	s.stats.updatedAt = now
+
+	if s.Status != StatusCompleted && s.Status != StatusFailed {
+		s.Status = StatusTransferring
+	}
+}
+
+// RecordAck accounts for a chunk acknowledged by the receiver.
+func (s *Session) RecordAck() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.chunksAcked++
+	s.stats.updatedAt = s.now()
+}
+
+// Progress returns the current transfer progress. Throughput is averaged over
+// the transfer so far and the ETA is derived from the remaining file size.
+func (s *Session) Progress() Progress {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p := Progress{
+		BytesTransferred: s.stats.bytesTransferred,
+		BytesRelayed:     s.stats.bytesRelayed,
+		ChunksRelayed:    s.stats.chunksRelayed,
+		ChunksAcked:      s.stats.chunksAcked,
+		StartedAt:        s.stats.startedAt,
+		UpdatedAt:        s.stats.updatedAt,
+	}
+
+	if p.StartedAt.IsZero() {
+		return p
+	}
+
+	end := s.now()
+	if s.summary != nil {
+		end = s.summary.CompletedAt
+	}
+	if elapsed := end.Sub(p.StartedAt).Seconds(); elapsed > 0 {
+		p.Throughput = float64(p.BytesTransferred) / elapsed
+	}
+
+	if remaining := s.FileSize - p.BytesTransferred; remaining > 0 && p.Throughput > 0 && s.summary == nil {
+		p.ETA = time.Duration(float64(remaining) / p.Throughput * float64(time.Second))
+	}
+
+	return p
+}
+
+// Complete marks the transfer as completed and stores its final summary.
+// Subsequent calls return the summary recorded by the first call.
+func (s *Session) Complete() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.summary != nil {
+		return *s.summary
+	}
+
+	now := s.now()
+	started := s.stats.startedAt
+	if started.IsZero() {
+		started = now
+	}
+
+	summary := &Summary{
+		TotalBytes:   s.stats.bytesTransferred,
+		BytesRelayed: s.stats.bytesRelayed,
+		TotalChunks:  s.stats.chunksRelayed,
+		ChunksAcked:  s.stats.chunksAcked,
+		StartedAt:    started,
+		CompletedAt:  now,
+		Duration:     now.Sub(started),
+	}
+	if secs := summary.Duration.Seconds(); secs > 0 {
+		summary.Throughput = float64(summary.TotalBytes) / secs
+	}
+
+	s.summary = summary
+	s.Status = StatusCompleted
+
+	return *summary
+}
+
+// Summary returns the final transfer summary, or nil if the transfer has not
+// completed yet.
+func (s *Session) Summary() *Summary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.summary == nil {
+		return nil
+	}
+	summary := *s.summary
+	return &summary
+}
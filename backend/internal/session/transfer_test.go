@@ -0,0 +1,57 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionTransfer(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(Options{Clock: clock, TTL: time.Hour})
+	sess, err := m.Create(CreateParams{FileName: "a.bin", FileSize: 4000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := sess.Progress(); !p.StartedAt.IsZero() || p.Throughput != 0 {
+		t.Errorf("progress before the first chunk = %+v", p)
+	}
+
+	started := clock.Now()
+	// Chunks compressed to a quarter of their size.
+	sess.RecordChunk(1000, 250)
+	clock.Advance(time.Second)
+	sess.RecordChunk(1000, 250)
+	sess.RecordAck()
+	clock.Advance(time.Second)
+
+	p := sess.Progress()
+	if !p.StartedAt.Equal(started) || !p.UpdatedAt.Equal(started.Add(time.Second)) {
+		t.Errorf("progress times = %s, %s, want %s, %s", p.StartedAt, p.UpdatedAt, started, started.Add(time.Second))
+	}
+	if p.BytesTransferred != 2000 || p.BytesRelayed != 500 || p.ChunksRelayed != 2 || p.ChunksAcked != 1 {
+		t.Errorf("progress counts = %+v", p)
+	}
+	if p.Throughput != 1000 || p.ETA != 2*time.Second {
+		t.Errorf("throughput = %v, ETA = %s, want 1000, 2s", p.Throughput, p.ETA)
+	}
+	if sess.GetStatus() != StatusTransferring {
+		t.Errorf("status = %s, want %s", sess.GetStatus(), StatusTransferring)
+	}
+
+	clock.Advance(2 * time.Second)
+	summary := sess.Complete()
+	if summary.Duration != 4*time.Second || summary.Throughput != 500 || summary.TotalBytes != 2000 || summary.BytesRelayed != 500 {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	// Time passing after completion changes neither the summary nor the
+	// reported throughput.
+	clock.Advance(time.Minute)
+	if again := sess.Complete(); again != summary {
+		t.Errorf("second Complete = %+v, want %+v", again, summary)
+	}
+	if p := sess.Progress(); p.Throughput != 500 || p.ETA != 0 {
+		t.Errorf("progress after completion = %+v", p)
+	}
+}
@@ -0,0 +1,242 @@
+// Package turn runs an embedded TURN relay so peers behind restrictive NATs
+// can still establish a WebRTC connection without a separate coturn
+// deployment.
+package turn
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	pionturn "github.com/pion/turn/v4"
+)
+
+var ErrInvalidConfig = errors.New("invalid TURN configuration")
+
+type Config struct {
+	// ListenAddr is the UDP and TCP address to listen on, e.g. ":3478".
+	ListenAddr string
+	// PublicIP is the address advertised to peers for relayed traffic.
+	PublicIP string
+	// Realm is the authentication realm.
+	Realm string
+	// Secret signs short-lived credentials. A random secret is generated
+	// when empty, which invalidates credentials across restarts.
+	Secret string
+	// CredentialTTL is how long minted credentials stay valid.
+	CredentialTTL time.Duration
+	// MinPort and MaxPort restrict relay allocations to a port range. Zero
+	// lets the OS pick.
+	MinPort uint16
+	MaxPort uint16
+	// AllowedPeers lists CIDRs peers may be relayed to even though they
+	// are loopback, private or link-local addresses, which are otherwise
+	// refused so the relay cannot reach into the host's network.
+	AllowedPeers []string
+}
+
+// Credentials are short-lived TURN credentials in the TURN REST API format.
+type Credentials struct {
+	URLs      []string
+	Username  string
+	Password  string
+	ExpiresAt time.Time
+}
+
+// Server is an embedded TURN relay. Credentials are bound to a session ID
+// and are only accepted while the session is still valid.
+type Server struct {
+	server *pionturn.Server
+	cfg    Config
+	urls   []string
+}
+
+// Start listens on cfg.ListenAddr for UDP and TCP. valid reports whether
+// the session ID embedded in a credential still refers to a live session.
+func Start(cfg Config, valid func(sessionID string) bool) (*Server, error) {
+	relayIP := net.ParseIP(cfg.PublicIP)
+	if relayIP == nil {
+		return nil, fmt.Errorf("%w: public IP %q", ErrInvalidConfig, cfg.PublicIP)
+	}
+	if cfg.MinPort > cfg.MaxPort {
+		return nil, fmt.Errorf("%w: min port above max port", ErrInvalidConfig)
+	}
+	allowed, err := parseCIDRs(cfg.AllowedPeers)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Secret == "" {
+		secret, err := randomSecret()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Secret = secret
+	}
+
+	udpConn, err := net.ListenPacket("udp4", cfg.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp: %w", err)
+	}
+	// Listen for TCP on the port the UDP socket got, which matters when
+	// ListenAddr asks for any free port.
+	port := udpConn.LocalAddr().(*net.UDPAddr).Port
+	listenHost, _, err := net.SplitHostPort(cfg.ListenAddr)
+	if err != nil {
+		udpConn.Close()
+		return nil, fmt.Errorf("%w: listen address %q", ErrInvalidConfig, cfg.ListenAddr)
+	}
+	tcpListener, err := net.Listen("tcp4", net.JoinHostPort(listenHost, strconv.Itoa(port)))
+	if err != nil {
+		udpConn.Close()
+		return nil, fmt.Errorf("listen tcp: %w", err)
+	}
+
+	auth := pionturn.LongTermTURNRESTAuthHandler(cfg.Secret, nil)
+	server, err := pionturn.NewServer(pionturn.ServerConfig{
+		Realm: cfg.Realm,
+		AuthHandler: func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+			_, sessionID, ok := strings.Cut(username, ":")
+			if !ok || !valid(sessionID) {
+				return nil, false
+			}
+			return auth(username, realm, srcAddr)
+		},
+		PacketConnConfigs: []pionturn.PacketConnConfig{{
+			PacketConn:            udpConn,
+			RelayAddressGenerator: relayAddressGenerator(relayIP, cfg),
+			PermissionHandler:     permissionHandler(allowed),
+		}},
+		ListenerConfigs: []pionturn.ListenerConfig{{
+			Listener:              tcpListener,
+			RelayAddressGenerator: relayAddressGenerator(relayIP, cfg),
+			PermissionHandler:     permissionHandler(allowed),
+		}},
+	})
+	if err != nil {
+		udpConn.Close()
+		tcpListener.Close()
+		return nil, err
+	}
+
+	host := net.JoinHostPort(cfg.PublicIP, strconv.Itoa(port))
+	log.Printf("TURN relay listening on %s (public %s)", cfg.ListenAddr, host)
+
+	return &Server{
+		server: server,
+		cfg:    cfg,
+		urls: []string{
+			"turn:" + host + "?transport=udp",
+			"turn:" + host + "?transport=tcp",
+		},
+	}, nil
+}
+
+// Credentials mints credentials for sessionID that expire after the
+// configured TTL.
+func (s *Server) Credentials(sessionID string) (Credentials, error) {
+	username, password, err := pionturn.GenerateLongTermTURNRESTCredentials(s.cfg.Secret, sessionID, s.cfg.CredentialTTL)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	return Credentials{
+		URLs:      s.urls,
+		Username:  username,
+		Password:  password,
+		ExpiresAt: time.Now().Add(s.cfg.CredentialTTL),
+	}, nil
+}
+
+// AllocationCount returns the number of active relay allocations.
+func (s *Server) AllocationCount() int {
+	return s.server.AllocationCount()
+}
+
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+func relayAddressGenerator(relayIP net.IP, cfg Config) pionturn.RelayAddressGenerator {
+	if cfg.MinPort == 0 && cfg.MaxPort == 0 {
+		return &pionturn.RelayAddressGeneratorStatic{
+			RelayAddress: relayIP,
+			Address:      "0.0.0.0",
+		}
+	}
+	return &pionturn.RelayAddressGeneratorPortRange{
+		RelayAddress: relayIP,
+		Address:      "0.0.0.0",
+		MinPort:      cfg.MinPort,
+		MaxPort:      cfg.MaxPort,
+	}
+}
+
+// permissionHandler refuses permissions and channel bindings to peers the
+// relay must not reach: anyone who creates a session gets credentials, so
+// without this the relay would let them probe the host's network or cloud
+// metadata services. Peers in allowed are always accepted.
+func permissionHandler(allowed []*net.IPNet) pionturn.PermissionHandler {
+	return func(clientAddr net.Addr, peerIP net.IP) bool {
+		for _, n := range allowed {
+			if n.Contains(peerIP) {
+				return true
+			}
+		}
+		if !isPublic(peerIP) {
+			log.Printf("TURN permission refused: client=%s peer=%s", clientAddr, peerIP)
+			return false
+		}
+		return true
+	}
+}
+
+// nonPublic holds reserved ranges the net.IP predicates do not cover.
+var nonPublic = mustParseCIDRs("0.0.0.0/8", "100.64.0.0/10")
+
+// isPublic reports whether ip is a globally routable unicast address.
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return false
+	}
+	for _, n := range nonPublic {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("%w: allowed peer %q", ErrInvalidConfig, c)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+func randomSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,184 @@
+package turn
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	pionturn "github.com/pion/turn/v4"
+)
+
+// startTest runs a relay on loopback that accepts credentials for the
+// session "live" only.
+func startTest(t *testing.T, allowed ...string) *Server {
+	t.Helper()
+
+	s, err := Start(Config{
+		ListenAddr:    "127.0.0.1:0",
+		PublicIP:      "127.0.0.1",
+		Realm:         "takedat",
+		CredentialTTL: time.Minute,
+		AllowedPeers:  allowed,
+	}, func(sessionID string) bool {
+		return sessionID == "live"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// allocate logs in to s with credentials for sessionID and requests a
+// relay allocation.
+func allocate(t *testing.T, s *Server, sessionID string) (net.PacketConn, error) {
+	t.Helper()
+
+	creds, err := s.Credentials(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _, _ := strings.Cut(strings.TrimPrefix(creds.URLs[0], "turn:"), "?")
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := pionturn.NewClient(&pionturn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Username:       creds.Username,
+		Password:       creds.Password,
+		Realm:          "takedat",
+		Conn:           conn,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		conn.Close()
+	})
+	if err := client.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	return client.Allocate()
+}
+
+func TestAllocation(t *testing.T) {
+	s := startTest(t, "127.0.0.0/8")
+
+	relay, err := allocate(t, s, "live")
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	defer relay.Close()
+	if n := s.AllocationCount(); n != 1 {
+		t.Errorf("AllocationCount = %d, want 1", n)
+	}
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// Relay a datagram to the peer and its reply back.
+	if _, err := relay.WriteTo([]byte("ping"), peer.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo peer: %v", err)
+	}
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, from, err := peer.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("peer read = %q, %v, want ping", buf[:n], err)
+	}
+	if _, err := peer.WriteTo([]byte("pong"), from); err != nil {
+		t.Fatal(err)
+	}
+	relay.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err = relay.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "pong" {
+		t.Fatalf("relay read = %q, %v, want pong", buf[:n], err)
+	}
+}
+
+func TestAllocationExpiredSession(t *testing.T) {
+	s := startTest(t)
+
+	if relay, err := allocate(t, s, "gone"); err == nil {
+		relay.Close()
+		t.Fatal("Allocate succeeded for a session that is not live")
+	}
+	if n := s.AllocationCount(); n != 0 {
+		t.Errorf("AllocationCount = %d, want 0", n)
+	}
+}
+
+func TestPermissionRefused(t *testing.T) {
+	s := startTest(t)
+
+	relay, err := allocate(t, s, "live")
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	defer relay.Close()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	if _, err := relay.WriteTo([]byte("ping"), peer.LocalAddr()); err == nil {
+		t.Error("relaying to a loopback peer succeeded")
+	}
+}
+
+func TestPermissionHandler(t *testing.T) {
+	handler := permissionHandler(mustParseCIDRs("10.1.0.0/16"))
+	client := &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 5000}
+
+	tests := []struct {
+		peer string
+		ok   bool
+	}{
+		{"198.51.100.7", true},
+		{"2001:db8::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"10.1.2.3", true}, // allowed
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if ok := handler(client, net.ParseIP(tt.peer)); ok != tt.ok {
+			t.Errorf("%s: permitted = %v, want %v", tt.peer, ok, tt.ok)
+		}
+	}
+}
+
+func TestStartInvalidAllowedPeers(t *testing.T) {
+	_, err := Start(Config{
+		ListenAddr:   "127.0.0.1:0",
+		PublicIP:     "127.0.0.1",
+		AllowedPeers: []string{"10.0.0.0"},
+	}, func(string) bool { return true })
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
@@ -0,0 +1,25 @@
+// Package web embeds the frontend build into the server binary. The output
+// of the frontend's npm run build is copied into dist before compiling;
+// binaries built without it serve only the API.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:dist
+var dist embed.FS
+
+// FS returns the embedded frontend, or nil if the binary was built without
+// one.
+func FS() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		return nil
+	}
+	if _, err := fs.Stat(sub, "index.html"); err != nil {
+		return nil
+	}
+	return sub
+}
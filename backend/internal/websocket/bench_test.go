@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"takedat/internal/session"
+)
+
+var benchSizes = []int{1 << 10, 64 << 10, 1 << 20}
+
+// chunkMessage builds a chunk message carrying size bytes of random data.
+func chunkMessage(b *testing.B, size int) *Message {
+	b.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+	msg, err := NewMessage(TypeChunk, ChunkPayload{
+		Index: 42,
+		Data:  base64.StdEncoding.EncodeToString(data),
+		Size:  size,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return msg
+}
+
+// BenchmarkRelayToPeer measures relaying a chunk from a sender to a
+// receiver whose queue is drained as fast as possible. Messages dropped
+// because the queue was full are reported as drops/op.
+func BenchmarkRelayToPeer(b *testing.B) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(out) })
+
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			sessions := session.NewManager(session.Options{TTL: time.Minute})
+			hub := NewHub(sessions, Options{})
+
+			sender := NewClient(hub, nil, "BENCH")
+			sender.role = "sender"
+			receiver := NewClient(hub, nil, "BENCH")
+			receiver.role = "receiver"
+			sc := &SessionClients{}
+			sc.pair(ControlStream).set(sender.role, sender)
+			sc.pair(ControlStream).set(receiver.role, receiver)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for range receiver.send {
+				}
+			}()
+
+			msg := chunkMessage(b, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			drops := 0
+			for i := 0; i < b.N; i++ {
+				sc.mu.RLock()
+				if !hub.relayToPeer(sender, sc, msg) {
+					drops++
+				}
+				sc.mu.RUnlock()
+			}
+
+			b.StopTimer()
+			receiver.closeSend()
+			<-done
+			b.ReportMetric(float64(drops)/float64(b.N), "drops/op")
+		})
+	}
+}
+
+func BenchmarkMessageEncode(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			data := make([]byte, size)
+			rand.Read(data)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				msg, err := NewMessage(TypeChunk, ChunkPayload{
+					Index: i,
+					Data:  base64.StdEncoding.EncodeToString(data),
+					Size:  size,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := msg.Bytes(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMessageDecode(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			raw, err := chunkMessage(b, size).Bytes()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				var msg Message
+				if err := json.Unmarshal(raw, &msg); err != nil {
+					b.Fatal(err)
+				}
+				var payload ChunkPayload
+				if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := base64.StdEncoding.DecodeString(payload.Data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,18 +1,27 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"sync"
+	"sync/atomic"
+	"takedat/internal/auth"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// Defaults for the connection tunables in Options.
 const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512 * 1024 // 512KB max message size
+	defaultWriteWait      = 10 * time.Second
+	defaultPongWait       = 60 * time.Second
+	defaultRegisterWait   = 10 * time.Second
+	defaultMaxMessageSize = 512 * 1024 // 512KB max message size
+	defaultBufferSize     = 1024
+	defaultSendQueue      = 256
+	defaultReplayBuffer   = 64
+	defaultReplayGrace    = 30 * time.Second
 )
 
 type Client struct {
@@ -22,27 +31,72 @@ type Client struct {
 	code    string
 	role    string // "sender" or "receiver"
 	session string
+	stream  int // ControlStream or a data stream number
+	ip      string
+	// principal is who the upgrade request authenticated as.
+	principal auth.Principal
+	// ctx is cancelled once either pump exits, releasing a sender that is
+	// waiting on a bandwidth limit.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Set during registration, before the client is handed to the hub.
+	version      int
+	capabilities []string
+	registered   bool
+	resumeFrom   uint64 // last message ID seen by a previous connection
+	// rejected is set when the hub turned the client away after it
+	// registered.
+	rejected atomic.Bool
+	// sendClosed is set once send is closed; sendMu guards both so
+	// messages racing with a disconnect are dropped rather than sent on a
+	// closed channel.
+	sendClosed bool
+	sendMu     sync.Mutex
+	// drained is signalled by WritePump whenever it takes a message off
+	// send, waking a chunk waiting for room.
+	drained chan struct{}
+	// skipCompression disables permessage-deflate for outgoing messages
+	// once the transfer's chunks are known to be incompressible.
+	skipCompression atomic.Bool
 }
 
 func NewClient(hub *Hub, conn *websocket.Conn, code string) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		hub:  hub,
-		conn: conn,
-		send: make(chan []byte, 256),
-		code: code,
+		hub:     hub,
+		conn:    conn,
+		send:    make(chan []byte, hub.opts.SendQueue),
+		drained: make(chan struct{}, 1),
+		code:    code,
+		ctx:     ctx,
+		cancel:  cancel,
 	}
 }
 
 func (c *Client) ReadPump() {
 	defer func() {
+		c.cancel()
+		if !c.registered {
+			// The hub never saw this client. Closing send lets WritePump
+			// flush any pending error before closing the connection.
+			c.closeSend()
+			return
+		}
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetReadLimit(c.hub.opts.MaxMessageSize)
+	if c.registered {
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.opts.PongWait))
+	} else {
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.opts.RegisterWait))
+	}
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		if !c.registered {
+			return nil
+		}
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.opts.PongWait))
 		return nil
 	})
 
@@ -54,10 +108,26 @@ func (c *Client) ReadPump() {
 			}
 			break
 		}
+		if c.rejected.Load() {
+			// Drain until WritePump has flushed the error and closed
+			// the connection.
+			continue
+		}
 
 		var msg Message
 		if err := json.Unmarshal(messageBytes, &msg); err != nil {
-			c.sendError("INVALID_MESSAGE", "Failed to parse message", false)
+			c.sendError("INVALID_MESSAGE", "Failed to parse message", !c.registered)
+			if !c.registered {
+				break
+			}
+			continue
+		}
+
+		if !c.registered {
+			if !c.handleRegister(&msg) {
+				break
+			}
+			c.conn.SetReadDeadline(time.Now().Add(c.hub.opts.PongWait))
 			continue
 		}
 
@@ -66,27 +136,33 @@ func (c *Client) ReadPump() {
 }
 
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.hub.opts.PingPeriod)
 	defer func() {
 		ticker.Stop()
+		c.cancel()
 		c.conn.Close()
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			select {
+			case c.drained <- struct{}{}:
+			default:
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.opts.WriteWait))
 			if !ok {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
+			c.conn.EnableWriteCompression(!c.skipCompression.Load())
 			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.opts.WriteWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -100,11 +176,78 @@ func (c *Client) Send(msg *Message) error {
 		return err
 	}
 
+	c.sendBytes(bytes)
+	return nil
+}
+
+// sendBytes queues an encoded message, dropping it if the buffer is full
+// or the client is disconnecting.
+func (c *Client) sendBytes(bytes []byte) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.sendClosed {
+		return false
+	}
 	select {
 	case c.send <- bytes:
-		return nil
+		return true
+	default:
+		log.Printf("Dropping message, client buffer full: code=%s role=%s", c.code, c.role)
+		return false
+	}
+}
+
+// queueBytes queues an encoded message like sendBytes but, rather than
+// dropping it when the buffer is full, waits for room so that a fast
+// sender is held to its receiver's pace. The wait holds no locks, so a
+// disconnect is never held up by it. A client that drains nothing for
+// WriteWait is stuck and is disconnected.
+func (c *Client) queueBytes(bytes []byte) bool {
+	timer := time.NewTimer(c.hub.opts.WriteWait)
+	defer timer.Stop()
+
+	for {
+		if sent, ok := c.trySend(bytes); sent || !ok {
+			return sent
+		}
+		select {
+		case <-c.drained:
+		case <-c.ctx.Done():
+			return false
+		case <-timer.C:
+			log.Printf("Client not reading, disconnecting: code=%s role=%s", c.code, c.role)
+			c.conn.Close()
+			return false
+		}
+	}
+}
+
+// trySend queues bytes if there is room. ok is false once send is closed.
+func (c *Client) trySend(bytes []byte) (sent, ok bool) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.sendClosed {
+		return false, false
+	}
+	select {
+	case c.send <- bytes:
+		return true, true
 	default:
-		return nil // Drop message if buffer is full
+		return false, true
+	}
+}
+
+// closeSend closes the send channel, after which WritePump flushes what is
+// queued and closes the connection. It may be called more than once.
+func (c *Client) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if !c.sendClosed {
+		c.sendClosed = true
+		close(c.send)
 	}
 }
 
@@ -0,0 +1,147 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+)
+
+// Chunk compression algorithms. Peers compress and decompress chunk data
+// themselves; the hub only negotiates which algorithm they use.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var knownCompressions = map[string]bool{
+	CompressionNone: true,
+	CompressionGzip: true,
+	CompressionZstd: true,
+}
+
+// compressedMimeTypes lists formats that gain nothing from another round of
+// compression.
+var compressedMimeTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+	"application/x-zstd":           true,
+	"application/java-archive":     true,
+	"application/epub+zip":         true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+	"font/woff":  true,
+	"font/woff2": true,
+}
+
+// isCompressedMimeType reports whether content of mimeType is already
+// compressed. Images, audio and video are, except for a few raw or text
+// based formats.
+func isCompressedMimeType(mimeType string) bool {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+
+	switch mimeType {
+	case "image/svg+xml", "image/bmp", "image/x-ms-bmp", "image/tiff", "audio/wav", "audio/x-wav":
+		return false
+	}
+	if strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "audio/") || strings.HasPrefix(mimeType, "video/") {
+		return true
+	}
+	return compressedMimeTypes[mimeType]
+}
+
+// negotiateCompression picks the first algorithm in the sender's offer that
+// the server allows and the receiver accepts, or none if the file is already
+// compressed or nothing matches.
+func (h *Hub) negotiateCompression(offered, accepted []string, mimeType string) string {
+	if isCompressedMimeType(mimeType) {
+		return CompressionNone
+	}
+
+	for _, algo := range offered {
+		if algo == CompressionNone {
+			return CompressionNone
+		}
+		if !knownCompressions[algo] || !slices.Contains(h.compressions, algo) || !slices.Contains(accepted, algo) {
+			continue
+		}
+		return algo
+	}
+
+	return CompressionNone
+}
+
+// handleFileMeta negotiates chunk compression for a transfer and relays the
+// file metadata with the chosen algorithm to the receiver. The sender learns
+// the choice from a file_meta_ack. Unless both peers negotiated the
+// compression capability, or the sender offers no algorithms, they get none.
+func (h *Hub) handleFileMeta(client *Client, sc *SessionClients, msg *Message) {
+	if client.role != "sender" {
+		client.sendError("INVALID_MESSAGE", "Only the sender can send file metadata", false)
+		return
+	}
+
+	var meta FileMetaPayload
+	if err := json.Unmarshal(msg.Payload, &meta); err != nil {
+		client.sendError("INVALID_MESSAGE", "Invalid file metadata", false)
+		return
+	}
+
+	var accepted []string
+	if p := sc.acceptCompression.Load(); p != nil && sharedCapability(client, sc.client("receiver", ControlStream), CapCompression) {
+		accepted = *p
+	}
+	meta.Compression = h.negotiateCompression(meta.CompressionOptions, accepted, meta.MimeType)
+	meta.CompressionOptions = nil
+
+	payload, err := json.Marshal(meta)
+	if err != nil {
+		return
+	}
+	relayed := *msg
+	relayed.Payload = payload
+
+	if !h.relayToPeer(client, sc, &relayed) {
+		return
+	}
+
+	// Chunks that are already compressed would only burn CPU in
+	// permessage-deflate.
+	skip := meta.Compression != CompressionNone || isCompressedMimeType(meta.MimeType)
+	for _, role := range []string{"sender", "receiver"} {
+		sc.each(role, func(c *Client) {
+			c.skipCompression.Store(skip)
+		})
+	}
+
+	if sess, err := h.sessions.GetByID(client.session); err == nil {
+		sess.SetCompression(meta.Compression)
+	}
+
+	ack, _ := NewMessage(TypeFileMetaAck, FileMetaAckPayload{Compression: meta.Compression})
+	h.deliver(sc, client.role, client, ack)
+
+	log.Printf("File meta: code=%s mime=%q compression=%s", client.code, meta.MimeType, meta.Compression)
+}
+
+// handleTransferRequest records the compression algorithms the receiver can
+// decode before relaying the request to the sender.
+func (h *Hub) handleTransferRequest(client *Client, sc *SessionClients, msg *Message) {
+	if client.role == "receiver" && len(msg.Payload) > 0 {
+		var payload TransferRequestPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err == nil {
+			sc.acceptCompression.Store(&payload.AcceptCompression)
+		}
+	}
+
+	h.relayToPeer(client, sc, msg)
+}
@@ -0,0 +1,166 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestIsCompressedMimeType(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		want     bool
+	}{
+		{"text/plain", false},
+		{"application/json", false},
+		{"application/octet-stream", false},
+		{"", false},
+		{"application/zip", true},
+		{"Application/GZIP; charset=binary", true},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", true},
+		{"font/woff2", true},
+		{"image/png", true},
+		{"video/mp4", true},
+		{"audio/mpeg", true},
+		// Raw and text based media still compress.
+		{"image/svg+xml", false},
+		{"image/bmp", false},
+		{"audio/wav", false},
+	}
+	for _, tt := range tests {
+		if got := isCompressedMimeType(tt.mimeType); got != tt.want {
+			t.Errorf("isCompressedMimeType(%q) = %v, want %v", tt.mimeType, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	h := &Hub{compressions: []string{CompressionZstd, CompressionGzip}}
+	both := []string{CompressionGzip, CompressionZstd}
+
+	tests := []struct {
+		name     string
+		offered  []string
+		accepted []string
+		mimeType string
+		want     string
+	}{
+		{"sender's preference", []string{CompressionZstd, CompressionGzip}, both, "text/plain", CompressionZstd},
+		{"sender prefers gzip", []string{CompressionGzip, CompressionZstd}, both, "text/plain", CompressionGzip},
+		{"receiver accepts one", []string{CompressionZstd, CompressionGzip}, []string{CompressionGzip}, "text/plain", CompressionGzip},
+		{"none first", []string{CompressionNone, CompressionGzip}, both, "text/plain", CompressionNone},
+		{"unknown skipped", []string{"brotli", CompressionGzip}, append(both, "brotli"), "text/plain", CompressionGzip},
+		{"no offer", nil, both, "text/plain", CompressionNone},
+		{"receiver accepts nothing", both, nil, "text/plain", CompressionNone},
+		{"no match", []string{CompressionZstd}, []string{CompressionGzip}, "text/plain", CompressionNone},
+		{"already compressed", both, both, "application/zip", CompressionNone},
+	}
+	for _, tt := range tests {
+		if got := h.negotiateCompression(tt.offered, tt.accepted, tt.mimeType); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Algorithms the server does not allow are never picked.
+	h = &Hub{compressions: []string{CompressionGzip}}
+	if got := h.negotiateCompression([]string{CompressionZstd, CompressionGzip}, both, "text/plain"); got != CompressionGzip {
+		t.Errorf("server allows gzip only: got %q", got)
+	}
+}
+
+func TestHubFileMeta(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities []string // of the receiver
+		accept       []string
+		mimeType     string
+		want         string
+	}{
+		{"negotiated", []string{CapCompression}, []string{CompressionGzip}, "text/plain", CompressionGzip},
+		{"already compressed", []string{CapCompression}, []string{CompressionGzip}, "image/jpeg", CompressionNone},
+		{"receiver without capability", nil, []string{CompressionGzip}, "text/plain", CompressionNone},
+		{"receiver accepts nothing", []string{CapCompression}, nil, "text/plain", CompressionNone},
+	}
+	for _, tt := range tests {
+		h := newHubTest(t, Options{Compressions: []string{CompressionZstd, CompressionGzip}})
+		sess := h.createSession(t)
+		sender, _ := h.register(t, sess, RegisterPayload{Role: "sender", Version: ProtocolVersion, Capabilities: []string{CapCompression}})
+		receiver, _ := h.register(t, sess, RegisterPayload{Role: "receiver", Version: ProtocolVersion, Capabilities: tt.capabilities})
+		sender.expect(TypePeerJoined, nil)
+
+		receiver.send(TypeTransferRequest, TransferRequestPayload{AcceptCompression: tt.accept})
+		sender.expect(TypeTransferRequest, nil)
+
+		sender.send(TypeFileMeta, FileMetaPayload{
+			FileName:           "a.bin",
+			FileSize:           10,
+			MimeType:           tt.mimeType,
+			CompressionOptions: []string{CompressionZstd, CompressionGzip},
+		})
+
+		var meta FileMetaPayload
+		receiver.expect(TypeFileMeta, &meta)
+		if meta.Compression != tt.want || meta.FileName != "a.bin" {
+			t.Errorf("%s: receiver got file_meta %+v, want compression %q", tt.name, meta, tt.want)
+		}
+		if meta.CompressionOptions != nil {
+			t.Errorf("%s: sender's offer relayed to the receiver: %v", tt.name, meta.CompressionOptions)
+		}
+
+		var ack FileMetaAckPayload
+		sender.expect(TypeFileMetaAck, &ack)
+		if ack.Compression != tt.want {
+			t.Errorf("%s: file_meta_ack compression = %q, want %q", tt.name, ack.Compression, tt.want)
+		}
+		if got := sess.GetCompression(); got != tt.want {
+			t.Errorf("%s: session compression = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHubFileMetaFromReceiver(t *testing.T) {
+	h := newHubTest(t, Options{})
+	_, _, receiver := h.pair(t)
+
+	receiver.send(TypeFileMeta, FileMetaPayload{FileName: "a.bin"})
+	if payload := receiver.expectError("INVALID_MESSAGE"); payload.Fatal {
+		t.Error("file_meta from the receiver is fatal")
+	}
+}
+
+func TestHubPerMessageDeflate(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		h := newHubTest(t, Options{EnableCompression: enabled})
+		sess := h.createSession(t)
+
+		dialer := websocket.Dialer{EnableCompression: true}
+		url := "ws" + strings.TrimPrefix(h.srv.URL, "http") + "/ws/" + sess.Code + "?role=sender"
+		conn, resp, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		ext := resp.Header.Get("Sec-WebSocket-Extensions")
+		if got := strings.Contains(ext, "permessage-deflate"); got != enabled {
+			t.Errorf("compression enabled %v: Sec-WebSocket-Extensions = %q", enabled, ext)
+		}
+	}
+}
+
+func TestHubCompressedProgress(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess, sender, receiver := h.pair(t)
+
+	// 1000 file bytes compressed to 5.
+	sender.send(TypeChunk, ChunkPayload{Index: 0, Data: "aGVsbG8=", Size: 1000})
+	receiver.expect(TypeChunk, nil)
+	// A pong means the hub has finished with the chunk.
+	sender.send(TypePing, nil)
+	sender.expect(TypePong, nil)
+
+	if p := sess.Progress(); p.BytesTransferred != 1000 || p.BytesRelayed != 5 {
+		t.Errorf("progress = %d file bytes, %d relayed, want 1000, 5", p.BytesTransferred, p.BytesRelayed)
+	}
+}
@@ -0,0 +1,179 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RFC 8441 carries a WebSocket over a single HTTP/2 stream opened with an
+// extended CONNECT request, instead of taking over the connection with an
+// HTTP/1.1 Upgrade. gorilla/websocket only implements the latter, so the
+// stream is passed to it as if it were a hijacked HTTP/1.1 connection.
+
+// isExtendedConnect reports whether r asks for a WebSocket over HTTP/2.
+func isExtendedConnect(r *http.Request) bool {
+	return r.Method == http.MethodConnect && r.Header.Get(":protocol") == "websocket"
+}
+
+// upgrade turns the request into a WebSocket connection over HTTP/1.1 or
+// HTTP/2.
+func (h *Hub) upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	if !isExtendedConnect(r) {
+		return h.upgrader.Upgrade(w, r, nil)
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	// Rewrite the request into the HTTP/1.1 handshake gorilla expects.
+	// Extensions are dropped because the negotiated ones would only be
+	// announced in the 101 response, which is never sent; HTTP/2 streams
+	// therefore go without permessage-deflate.
+	up := r.Clone(r.Context())
+	up.Method = http.MethodGet
+	up.Header.Set("Connection", "Upgrade")
+	up.Header.Set("Upgrade", "websocket")
+	up.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	up.Header.Del("Sec-WebSocket-Extensions")
+
+	return h.upgrader.Upgrade(&h2Hijacker{ResponseWriter: w, r: r}, up, nil)
+}
+
+// h2Hijacker lets gorilla hijack an HTTP/2 stream.
+type h2Hijacker struct {
+	http.ResponseWriter
+	r *http.Request
+}
+
+func (hj *h2Hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rc := http.NewResponseController(hj.ResponseWriter)
+
+	// The server's read and write timeouts would cut the stream off
+	// mid-transfer; the client pumps set their own deadlines.
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	hj.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	stream := &h2Stream{
+		body:   hj.r.Body,
+		w:      hj.ResponseWriter,
+		rc:     rc,
+		remote: hj.r.RemoteAddr,
+		done:   make(chan struct{}),
+	}
+	return stream, bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream)), nil
+}
+
+// h2Stream is an HTTP/2 stream used as a net.Conn. The ResponseWriter must
+// not be touched once the handler has returned, so every use of it holds
+// mu and checks closed.
+type h2Stream struct {
+	body      io.ReadCloser
+	w         http.ResponseWriter
+	rc        *http.ResponseController
+	remote    string
+	handshake bool // the 101 response has been swallowed
+	closed    bool
+	done      chan struct{}
+	mu        sync.Mutex
+}
+
+func (s *h2Stream) Read(p []byte) (int, error) {
+	return s.body.Read(p)
+}
+
+func (s *h2Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, net.ErrClosed
+	}
+	// gorilla writes its 101 response in a single Write. The 200 sent in
+	// Hijack already completed the handshake, so drop it.
+	if !s.handshake {
+		s.handshake = true
+		return len(p), nil
+	}
+
+	n, err := s.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, s.rc.Flush()
+}
+
+// Close ends the stream by letting its handler return.
+func (s *h2Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.body.Close()
+		close(s.done)
+	}
+	return nil
+}
+
+// wait blocks until the stream is closed. The HTTP/2 server ends a stream
+// when its handler returns, so the handler must wait here.
+func (s *h2Stream) wait() {
+	<-s.done
+}
+
+func (s *h2Stream) SetDeadline(t time.Time) error {
+	return errors.Join(s.SetReadDeadline(t), s.SetWriteDeadline(t))
+}
+
+func (s *h2Stream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return net.ErrClosed
+	}
+	return ignoreUnsupported(s.rc.SetReadDeadline(t))
+}
+
+func (s *h2Stream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return net.ErrClosed
+	}
+	return ignoreUnsupported(s.rc.SetWriteDeadline(t))
+}
+
+func (s *h2Stream) LocalAddr() net.Addr  { return h2Addr("") }
+func (s *h2Stream) RemoteAddr() net.Addr { return h2Addr(s.remote) }
+
+func ignoreUnsupported(err error) error {
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+// h2Addr is the address of an HTTP/2 stream's connection.
+type h2Addr string
+
+func (a h2Addr) Network() string { return "tcp" }
+func (a h2Addr) String() string  { return string(a) }
@@ -0,0 +1,211 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/http2"
+)
+
+// h2Server serves the test's hub over HTTP/2 with TLS, the way the server
+// sets it up in configureHTTP2.
+type h2Server struct {
+	srv       *httptest.Server
+	transport *http2.Transport
+}
+
+func (h *hubTest) startH2(t *testing.T) *h2Server {
+	t.Helper()
+
+	r := chi.NewRouter()
+	r.Use(testAuth)
+	r.Get("/ws/{code}", h.hub.HandleWebSocket)
+	r.Method(http.MethodConnect, "/ws/{code}", http.HandlerFunc(h.hub.HandleWebSocket))
+
+	srv := httptest.NewUnstartedServer(r)
+	if err := http2.ConfigureServer(srv.Config, &http2.Server{}); err != nil {
+		t.Fatal(err)
+	}
+	srv.TLS = &tls.Config{NextProtos: []string{http2.NextProtoTLS}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	transport := &http2.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	return &h2Server{srv: srv, transport: transport}
+}
+
+// dial opens a WebSocket over an HTTP/2 extended CONNECT request (RFC
+// 8441). It returns the response instead if the server refuses.
+func (s *h2Server) dial(t *testing.T, code, query string) (*testPeer, *http.Response) {
+	t.Helper()
+
+	u, err := url.Parse(s.srv.URL + "/ws/" + code + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The x/net client writes :protocol wherever it falls in the header
+	// map, and the server resets streams where it follows a regular
+	// header. Browsers get this right; GetBody lets the client retry until
+	// the map happens to put it first.
+	var pw *io.PipeWriter
+	body := func() (io.ReadCloser, error) {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		return pr, nil
+	}
+	pr, _ := body()
+	req, err := http.NewRequest(http.MethodConnect, u.String(), pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.GetBody = body
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	resp, err := s.transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("extended CONNECT: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		pw.Close()
+		resp.Body.Close()
+		return nil, resp
+	}
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("extended CONNECT answered over %s", resp.Proto)
+	}
+
+	stream := &h2ClientStream{body: resp.Body, w: pw}
+	wsURL := *u
+	wsURL.Scheme = "ws" // TLS is the HTTP/2 connection's business
+	conn, _, err := websocket.NewClient(stream, &wsURL, nil, 1024, 1024)
+	if err != nil {
+		stream.Close()
+		t.Fatalf("WebSocket over HTTP/2: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testPeer{t: t, conn: conn}, resp
+}
+
+// h2ClientStream is the client end of an extended CONNECT stream used as
+// a net.Conn. The 200 response already completed the handshake, so the
+// HTTP/1.1 handshake gorilla insists on is answered locally.
+type h2ClientStream struct {
+	body     io.ReadCloser
+	w        io.WriteCloser
+	r        io.Reader
+	deadline *time.Timer
+	mu       sync.Mutex
+}
+
+func (s *h2ClientStream) Write(p []byte) (int, error) {
+	if s.r != nil {
+		return s.w.Write(p)
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(p)))
+	if err != nil {
+		return 0, err
+	}
+	sum := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	accept := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	s.r = io.MultiReader(strings.NewReader(accept), s.body)
+	return len(p), nil
+}
+
+func (s *h2ClientStream) Read(p []byte) (int, error) { return s.r.Read(p) }
+
+func (s *h2ClientStream) Close() error {
+	s.w.Close()
+	return s.body.Close()
+}
+
+// SetReadDeadline ends the stream once t passes, which is all the tests
+// need to fail instead of hanging.
+func (s *h2ClientStream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deadline != nil {
+		s.deadline.Stop()
+		s.deadline = nil
+	}
+	if !t.IsZero() {
+		s.deadline = time.AfterFunc(time.Until(t), func() { s.body.Close() })
+	}
+	return nil
+}
+
+func (s *h2ClientStream) SetDeadline(t time.Time) error      { return s.SetReadDeadline(t) }
+func (s *h2ClientStream) SetWriteDeadline(t time.Time) error { return nil }
+func (s *h2ClientStream) LocalAddr() net.Addr                { return h2Addr("client") }
+func (s *h2ClientStream) RemoteAddr() net.Addr               { return h2Addr("server") }
+
+func TestHubHTTP2(t *testing.T) {
+	h := newHubTest(t, Options{})
+	h2 := h.startH2(t)
+	sess := h.createSession(t)
+
+	// The sender comes in over HTTP/2, the receiver over HTTP/1.1.
+	sender, _ := h2.dial(t, sess.Code, "")
+	sender.role = "sender"
+	sender.send(TypeRegister, RegisterPayload{Role: "sender", SessionID: sess.ID, Version: ProtocolVersion})
+	var ack RegisterAckPayload
+	sender.expect(TypeRegisterAck, &ack)
+	if !ack.Success {
+		t.Fatal("sender: register failed")
+	}
+	receiver, _ := h.join(t, sess, "receiver")
+	sender.expect(TypePeerJoined, nil)
+
+	for i := 0; i < 10; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, 16<<10)
+		sender.send(TypeChunk, ChunkPayload{Index: i, Data: base64.StdEncoding.EncodeToString(data), Size: len(data)})
+		var chunk ChunkPayload
+		receiver.expect(TypeChunk, &chunk)
+		if chunk.Index != i || chunk.Size != len(data) {
+			t.Fatalf("chunk %d: got index %d size %d", i, chunk.Index, chunk.Size)
+		}
+		receiver.send(TypeChunkAck, ChunkAckPayload{Index: i})
+		sender.expect(TypeChunkAck, nil)
+	}
+
+	// Closing the stream disconnects the sender like closing a socket.
+	sender.conn.Close()
+	receiver.expect(TypePeerLeft, nil)
+}
+
+func TestHubHTTP2Refused(t *testing.T) {
+	h := newHubTest(t, Options{})
+	h2 := h.startH2(t)
+
+	if _, resp := h2.dial(t, "NOPE-00", "?role=sender"); resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown session: got %v, want 404", resp)
+	}
+
+	sess := h.createSession(t)
+	if _, resp := h2.dial(t, sess.Code, "?role=spectator"); resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad role: got %v, want 400", resp)
+	}
+}
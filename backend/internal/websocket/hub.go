@@ -1,48 +1,217 @@
 package websocket
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"takedat/internal/auth"
+	"takedat/internal/origin"
+	"takedat/internal/realip"
 	"takedat/internal/session"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for now
-	},
+type SessionClients struct {
+	session *session.Session
+	streams map[int]*streamPair // stream number -> connections
+	// acceptCompression holds the algorithms from the receiver's
+	// transfer_request.
+	acceptCompression atomic.Pointer[[]string]
+	// replay holds unacknowledged reliable messages per role.
+	replay      map[string]*replayBuffer
+	replayLimit int
+	// limiter caps the session's relay bandwidth.
+	limiter     *rate.Limiter
+	limiterOnce sync.Once
+	mu          sync.RWMutex
 }
 
-type SessionClients struct {
-	sender   *Client
-	receiver *Client
-	mu       sync.RWMutex
+// Options configures a Hub.
+type Options struct {
+	// EnableCompression negotiates permessage-deflate with clients.
+	EnableCompression bool
+	// Compressions lists the chunk compression algorithms peers may
+	// negotiate, in addition to none.
+	Compressions []string
+	// MaxStreams is how many parallel connections each side of a session
+	// may open, including the control stream.
+	MaxStreams int
+	// RateLimits caps the bandwidth chunks are relayed at.
+	RateLimits RateLimits
+	// MaxPairs caps the sessions with both peers connected at once. Zero
+	// means unlimited.
+	MaxPairs int
+	// RequireSenderAuth and RequireReceiverAuth refuse anonymous clients
+	// in that role.
+	RequireSenderAuth   bool
+	RequireReceiverAuth bool
+	// Origins lists the browser origins allowed to connect besides our
+	// own. Nil allows same-origin connections only.
+	Origins *origin.Matcher
+
+	// Connection tunables. Zero values select the defaults in client.go.
+	WriteWait      time.Duration // time allowed to write a message
+	PongWait       time.Duration // time allowed between pongs
+	PingPeriod     time.Duration // must be less than PongWait
+	RegisterWait   time.Duration // time allowed to send the register message
+	MaxMessageSize int64         // largest message accepted from a client
+	// ReadBufferSize and WriteBufferSize size the upgrader's I/O buffers.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// SendQueue is how many outgoing messages are buffered per client
+	// before messages are dropped.
+	SendQueue int
+	// ReplayBuffer bounds the unacknowledged reliable messages kept per
+	// side of a session; ReplayGrace is how long they outlive the last
+	// connection.
+	ReplayBuffer int
+	ReplayGrace  time.Duration
+}
+
+// withDefaults fills in zero tunables.
+func (o Options) withDefaults() Options {
+	if o.MaxStreams < 1 {
+		o.MaxStreams = 1
+	}
+	if o.WriteWait <= 0 {
+		o.WriteWait = defaultWriteWait
+	}
+	if o.PongWait <= 0 {
+		o.PongWait = defaultPongWait
+	}
+	if o.PingPeriod <= 0 {
+		o.PingPeriod = (o.PongWait * 9) / 10
+	}
+	if o.RegisterWait <= 0 {
+		o.RegisterWait = defaultRegisterWait
+	}
+	if o.MaxMessageSize <= 0 {
+		o.MaxMessageSize = defaultMaxMessageSize
+	}
+	if o.ReadBufferSize <= 0 {
+		o.ReadBufferSize = defaultBufferSize
+	}
+	if o.WriteBufferSize <= 0 {
+		o.WriteBufferSize = defaultBufferSize
+	}
+	if o.SendQueue <= 0 {
+		o.SendQueue = defaultSendQueue
+	}
+	if o.ReplayBuffer <= 0 {
+		o.ReplayBuffer = defaultReplayBuffer
+	}
+	if o.ReplayGrace <= 0 {
+		o.ReplayGrace = defaultReplayGrace
+	}
+	return o
 }
 
+// expiredQueue is how many expired sessions may wait for the hub before
+// the session manager's notifications are handed off to goroutines.
+const expiredQueue = 64
+
 type Hub struct {
-	sessions   *session.Manager
-	clients    map[string]*SessionClients // code -> clients
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
+	sessions     *session.Manager
+	clients      map[string]*SessionClients // session ID -> clients
+	register     chan *Client
+	unregister   chan *Client
+	expired      chan *session.Session
+	upgrader     websocket.Upgrader
+	opts         Options
+	compressions []string
+	maxStreams   int
+	throttle     *throttle
+	maxPairs     int
+	requireAuth  map[string]bool // role -> anonymous clients refused
+	activePairs  int
+	counters     hubCounters
+	mu           sync.RWMutex
 }
 
-func NewHub(sessions *session.Manager) *Hub {
-	return &Hub{
+func NewHub(sessions *session.Manager, opts Options) *Hub {
+	opts = opts.withDefaults()
+	h := &Hub{
 		sessions:   sessions,
 		clients:    make(map[string]*SessionClients),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		expired:    make(chan *session.Session, expiredQueue),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:    opts.ReadBufferSize,
+			WriteBufferSize:   opts.WriteBufferSize,
+			EnableCompression: opts.EnableCompression,
+			CheckOrigin:       opts.Origins.CheckRequest,
+		},
+		opts:         opts,
+		compressions: opts.Compressions,
+		maxStreams:   opts.MaxStreams,
+		throttle:     newThrottle(opts.RateLimits),
+		maxPairs:     opts.MaxPairs,
+		requireAuth: map[string]bool{
+			"sender":   opts.RequireSenderAuth,
+			"receiver": opts.RequireReceiverAuth,
+		},
 	}
+	sessions.OnExpire(func(sess *session.Session) {
+		// Never hold up the session cleanup: when a burst of expiries
+		// fills the queue, hand the rest over in the background.
+		select {
+		case h.expired <- sess:
+		default:
+			go func() { h.expired <- sess }()
+		}
+	})
+	return h
+}
+
+// SetLimits replaces the relay bandwidth limits and the concurrent pair
+// limit. Transfers in progress continue at the new rates; pairs already
+// connected are never dropped.
+func (h *Hub) SetLimits(limits RateLimits, maxPairs int) {
+	h.throttle.setLimits(limits)
+
+	h.mu.Lock()
+	h.maxPairs = maxPairs
+	clients := make(map[string]*SessionClients, len(h.clients))
+	for id, sc := range h.clients {
+		clients[id] = sc
+	}
+	h.mu.Unlock()
+
+	for id, sc := range clients {
+		var ownerCap int64
+		if sess, err := h.sessions.GetByID(id); err == nil {
+			ownerCap = sess.SpeedLimit
+		}
+		setLimit(h.throttle.sessionLimiter(sc, ownerCap), sessionLimit(limits.Session, ownerCap))
+	}
+}
+
+// Limits returns the relay bandwidth limits and the concurrent pair limit
+// in effect.
+func (h *Hub) Limits() (RateLimits, int) {
+	h.throttle.mu.Lock()
+	limits := h.throttle.limits
+	h.throttle.mu.Unlock()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return limits, h.maxPairs
 }
 
 func (h *Hub) Run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
@@ -50,6 +219,12 @@ func (h *Hub) Run() {
 
 		case client := <-h.unregister:
 			h.removeClient(client)
+
+		case sess := <-h.expired:
+			h.expireSession(sess)
+
+		case <-ticker.C:
+			h.throttle.prune()
 		}
 	}
 }
@@ -63,11 +238,34 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if role != "sender" && role != "receiver" {
+	// Without a role the client registers over the socket instead.
+	if role != "" && role != "sender" && role != "receiver" {
 		http.Error(w, "Invalid role", http.StatusBadRequest)
 		return
 	}
 
+	stream := ControlStream
+	if v := r.URL.Query().Get("stream"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || h.validateStream(n) != nil {
+			http.Error(w, "Invalid stream", http.StatusBadRequest)
+			return
+		}
+		stream = n
+	}
+
+	principal := auth.FromContext(r.Context())
+	if role != "" {
+		if err := h.authorize(principal, role); err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, auth.ErrInsufficientScope) {
+				status = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
 	// Validate session exists
 	sess, err := h.sessions.GetByCode(code)
 	if err != nil {
@@ -83,92 +281,179 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if role == "sender" {
+		if err := h.checkOwner(principal, sess); err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, auth.ErrAuthRequired) {
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	if role == "receiver" {
+		if err := h.CheckRecipient(principal, sess); err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, auth.ErrAuthRequired) {
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	conn, err := h.upgrade(w, r)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 
-	client := NewClient(h, conn, code)
-	client.role = role
+	client := NewClient(h, conn, sess.Code)
 	client.session = sess.ID
-
-	h.register <- client
+	client.stream = stream
+	client.ip = realip.ClientIP(r)
+	client.principal = principal
+
+	if role != "" {
+		// Protocol version 1: the role comes from the query parameter and
+		// no capabilities are negotiated.
+		client.role = role
+		client.version = MinProtocolVersion
+		client.capabilities = []string{}
+		client.registered = true
+		h.register <- client
+	}
 
 	go client.WritePump()
 	go client.ReadPump()
+
+	if stream, ok := conn.NetConn().(*h2Stream); ok {
+		stream.wait()
+	}
 }
 
 func (h *Hub) addClient(client *Client) {
+	// The session may have expired since the client connected.
+	sess, err := h.sessions.GetByID(client.session)
+	if err != nil {
+		h.reject(client, "SESSION_EXPIRED", "Session has expired")
+		return
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	sc, exists := h.clients[client.code]
+	sc, exists := h.clients[client.session]
 	if !exists {
-		sc = &SessionClients{}
-		h.clients[client.code] = sc
+		sc = &SessionClients{session: sess, replayLimit: h.opts.ReplayBuffer}
+		h.clients[client.session] = sc
 	}
 
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
 
-	var peerConnected bool
+	if sc.client(client.role, client.stream) != nil {
+		// Already has a client in this slot, reject
+		h.reject(client, "SESSION_FULL", "Session already has a "+client.role+streamSuffix(client.stream))
+		return
+	}
 
-	if client.role == "sender" {
-		if sc.sender != nil {
-			// Already has a sender, reject
-			client.sendError("SESSION_FULL", "Session already has a sender", true)
-			client.conn.Close()
-			return
-		}
-		sc.sender = client
-		peerConnected = sc.receiver != nil
-	} else {
-		if sc.receiver != nil {
-			// Already has a receiver, reject
-			client.sendError("SESSION_FULL", "Session already has a receiver", true)
-			client.conn.Close()
-			return
-		}
-		sc.receiver = client
-		peerConnected = sc.sender != nil
+	if client.stream != ControlStream && sc.client(client.role, ControlStream) == nil {
+		h.reject(client, "NO_CONTROL_STREAM", "Open the control stream before data streams")
+		return
 	}
 
+	if !h.admitPair(client, sc) {
+		h.reject(client, "QUOTA_EXCEEDED", "Too many transfers in progress, try again later")
+		return
+	}
+
+	pair := sc.pair(client.stream)
+	pair.set(client.role, client)
+	peer := pair.get(oppositeRole(client.role))
+	peerConnected := peer != nil
+
 	// Send registration acknowledgment
-	ack, _ := NewMessage(TypeRegisterAck, RegisterAckPayload{
+	ackPayload := RegisterAckPayload{
 		Success:       true,
 		PeerConnected: peerConnected,
-	})
+		Version:       client.version,
+		Capabilities:  client.capabilities,
+		Stream:        client.stream,
+	}
+	if peer != nil {
+		ackPayload.PeerCapabilities = peer.capabilities
+	}
+	ack, _ := NewMessage(TypeRegisterAck, ackPayload)
 	client.Send(ack)
 
+	// Resend reliable messages the previous connection in this role may
+	// have missed.
+	if client.stream == ControlStream && client.hasCapability(CapReliable) {
+		h.replay(sc, client, client.resumeFrom)
+	}
+
 	// Notify peer if connected
 	if peerConnected {
-		peerMsg, _ := NewMessage(TypePeerJoined, PeerJoinedPayload{Role: client.role})
-		if client.role == "sender" && sc.receiver != nil {
-			sc.receiver.Send(peerMsg)
-		} else if client.role == "receiver" && sc.sender != nil {
-			sc.sender.Send(peerMsg)
-		}
+		peerMsg, _ := NewMessage(TypePeerJoined, PeerJoinedPayload{
+			Role:         client.role,
+			Capabilities: client.capabilities,
+			Stream:       client.stream,
+		})
+		peer.Send(peerMsg)
+	}
 
-		// Update session status
-		if sess, err := h.sessions.GetByCode(client.code); err == nil {
+	// Update session status
+	if client.stream == ControlStream {
+		if peerConnected {
 			sess.SetStatus(session.StatusPaired)
-		}
-	} else {
-		// Update session status to waiting
-		if sess, err := h.sessions.GetByCode(client.code); err == nil {
+		} else {
 			sess.SetStatus(session.StatusWaiting)
 		}
 	}
 
-	log.Printf("Client connected: code=%s role=%s peerConnected=%v", client.code, client.role, peerConnected)
+	log.Printf("Client connected: code=%s role=%s stream=%d peerConnected=%v", client.code, client.role, client.stream, peerConnected)
+}
+
+// expireSession tells everyone connected to an expired session and
+// disconnects them. Their unregistering then cleans up as usual.
+func (h *Hub) expireSession(sess *session.Session) {
+	h.mu.RLock()
+	sc, exists := h.clients[sess.ID]
+	h.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	msg, _ := NewMessage(TypeSessionExpired, SessionExpiredPayload{ExpiredAt: sess.ExpiresAt.UnixMilli()})
+
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	for _, role := range []string{"sender", "receiver"} {
+		sc.each(role, func(c *Client) {
+			c.Send(msg)
+			c.closeSend()
+		})
+	}
+	log.Printf("Session expired: code=%s", sess.Code)
+}
+
+// reject turns away a registered client that cannot take a slot in its
+// session. Closing send rather than the connection lets WritePump flush
+// the error first.
+func (h *Hub) reject(client *Client, code, message string) {
+	client.rejected.Store(true)
+	client.sendError(code, message, true)
+	client.closeSend()
 }
 
 func (h *Hub) removeClient(client *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	sc, exists := h.clients[client.code]
+	sc, exists := h.clients[client.session]
 	if !exists {
 		return
 	}
@@ -178,86 +463,247 @@ func (h *Hub) removeClient(client *Client) {
 
 	var peer *Client
 
-	if client.role == "sender" && sc.sender == client {
-		sc.sender = nil
-		peer = sc.receiver
-	} else if client.role == "receiver" && sc.receiver == client {
-		sc.receiver = nil
-		peer = sc.sender
+	if pair := sc.streams[client.stream]; pair != nil && pair.get(client.role) == client {
+		pair.set(client.role, nil)
+		peer = pair.get(oppositeRole(client.role))
+
+		// Data streams cannot outlive the control stream they belong to.
+		if client.stream == ControlStream {
+			if peer != nil {
+				h.activePairs--
+			}
+			sc.each(client.role, func(c *Client) {
+				c.conn.Close()
+			})
+		}
 	}
 
 	// Notify peer
 	if peer != nil {
-		leftMsg, _ := NewMessage(TypePeerLeft, PeerLeftPayload{Role: client.role})
+		leftMsg, _ := NewMessage(TypePeerLeft, PeerLeftPayload{Role: client.role, Stream: client.stream})
 		peer.Send(leftMsg)
 	}
 
-	// Cleanup if both disconnected
-	if sc.sender == nil && sc.receiver == nil {
-		delete(h.clients, client.code)
+	// Cleanup if everyone disconnected, keeping undelivered reliable
+	// messages around for a while in case a client reconnects
+	if sc.empty() {
+		if sc.hasPending() {
+			h.scheduleCleanup(client.session, sc)
+		} else {
+			delete(h.clients, client.session)
+		}
 	}
 
-	close(client.send)
-	log.Printf("Client disconnected: code=%s role=%s", client.code, client.role)
+	client.closeSend()
+	log.Printf("Client disconnected: code=%s role=%s stream=%d", client.code, client.role, client.stream)
 }
 
 func (h *Hub) handleMessage(client *Client, msg *Message) {
 	h.mu.RLock()
-	sc, exists := h.clients[client.code]
+	sc, exists := h.clients[client.session]
 	h.mu.RUnlock()
 
 	if !exists {
 		return
 	}
 
+	// Wait for bandwidth before taking the session lock so a throttled
+	// sender does not hold up its peer's acks.
+	charged := false
+	if msg.Type == TypeChunk && client.role == "sender" {
+		if !h.throttleChunk(client, sc, msg) || !h.chargeChunk(client, msg) {
+			return
+		}
+		charged = true
+	}
+
+	if msg.Type == TypeChunk {
+		h.relayChunk(client, sc, msg, charged)
+		return
+	}
+
 	sc.mu.RLock()
 	defer sc.mu.RUnlock()
 
+	if client.stream != ControlStream && !dataStreamTypes[msg.Type] {
+		client.sendError("CONTROL_ONLY", "Only chunks may be sent on data streams", false)
+		return
+	}
+
 	switch msg.Type {
 	case TypePing:
 		pong, _ := NewMessage(TypePong, nil)
 		client.Send(pong)
 
-	case TypeTransferRequest, TypeTransferAccept, TypeFileMeta, TypeChunk, TypeChunkAck, TypeTransferComplete:
+	case TypeTransferRequest:
+		h.handleTransferRequest(client, sc, msg)
+
+	case TypeFileMeta:
+		h.handleFileMeta(client, sc, msg)
+
+	case TypeTransferAccept, TypeChunkAck, TypeTransferComplete:
 		// Relay to peer
-		h.relayToPeer(client, sc, msg)
+		if h.relayToPeer(client, sc, msg) {
+			h.trackTransfer(client, msg)
+		}
+
+	case TypeOffer, TypeAnswer, TypeICECandidate, TypeTransport:
+		h.handleSignal(client, sc, msg)
+
+	case TypeAck, TypeNack:
+		h.handleAck(client, sc, msg)
 
 	default:
 		client.sendError("UNKNOWN_MESSAGE", "Unknown message type", false)
 	}
 }
 
-func (h *Hub) relayToPeer(client *Client, sc *SessionClients, msg *Message) {
-	var peer *Client
+// peerOf returns the client on the other end of client's stream, if any.
+// Callers must hold sc.mu.
+func (sc *SessionClients) peerOf(client *Client) *Client {
+	return sc.client(oppositeRole(client.role), client.stream)
+}
+
+func (h *Hub) relayToPeer(client *Client, sc *SessionClients, msg *Message) bool {
+	if !h.deliver(sc, oppositeRole(client.role), sc.peerOf(client), msg) {
+		if sc.peerOf(client) == nil {
+			client.sendError("PEER_DISCONNECTED", "Peer is not connected", false)
+		}
+		return false
+	}
+	return true
+}
 
-	if client.role == "sender" {
-		peer = sc.receiver
-	} else {
-		peer = sc.sender
+// relayChunk forwards a chunk to the peer on client's stream, waiting for
+// room in the peer's send queue so a fast sender is held to a slow
+// receiver's pace. Only the lookup holds sc.mu: while the chunk waits, the
+// session and the hub carry on as usual. Chunks the sender was charged for
+// but that never reach the peer are refunded.
+func (h *Hub) relayChunk(client *Client, sc *SessionClients, msg *Message, charged bool) {
+	sc.mu.RLock()
+	peer := sc.peerOf(client)
+	sc.mu.RUnlock()
+
+	delivered := false
+	if peer != nil {
+		if bytes, err := msg.Bytes(); err == nil {
+			delivered = peer.queueBytes(bytes)
+		}
+		// As with throttling, time spent waiting for the receiver must
+		// not count against the pong deadline.
+		client.conn.SetReadDeadline(time.Now().Add(h.opts.PongWait))
 	}
 
-	if peer == nil {
-		client.sendError("PEER_DISCONNECTED", "Peer is not connected", false)
+	if !delivered {
+		if peer == nil {
+			client.sendError("PEER_DISCONNECTED", "Peer is not connected", false)
+		}
+		if charged {
+			h.refundChunk(client, msg)
+		}
 		return
 	}
+	h.trackTransfer(client, msg)
+}
 
-	// Re-encode and forward
-	bytes, err := json.Marshal(msg)
+// trackTransfer updates the session's transfer statistics for a message that
+// was successfully relayed.
+func (h *Hub) trackTransfer(client *Client, msg *Message) {
+	sess, err := h.sessions.GetByID(client.session)
 	if err != nil {
 		return
 	}
 
-	select {
-	case peer.send <- bytes:
-	default:
-		// Buffer full, drop message
-		log.Printf("Dropping message, peer buffer full")
+	switch msg.Type {
+	case TypeChunk:
+		if client.role != "sender" {
+			return
+		}
+		var payload ChunkPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return
+		}
+		// Progress counts file bytes, which for compressed chunks only the
+		// sender's claimed size tells; it can never be less than what was
+		// actually relayed.
+		relayed := decodedLen(payload.Data)
+		sess.RecordChunk(max(int64(payload.Size), relayed), relayed)
+
+	case TypeChunkAck:
+		if client.role != "receiver" {
+			return
+		}
+		var payload ChunkAckPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil || !payload.Success {
+			return
+		}
+		sess.RecordAck()
+
+	case TypeTransferComplete:
+		if client.role != "sender" {
+			return
+		}
+		summary := sess.Complete()
+		log.Printf("Transfer complete: code=%s bytes=%d chunks=%d duration=%s",
+			client.code, summary.TotalBytes, summary.TotalChunks, summary.Duration)
+	}
+}
+
+// decodedLen returns the number of bytes encoded by a standard base64 string
+// without decoding it.
+func decodedLen(data string) int64 {
+	n := base64.StdEncoding.DecodedLen(len(data))
+	for i := len(data) - 1; i >= 0 && data[i] == '='; i-- {
+		n--
+	}
+	if n < 0 {
+		return 0
+	}
+	return int64(n)
+}
+
+// authorize checks that p may connect as role: senders need the create
+// scope and receivers the receive scope.
+func (h *Hub) authorize(p auth.Principal, role string) error {
+	scope := auth.ScopeReceive
+	if role == "sender" {
+		scope = auth.ScopeCreate
+	}
+	return p.Authorize(h.requireAuth[role], scope)
+}
+
+// CheckJoin reports whether p may join sess as role, making the same checks
+// as the WebSocket upgrade: senders need the create scope and must own the
+// session, receivers need the receive scope and must be on its recipient
+// allowlist.
+func (h *Hub) CheckJoin(p auth.Principal, role string, sess *session.Session) error {
+	if err := h.authorize(p, role); err != nil {
+		return err
+	}
+	if role == "sender" {
+		return h.checkOwner(p, sess)
+	}
+	return h.CheckRecipient(p, sess)
+}
+
+// checkOwner refuses senders other than the session's creator. Sessions
+// created anonymously may be sent to by anyone holding the code.
+func (h *Hub) checkOwner(p auth.Principal, sess *session.Session) error {
+	if sess.Owner == nil || sess.OwnedBy(p.Subject()) {
+		return nil
+	}
+
+	err := session.ErrNotOwner
+	if p.Anonymous() {
+		err = auth.ErrAuthRequired
 	}
+	log.Printf("Sender rejected: code=%s subject=%q", sess.Code, p.Subject())
+	return err
 }
 
-func (h *Hub) GetSessionClients(code string) (*SessionClients, bool) {
+func (h *Hub) GetSessionClients(sessionID string) (*SessionClients, bool) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	sc, exists := h.clients[code]
+	sc, exists := h.clients[sessionID]
 	return sc, exists
 }
@@ -0,0 +1,739 @@
+package websocket
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"takedat/internal/auth"
+	"takedat/internal/session"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// testClock is a session clock the test moves forward by hand.
+type testClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type hubTest struct {
+	srv      *httptest.Server
+	hub      *Hub
+	sessions *session.Manager
+	clock    *testClock
+}
+
+// newHubTest starts a hub behind a test server. Sessions live for a
+// minute of the test clock and are swept as soon as they expire.
+func newHubTest(t *testing.T, opts Options) *hubTest {
+	t.Helper()
+
+	clock := &testClock{now: time.Now()}
+	sessions := session.NewManager(session.Options{
+		TTL:             time.Minute,
+		CleanupInterval: time.Millisecond,
+		Clock:           clock,
+	})
+	hub := NewHub(sessions, opts)
+	go hub.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go sessions.StartCleanup(ctx)
+
+	r := chi.NewRouter()
+	r.Use(testAuth)
+	r.Get("/ws/{code}", hub.HandleWebSocket)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return &hubTest{srv: srv, hub: hub, sessions: sessions, clock: clock}
+}
+
+// testUsers are the principals testAuth knows, by access token.
+var testUsers = map[string]auth.Principal{
+	"alice": {User: &auth.Identity{Subject: "alice", Email: "alice@example.com"}},
+	"bob":   {User: &auth.Identity{Subject: "bob", Email: "bob@example.com"}},
+}
+
+// testAuth stands in for the API's authentication middleware, taking the
+// principal from the access_token query parameter.
+func testAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := testUsers[r.URL.Query().Get("access_token")]; ok {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), p))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *hubTest) createSession(t *testing.T) *session.Session {
+	t.Helper()
+
+	sess, err := h.sessions.Create(session.CreateParams{FileName: "a.bin", FileSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sess
+}
+
+// testPeer is one end of a session, speaking the wire protocol.
+type testPeer struct {
+	t    *testing.T
+	conn *websocket.Conn
+	role string
+}
+
+// dial opens a socket for code. query is appended to the URL as is.
+func (h *hubTest) dial(t *testing.T, code, query string) (*testPeer, *http.Response, error) {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(h.srv.URL, "http") + "/ws/" + code + query
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, resp, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testPeer{t: t, conn: conn}, resp, nil
+}
+
+// join connects as role with the register handshake and waits for the
+// acknowledgement.
+func (h *hubTest) join(t *testing.T, sess *session.Session, role string) (*testPeer, RegisterAckPayload) {
+	t.Helper()
+	return h.register(t, sess, RegisterPayload{Role: role, SessionID: sess.ID, Version: ProtocolVersion})
+}
+
+// register connects and sends reg, waiting for the acknowledgement.
+func (h *hubTest) register(t *testing.T, sess *session.Session, reg RegisterPayload) (*testPeer, RegisterAckPayload) {
+	t.Helper()
+
+	role := reg.Role
+	p, _, err := h.dial(t, sess.Code, "")
+	if err != nil {
+		t.Fatalf("%s: dial: %v", role, err)
+	}
+	p.role = role
+	p.send(TypeRegister, reg)
+
+	var ack RegisterAckPayload
+	p.expect(TypeRegisterAck, &ack)
+	if !ack.Success {
+		t.Fatalf("%s: register failed", role)
+	}
+	return p, ack
+}
+
+func (p *testPeer) send(msgType MessageType, payload any) {
+	p.t.Helper()
+
+	msg, err := NewMessage(msgType, payload)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	if err := p.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		p.t.Fatalf("%s: send %s: %v", p.role, msgType, err)
+	}
+}
+
+// read returns the next message, failing the test if none arrives in
+// time.
+func (p *testPeer) read() *Message {
+	p.t.Helper()
+
+	p.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := p.conn.ReadMessage()
+	if err != nil {
+		p.t.Fatalf("%s: read: %v", p.role, err)
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		p.t.Fatalf("%s: decode %q: %v", p.role, data, err)
+	}
+	return &msg
+}
+
+// expect reads the next message, checks its type and decodes its payload
+// into payload, if given.
+func (p *testPeer) expect(msgType MessageType, payload any) {
+	p.t.Helper()
+
+	msg := p.read()
+	if msg.Type != msgType {
+		p.t.Fatalf("%s: got %s %s, want %s", p.role, msg.Type, msg.Payload, msgType)
+	}
+	if payload != nil {
+		if err := json.Unmarshal(msg.Payload, payload); err != nil {
+			p.t.Fatalf("%s: decode %s payload: %v", p.role, msgType, err)
+		}
+	}
+}
+
+// expectError reads an error message and checks its code.
+func (p *testPeer) expectError(code string) ErrorPayload {
+	p.t.Helper()
+
+	var payload ErrorPayload
+	p.expect(TypeError, &payload)
+	if payload.Code != code {
+		p.t.Fatalf("%s: error %s (%s), want %s", p.role, payload.Code, payload.Message, code)
+	}
+	return payload
+}
+
+// expectClosed waits for the server to close the connection.
+func (p *testPeer) expectClosed() {
+	p.t.Helper()
+
+	p.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := p.conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure) ||
+			strings.Contains(err.Error(), "EOF") {
+			return
+		}
+		p.t.Fatalf("%s: waiting for close: %v (last %q)", p.role, err, data)
+	}
+}
+
+// pair connects a sender and a receiver to a new session.
+func (h *hubTest) pair(t *testing.T) (*session.Session, *testPeer, *testPeer) {
+	t.Helper()
+
+	sess := h.createSession(t)
+	sender, ack := h.join(t, sess, "sender")
+	if ack.PeerConnected {
+		t.Fatal("sender: peer connected before the receiver joined")
+	}
+	receiver, ack := h.join(t, sess, "receiver")
+	if !ack.PeerConnected {
+		t.Fatal("receiver: sender not reported as connected")
+	}
+
+	var joined PeerJoinedPayload
+	sender.expect(TypePeerJoined, &joined)
+	if joined.Role != "receiver" {
+		t.Fatalf("sender: peer_joined role = %q, want receiver", joined.Role)
+	}
+	return sess, sender, receiver
+}
+
+func TestHubRelay(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess, sender, receiver := h.pair(t)
+
+	if got := sess.GetStatus(); got != session.StatusPaired {
+		t.Errorf("status after pairing = %q, want %q", got, session.StatusPaired)
+	}
+
+	const chunks = 20
+	var total int64
+	for i := 0; i < chunks; i++ {
+		data := []byte(strings.Repeat(fmt.Sprint(i), 100+i))
+		total += int64(len(data))
+		sender.send(TypeChunk, ChunkPayload{Index: i, Data: base64.StdEncoding.EncodeToString(data), Size: len(data)})
+	}
+
+	for i := 0; i < chunks; i++ {
+		var chunk ChunkPayload
+		receiver.expect(TypeChunk, &chunk)
+		data, err := base64.StdEncoding.DecodeString(chunk.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.Repeat(fmt.Sprint(i), 100+i); chunk.Index != i || string(data) != want {
+			t.Fatalf("chunk %d: got index %d with %d bytes", i, chunk.Index, len(data))
+		}
+		receiver.send(TypeChunkAck, ChunkAckPayload{Index: i, Success: true})
+	}
+
+	for i := 0; i < chunks; i++ {
+		var ack ChunkAckPayload
+		sender.expect(TypeChunkAck, &ack)
+		if ack.Index != i || !ack.Success {
+			t.Fatalf("ack %d: got %+v", i, ack)
+		}
+	}
+
+	sender.send(TypeTransferComplete, TransferCompletePayload{TotalBytes: total, TotalChunks: chunks})
+	var complete TransferCompletePayload
+	receiver.expect(TypeTransferComplete, &complete)
+	if complete.TotalBytes != total || complete.TotalChunks != chunks {
+		t.Errorf("transfer_complete = %+v", complete)
+	}
+
+	// The receiver saw transfer_complete after the hub recorded it.
+	summary := sess.Summary()
+	if summary == nil {
+		t.Fatal("no summary after transfer_complete")
+	}
+	if summary.TotalBytes != total || summary.TotalChunks != chunks || summary.ChunksAcked != chunks {
+		t.Errorf("summary = %+v, want %d bytes in %d acked chunks", summary, total, chunks)
+	}
+	if got := sess.GetStatus(); got != session.StatusCompleted {
+		t.Errorf("status after transfer = %q, want %q", got, session.StatusCompleted)
+	}
+}
+
+func TestHubPing(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess := h.createSession(t)
+	sender, _ := h.join(t, sess, "sender")
+
+	sender.send(TypePing, nil)
+	sender.expect(TypePong, nil)
+
+	sender.send("bogus", nil)
+	if payload := sender.expectError("UNKNOWN_MESSAGE"); payload.Fatal {
+		t.Error("unknown message type is fatal")
+	}
+}
+
+func TestHubPeerLeft(t *testing.T) {
+	tests := []struct {
+		leaves string
+		// relay is a message the remaining peer sends to the one that left.
+		relay MessageType
+	}{
+		{"sender", TypeChunkAck},
+		{"receiver", TypeChunk},
+	}
+	for _, tt := range tests {
+		h := newHubTest(t, Options{})
+		sess, sender, receiver := h.pair(t)
+
+		leaving, staying := sender, receiver
+		if tt.leaves == "receiver" {
+			leaving, staying = receiver, sender
+		}
+		leaving.conn.Close()
+
+		var left PeerLeftPayload
+		staying.expect(TypePeerLeft, &left)
+		if left.Role != tt.leaves {
+			t.Errorf("%s leaves: peer_left role = %q", tt.leaves, left.Role)
+		}
+
+		staying.send(tt.relay, ChunkPayload{Index: 0, Data: "AA==", Size: 1})
+		staying.expectError("PEER_DISCONNECTED")
+
+		// The slot is free again, and the remaining peer hears about the
+		// new connection.
+		back, ack := h.join(t, sess, tt.leaves)
+		if !ack.PeerConnected {
+			t.Errorf("%s leaves: rejoining peer not told the other side is connected", tt.leaves)
+		}
+		var joined PeerJoinedPayload
+		staying.expect(TypePeerJoined, &joined)
+		if joined.Role != tt.leaves {
+			t.Errorf("%s leaves: peer_joined role = %q", tt.leaves, joined.Role)
+		}
+
+		staying.send(tt.relay, ChunkPayload{Index: 1, Data: "AA==", Size: 1})
+		back.expect(tt.relay, nil)
+	}
+}
+
+func TestHubSessionFull(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess, _, _ := h.pair(t)
+
+	for _, role := range []string{"sender", "receiver"} {
+		p, _, err := h.dial(t, sess.Code, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.role = role
+		p.send(TypeRegister, RegisterPayload{Role: role, Version: ProtocolVersion})
+		if payload := p.expectError("SESSION_FULL"); !payload.Fatal {
+			t.Errorf("%s: SESSION_FULL is not fatal", role)
+		}
+		p.expectClosed()
+	}
+}
+
+func TestHubSessionExpired(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess, sender, receiver := h.pair(t)
+
+	h.clock.Advance(time.Minute + time.Second)
+
+	for _, p := range []*testPeer{sender, receiver} {
+		var expired SessionExpiredPayload
+		p.expect(TypeSessionExpired, &expired)
+		if expired.ExpiredAt != sess.ExpiresAt.UnixMilli() {
+			t.Errorf("%s: expiredAt = %d, want %d", p.role, expired.ExpiredAt, sess.ExpiresAt.UnixMilli())
+		}
+		p.expectClosed()
+	}
+
+	if _, resp, err := h.dial(t, sess.Code, "?role=sender"); err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("dial after expiry: got %v, want 404", err)
+	}
+
+	// A session waiting for its receiver expires the same way.
+	sess = h.createSession(t)
+	sender, _ = h.join(t, sess, "sender")
+	h.clock.Advance(time.Minute + time.Second)
+	sender.expect(TypeSessionExpired, nil)
+	sender.expectClosed()
+}
+
+// TestHubExpireBurst checks that a burst of expiries larger than the hub's
+// queue does not hold up the session cleanup while the hub is busy.
+func TestHubExpireBurst(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	sessions := session.NewManager(session.Options{
+		TTL:             time.Minute,
+		CleanupInterval: time.Millisecond,
+		Clock:           clock,
+	})
+	NewHub(sessions, Options{}) // not running
+
+	n := expiredQueue + 10
+	for i := 0; i < n; i++ {
+		if _, err := sessions.Create(session.CreateParams{FileName: "a.bin", FileSize: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notified := make(chan struct{}, n)
+	sessions.OnExpire(func(*session.Session) { notified <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sessions.StartCleanup(ctx)
+	clock.Advance(time.Minute + time.Second)
+
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case <-notified:
+		case <-timeout:
+			t.Fatalf("cleanup blocked after %d of %d expiries", i, n)
+		}
+	}
+}
+
+func TestHubSenderOwner(t *testing.T) {
+	h := newHubTest(t, Options{})
+	owned, err := h.sessions.Create(session.CreateParams{
+		FileName: "a.bin",
+		FileSize: 1,
+		Owner:    &session.Owner{Subject: "alice"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous := h.createSession(t)
+
+	tests := []struct {
+		name  string
+		sess  *session.Session
+		token string
+		code  string // register error, empty if accepted
+		want  int    // status of a query parameter upgrade
+	}{
+		{"owner", owned, "alice", "", http.StatusSwitchingProtocols},
+		{"other user", owned, "bob", "NOT_OWNER", http.StatusForbidden},
+		{"anonymous", owned, "", "AUTH_REQUIRED", http.StatusUnauthorized},
+		{"anonymous session", anonymous, "bob", "", http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		p, resp, err := h.dial(t, tt.sess.Code, "?role=sender&access_token="+tt.token)
+		if resp == nil || resp.StatusCode != tt.want {
+			t.Errorf("%s: query upgrade: %v, want %d", tt.name, err, tt.want)
+		}
+		if p != nil {
+			p.conn.Close()
+		}
+
+		p, _, err = h.dial(t, tt.sess.Code, "?access_token="+tt.token)
+		if err != nil {
+			t.Fatalf("%s: dial: %v", tt.name, err)
+		}
+		p.role = "sender"
+		p.send(TypeRegister, RegisterPayload{Role: "sender", Version: ProtocolVersion})
+		if tt.code == "" {
+			p.expect(TypeRegisterAck, nil)
+			p.conn.Close()
+			continue
+		}
+		if payload := p.expectError(tt.code); !payload.Fatal {
+			t.Errorf("%s: %s is not fatal", tt.name, tt.code)
+		}
+		p.expectClosed()
+	}
+}
+
+func TestHubRegister(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  MessageType
+		reg  RegisterPayload
+		code string
+	}{
+		{"not register", TypePing, RegisterPayload{}, "NOT_REGISTERED"},
+		{"bad role", TypeRegister, RegisterPayload{Role: "spectator", Version: ProtocolVersion}, "INVALID_ROLE"},
+		{"old version", TypeRegister, RegisterPayload{Role: "sender", Version: 0}, "INCOMPATIBLE_PROTOCOL"},
+		{"unknown requirement", TypeRegister, RegisterPayload{Role: "sender", Version: ProtocolVersion, Require: []string{"teleport"}}, "INCOMPATIBLE_PROTOCOL"},
+		{"wrong session", TypeRegister, RegisterPayload{Role: "sender", Version: ProtocolVersion, SessionID: "other"}, "SESSION_MISMATCH"},
+		{"bad stream", TypeRegister, RegisterPayload{Role: "sender", Version: ProtocolVersion, Stream: 5}, "INVALID_STREAM"},
+	}
+	for _, tt := range tests {
+		h := newHubTest(t, Options{})
+		sess := h.createSession(t)
+
+		p, _, err := h.dial(t, sess.Code, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.role = tt.name
+		p.send(tt.msg, tt.reg)
+		if payload := p.expectError(tt.code); !payload.Fatal {
+			t.Errorf("%s: %s is not fatal", tt.name, tt.code)
+		}
+		p.expectClosed()
+	}
+}
+
+func TestHubNegotiation(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess := h.createSession(t)
+
+	sender, _, err := h.dial(t, sess.Code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.role = "sender"
+	sender.send(TypeRegister, RegisterPayload{
+		Role:         "sender",
+		Version:      ProtocolVersion + 1,
+		Capabilities: []string{CapCompression, "teleport", CapReliable},
+	})
+	var ack RegisterAckPayload
+	sender.expect(TypeRegisterAck, &ack)
+	if ack.Version != ProtocolVersion {
+		t.Errorf("version = %d, want %d", ack.Version, ProtocolVersion)
+	}
+	if strings.Join(ack.Capabilities, ",") != CapCompression+","+CapReliable {
+		t.Errorf("capabilities = %v", ack.Capabilities)
+	}
+
+	// Version 1 clients pick their role in the URL and skip registering.
+	receiver, _, err := h.dial(t, sess.Code, "?role=receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver.role = "receiver"
+	receiver.expect(TypeRegisterAck, &ack)
+	if ack.Version != MinProtocolVersion || len(ack.Capabilities) != 0 || !ack.PeerConnected {
+		t.Errorf("version 1 ack = %+v", ack)
+	}
+
+	var joined PeerJoinedPayload
+	sender.expect(TypePeerJoined, &joined)
+	if joined.Role != "receiver" || len(joined.Capabilities) != 0 {
+		t.Errorf("peer_joined = %+v", joined)
+	}
+}
+
+func TestHubHandshakeErrors(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess := h.createSession(t)
+
+	tests := []struct {
+		name   string
+		code   string
+		query  string
+		status int
+	}{
+		{"unknown session", "ZZZ-ZZZ", "", http.StatusNotFound},
+		{"bad role", sess.Code, "?role=spectator", http.StatusBadRequest},
+		{"bad stream", sess.Code, "?role=sender&stream=x", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		_, resp, err := h.dial(t, tt.code, tt.query)
+		if err == nil {
+			t.Errorf("%s: dial succeeded", tt.name)
+			continue
+		}
+		if resp == nil || resp.StatusCode != tt.status {
+			t.Errorf("%s: got %v, want %d", tt.name, err, tt.status)
+		}
+	}
+}
+
+// joinStream connects as role on a data stream of sess.
+func (h *hubTest) joinStream(t *testing.T, sess *session.Session, role string, stream int) (*testPeer, RegisterAckPayload) {
+	t.Helper()
+	return h.register(t, sess, RegisterPayload{Role: role, SessionID: sess.ID, Version: ProtocolVersion, Stream: stream})
+}
+
+// pairStreams pairs a session and opens streams data streams on each side,
+// returning the sender's and receiver's connections indexed by stream.
+func (h *hubTest) pairStreams(t *testing.T, streams int) (*session.Session, []*testPeer, []*testPeer) {
+	t.Helper()
+
+	sess, sender, receiver := h.pair(t)
+	senders := []*testPeer{sender}
+	receivers := []*testPeer{receiver}
+	for stream := 1; stream <= streams; stream++ {
+		s, ack := h.joinStream(t, sess, "sender", stream)
+		if ack.Stream != stream || ack.PeerConnected {
+			t.Fatalf("sender stream %d: ack = %+v", stream, ack)
+		}
+		r, ack := h.joinStream(t, sess, "receiver", stream)
+		if ack.Stream != stream || !ack.PeerConnected {
+			t.Fatalf("receiver stream %d: ack = %+v", stream, ack)
+		}
+
+		var joined PeerJoinedPayload
+		s.expect(TypePeerJoined, &joined)
+		if joined.Role != "receiver" || joined.Stream != stream {
+			t.Fatalf("sender stream %d: peer_joined = %+v", stream, joined)
+		}
+		senders = append(senders, s)
+		receivers = append(receivers, r)
+	}
+	return sess, senders, receivers
+}
+
+func TestHubStreams(t *testing.T) {
+	h := newHubTest(t, Options{MaxStreams: 3})
+	sess, senders, receivers := h.pairStreams(t, 2)
+
+	// Stripe chunks across every stream; each one arrives on, and is
+	// acknowledged over, the stream it was sent on.
+	const chunks = 12
+	var total int64
+	for i := 0; i < chunks; i++ {
+		stream := i % len(senders)
+		data := []byte(strings.Repeat("x", 100+i))
+		total += int64(len(data))
+		senders[stream].send(TypeChunk, ChunkPayload{Index: i, Data: base64.StdEncoding.EncodeToString(data), Size: len(data)})
+
+		var chunk ChunkPayload
+		receivers[stream].expect(TypeChunk, &chunk)
+		if chunk.Index != i {
+			t.Fatalf("stream %d: got chunk %d, want %d", stream, chunk.Index, i)
+		}
+		receivers[stream].send(TypeChunkAck, ChunkAckPayload{Index: i, Success: true})
+
+		var ack ChunkAckPayload
+		senders[stream].expect(TypeChunkAck, &ack)
+		if ack.Index != i {
+			t.Fatalf("stream %d: got ack %d, want %d", stream, ack.Index, i)
+		}
+	}
+
+	// A pong means the hub is done with everything sent before the ping.
+	for _, p := range append(senders, receivers...) {
+		p.send(TypePing, nil)
+		p.expect(TypePong, nil)
+	}
+
+	progress := sess.Progress()
+	if progress.ChunksRelayed != chunks || progress.ChunksAcked != chunks || progress.BytesRelayed != total {
+		t.Errorf("progress = %+v, want %d bytes in %d acked chunks", progress, total, chunks)
+	}
+
+	// Data streams only carry chunks.
+	senders[1].send(TypeTransferComplete, TransferCompletePayload{TotalBytes: total, TotalChunks: chunks})
+	if payload := senders[1].expectError("CONTROL_ONLY"); payload.Fatal {
+		t.Error("CONTROL_ONLY is fatal")
+	}
+}
+
+func TestHubStreamWithoutControl(t *testing.T) {
+	h := newHubTest(t, Options{MaxStreams: 2})
+	sess := h.createSession(t)
+
+	p, _, err := h.dial(t, sess.Code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.role = "sender"
+	p.send(TypeRegister, RegisterPayload{Role: "sender", Version: ProtocolVersion, Stream: 1})
+	if payload := p.expectError("NO_CONTROL_STREAM"); !payload.Fatal {
+		t.Error("NO_CONTROL_STREAM is not fatal")
+	}
+	p.expectClosed()
+
+	// Once the control stream is open the data stream is accepted.
+	h.join(t, sess, "sender")
+	h.joinStream(t, sess, "sender", 1)
+}
+
+func TestHubStreamLimit(t *testing.T) {
+	h := newHubTest(t, Options{MaxStreams: 2})
+	sess := h.createSession(t)
+	h.join(t, sess, "sender")
+
+	for _, stream := range []int{2, -1} {
+		p, _, err := h.dial(t, sess.Code, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.role = "sender"
+		p.send(TypeRegister, RegisterPayload{Role: "sender", Version: ProtocolVersion, Stream: stream})
+		if payload := p.expectError("INVALID_STREAM"); !payload.Fatal {
+			t.Errorf("stream %d: INVALID_STREAM is not fatal", stream)
+		}
+		p.expectClosed()
+
+		query := fmt.Sprintf("?role=sender&stream=%d", stream)
+		if _, resp, err := h.dial(t, sess.Code, query); err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("stream %d: query upgrade: %v, want 400", stream, err)
+		}
+	}
+}
+
+func TestHubControlStreamLeaves(t *testing.T) {
+	h := newHubTest(t, Options{MaxStreams: 3})
+	_, senders, receivers := h.pairStreams(t, 2)
+
+	senders[ControlStream].conn.Close()
+
+	// The sender's data streams go with its control stream, and the
+	// receiver hears about each of them.
+	for stream, p := range senders[1:] {
+		p.role = fmt.Sprintf("sender stream %d", stream+1)
+		p.expectClosed()
+	}
+	for stream, p := range receivers {
+		var left PeerLeftPayload
+		p.expect(TypePeerLeft, &left)
+		if left.Role != "sender" || left.Stream != stream {
+			t.Errorf("receiver stream %d: peer_left = %+v", stream, left)
+		}
+	}
+}
@@ -15,16 +15,32 @@ const (
 	TypeTransferRequest  MessageType = "transfer_request"
 	TypeTransferAccept   MessageType = "transfer_accept"
 	TypeFileMeta         MessageType = "file_meta"
+	TypeFileMetaAck      MessageType = "file_meta_ack"
 	TypeChunk            MessageType = "chunk"
 	TypeChunkAck         MessageType = "chunk_ack"
 	TypeTransferComplete MessageType = "transfer_complete"
 	TypeError            MessageType = "error"
 	TypePing             MessageType = "ping"
 	TypePong             MessageType = "pong"
+	TypeAck              MessageType = "ack"
+	TypeNack             MessageType = "nack"
+	// Sent to the sender when someone outside the recipient allowlist
+	// tries to receive.
+	TypeRecipientRejected MessageType = "recipient_rejected"
+	// Sent to everyone connected to a session when it expires, just
+	// before the hub disconnects them.
+	TypeSessionExpired MessageType = "session_expired"
+
+	// WebRTC signaling, relayed between paired peers
+	TypeOffer        MessageType = "offer"
+	TypeAnswer       MessageType = "answer"
+	TypeICECandidate MessageType = "ice_candidate"
+	TypeTransport    MessageType = "transport"
 )
 
 type Message struct {
 	Type      MessageType     `json:"type"`
+	Version   int             `json:"version,omitempty"` // protocol version of the author
 	Payload   json.RawMessage `json:"payload,omitempty"`
 	Timestamp int64           `json:"timestamp"`
 	MessageID string          `json:"messageId,omitempty"`
@@ -42,6 +58,7 @@ func NewMessage(msgType MessageType, payload interface{}) (*Message, error) {
 
 	return &Message{
 		Type:      msgType,
+		Version:   ProtocolVersion,
 		Payload:   payloadBytes,
 		Timestamp: time.Now().UnixMilli(),
 	}, nil
@@ -56,19 +73,57 @@ func (m *Message) Bytes() ([]byte, error) {
 type RegisterPayload struct {
 	Role      string `json:"role"` // "sender" or "receiver"
 	SessionID string `json:"sessionId"`
+	Version   int    `json:"version"`
+	// Capabilities lists optional features the client supports; Require
+	// lists those it cannot work without.
+	Capabilities []string `json:"capabilities,omitempty"`
+	Require      []string `json:"require,omitempty"`
+	// LastMessageID is the last message ID a reconnecting client received;
+	// buffered messages after it are replayed.
+	LastMessageID string `json:"lastMessageId,omitempty"`
+	// Stream selects a data stream; zero is the control stream.
+	Stream int `json:"stream,omitempty"`
 }
 
 type RegisterAckPayload struct {
 	Success       bool `json:"success"`
 	PeerConnected bool `json:"peerConnected"`
+	// Version and Capabilities are what the server agreed to for this
+	// client; PeerCapabilities are the peer's, if it is connected.
+	Version          int      `json:"version"`
+	Capabilities     []string `json:"capabilities"`
+	PeerCapabilities []string `json:"peerCapabilities,omitempty"`
+	Stream           int      `json:"stream,omitempty"`
 }
 
 type PeerJoinedPayload struct {
-	Role string `json:"role"`
+	Role         string   `json:"role"`
+	Capabilities []string `json:"capabilities"`
+	Stream       int      `json:"stream,omitempty"`
+}
+
+// RecipientRejectedPayload tells the sender who was turned away. Identity
+// is the email or subject, empty for anonymous attempts.
+type RecipientRejectedPayload struct {
+	Identity string `json:"identity,omitempty"`
+	Reason   string `json:"reason"` // AUTH_REQUIRED or NOT_A_RECIPIENT
+}
+
+// SessionExpiredPayload carries when the session expired, in Unix
+// milliseconds.
+type SessionExpiredPayload struct {
+	ExpiredAt int64 `json:"expiredAt"`
 }
 
 type PeerLeftPayload struct {
-	Role string `json:"role"`
+	Role   string `json:"role"`
+	Stream int    `json:"stream,omitempty"`
+}
+
+type TransferRequestPayload struct {
+	// AcceptCompression lists the chunk compression algorithms the
+	// receiver can decode.
+	AcceptCompression []string `json:"acceptCompression,omitempty"`
 }
 
 type FileMetaPayload struct {
@@ -77,12 +132,20 @@ type FileMetaPayload struct {
 	MimeType    string `json:"mimeType"`
 	TotalChunks int    `json:"totalChunks"`
 	ChunkSize   int    `json:"chunkSize"`
+	// CompressionOptions is the sender's offer in order of preference;
+	// Compression is the algorithm the hub selected.
+	CompressionOptions []string `json:"compressionOptions,omitempty"`
+	Compression        string   `json:"compression,omitempty"`
+}
+
+type FileMetaAckPayload struct {
+	Compression string `json:"compression"`
 }
 
 type ChunkPayload struct {
 	Index int    `json:"index"`
-	Data  string `json:"data"` // Base64 encoded
-	Size  int    `json:"size"`
+	Data  string `json:"data"` // Base64 encoded, compressed if negotiated
+	Size  int    `json:"size"` // Uncompressed size
 }
 
 type ChunkAckPayload struct {
@@ -96,6 +159,31 @@ type TransferCompletePayload struct {
 	Duration    int64 `json:"duration"` // milliseconds
 }
 
+// SessionDescriptionPayload carries the SDP of a WebRTC offer or answer.
+type SessionDescriptionPayload struct {
+	SDP string `json:"sdp"`
+}
+
+// ICECandidatePayload mirrors the browser's RTCIceCandidateInit.
+type ICECandidatePayload struct {
+	Candidate        string  `json:"candidate"`
+	SDPMid           *string `json:"sdpMid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment *string `json:"usernameFragment,omitempty"`
+}
+
+// TransportPayload announces how the peers move file data: "direct" over a
+// WebRTC DataChannel or "relay" through the hub.
+type TransportPayload struct {
+	Mode string `json:"mode"`
+}
+
+// AckPayload acknowledges (ack) or requests retransmission of (nack) a
+// message stamped by the server.
+type AckPayload struct {
+	MessageID string `json:"messageId"`
+}
+
 type ErrorPayload struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"takedat/internal/auth"
+)
+
+// Protocol versions. Version 1 clients pick their role with the role query
+// parameter and skip the register handshake; later versions send a register
+// message carrying their version and capabilities.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// Capabilities a client may announce in its register message. A feature is
+// only used in a session when both peers negotiated it.
+const (
+	CapWebRTC      = "webrtc"      // offer, answer, ice_candidate and transport
+	CapCompression = "compression" // chunk compression negotiated in file_meta
+	CapReliable    = "reliable"    // message IDs, ack/nack and replay on reconnect
+)
+
+var serverCapabilities = []string{CapWebRTC, CapCompression, CapReliable}
+
+// negotiate checks a register payload against what the server supports and
+// returns the protocol version and capabilities both sides agree on.
+func negotiate(payload RegisterPayload) (int, []string, error) {
+	if payload.Version < MinProtocolVersion {
+		return 0, nil, fmt.Errorf("protocol version %d is no longer supported, minimum is %d", payload.Version, MinProtocolVersion)
+	}
+
+	for _, c := range payload.Require {
+		if !slices.Contains(serverCapabilities, c) {
+			return 0, nil, fmt.Errorf("required capability %q is not supported", c)
+		}
+	}
+
+	capabilities := []string{}
+	for _, c := range payload.Capabilities {
+		if slices.Contains(serverCapabilities, c) && !slices.Contains(capabilities, c) {
+			capabilities = append(capabilities, c)
+		}
+	}
+
+	return min(payload.Version, ProtocolVersion), capabilities, nil
+}
+
+// handleRegister completes the handshake for a client that connected
+// without a role query parameter. It returns false if the client was
+// refused and must be disconnected.
+func (c *Client) handleRegister(msg *Message) bool {
+	if msg.Type != TypeRegister {
+		c.sendError("NOT_REGISTERED", "First message must be register", true)
+		return false
+	}
+
+	var payload RegisterPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		c.sendError("INVALID_MESSAGE", "Invalid register payload", true)
+		return false
+	}
+
+	if payload.Role != "sender" && payload.Role != "receiver" {
+		c.sendError("INVALID_ROLE", "Role must be sender or receiver", true)
+		return false
+	}
+
+	if err := c.hub.authorize(c.principal, payload.Role); err != nil {
+		code := "AUTH_REQUIRED"
+		if errors.Is(err, auth.ErrInsufficientScope) {
+			code = "INSUFFICIENT_SCOPE"
+		}
+		c.sendError(code, err.Error(), true)
+		return false
+	}
+
+	if payload.Role == "sender" {
+		if sess, err := c.hub.sessions.GetByID(c.session); err == nil {
+			if err := c.hub.checkOwner(c.principal, sess); err != nil {
+				code := "NOT_OWNER"
+				if errors.Is(err, auth.ErrAuthRequired) {
+					code = "AUTH_REQUIRED"
+				}
+				c.sendError(code, err.Error(), true)
+				return false
+			}
+		}
+	}
+
+	if payload.Role == "receiver" {
+		if sess, err := c.hub.sessions.GetByID(c.session); err == nil {
+			if err := c.hub.CheckRecipient(c.principal, sess); err != nil {
+				code := "NOT_A_RECIPIENT"
+				if errors.Is(err, auth.ErrAuthRequired) {
+					code = "AUTH_REQUIRED"
+				}
+				c.sendError(code, err.Error(), true)
+				return false
+			}
+		}
+	}
+
+	if payload.SessionID != "" && payload.SessionID != c.session {
+		c.sendError("SESSION_MISMATCH", "Session ID does not match code", true)
+		return false
+	}
+
+	version, capabilities, err := negotiate(payload)
+	if err != nil {
+		c.sendError("INCOMPATIBLE_PROTOCOL", err.Error(), true)
+		return false
+	}
+
+	if payload.Stream != ControlStream {
+		if err := c.hub.validateStream(payload.Stream); err != nil {
+			c.sendError("INVALID_STREAM", err.Error(), true)
+			return false
+		}
+		c.stream = payload.Stream
+	}
+
+	if payload.LastMessageID != "" {
+		id, err := strconv.ParseUint(payload.LastMessageID, 10, 64)
+		if err != nil {
+			c.sendError("INVALID_MESSAGE", "Invalid last message ID", true)
+			return false
+		}
+		c.resumeFrom = id
+	}
+
+	c.role = payload.Role
+	c.version = version
+	c.capabilities = capabilities
+	c.registered = true
+	c.hub.register <- c
+
+	return true
+}
+
+// hasCapability reports whether c negotiated capability.
+func (c *Client) hasCapability(capability string) bool {
+	return slices.Contains(c.capabilities, capability)
+}
+
+// sharedCapability reports whether both c and its peer negotiated
+// capability.
+func sharedCapability(c, peer *Client, capability string) bool {
+	return c != nil && peer != nil && c.hasCapability(capability) && peer.hasCapability(capability)
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"takedat/internal/session"
+)
+
+// Stats is a snapshot of the hub's counters.
+type Stats struct {
+	// Sessions is the number of sessions with at least one connection or
+	// pending replay buffer.
+	Sessions int
+	// ActivePairs is the number of sessions with both control streams
+	// connected.
+	ActivePairs int
+	// PairsRejected counts connections refused for the concurrent pair
+	// limit.
+	PairsRejected uint64
+}
+
+type hubCounters struct {
+	pairsRejected atomic.Uint64
+}
+
+// admitPair reports whether client may complete a pair on the control
+// stream without exceeding the concurrent pair limit. Callers must hold
+// h.mu and sc.mu.
+func (h *Hub) admitPair(client *Client, sc *SessionClients) bool {
+	if client.stream != ControlStream || sc.client(oppositeRole(client.role), ControlStream) == nil {
+		return true
+	}
+	if h.maxPairs > 0 && h.activePairs >= h.maxPairs {
+		h.counters.pairsRejected.Add(1)
+		log.Printf("Pair limit reached: code=%s active=%d", client.code, h.activePairs)
+		return false
+	}
+	h.activePairs++
+	return true
+}
+
+// chargeChunk counts a sender's chunk against its IP's daily byte quota.
+// Once the quota is used up the transfer cannot continue, so the error is
+// fatal.
+func (h *Hub) chargeChunk(client *Client, msg *Message) bool {
+	err := h.sessions.ChargeRelay(client.ip, int64(len(msg.Payload)))
+	if errors.Is(err, session.ErrQuotaExceeded) {
+		client.sendError("QUOTA_EXCEEDED", "Daily transfer quota exceeded", true)
+		return false
+	}
+	return err == nil
+}
+
+// refundChunk returns a chunk's bytes to its sender's quota when the chunk
+// could not be relayed.
+func (h *Hub) refundChunk(client *Client, msg *Message) {
+	h.sessions.RefundRelay(client.ip, int64(len(msg.Payload)))
+}
+
+// Stats returns the hub's current counters.
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return Stats{
+		Sessions:      len(h.clients),
+		ActivePairs:   h.activePairs,
+		PairsRejected: h.counters.pairsRejected.Load(),
+	}
+}
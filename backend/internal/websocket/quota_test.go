@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"takedat/internal/session"
+)
+
+func TestHubChunkQuota(t *testing.T) {
+	h := newHubTest(t, Options{})
+	chunk := ChunkPayload{Index: 0, Data: "AAAA", Size: 3}
+	encoded, err := json.Marshal(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int64(len(encoded))
+	h.sessions.SetLimits(time.Minute, session.Quotas{MaxDailyBytesPerIP: 2 * n})
+
+	sess, sender, receiver := h.pair(t)
+
+	// Chunks that cannot be relayed are not charged.
+	receiver.conn.Close()
+	sender.expect(TypePeerLeft, nil)
+	for i := 0; i < 3; i++ {
+		sender.send(TypeChunk, chunk)
+		sender.expectError("PEER_DISCONNECTED")
+	}
+	sender.send(TypePing, nil)
+	sender.expect(TypePong, nil)
+	if got := h.sessions.Stats().BytesRelayedToday; got != 0 {
+		t.Errorf("after failed relays: %d bytes charged, want 0", got)
+	}
+
+	receiver, _ = h.join(t, sess, "receiver")
+	sender.expect(TypePeerJoined, nil)
+	for i := 0; i < 2; i++ {
+		sender.send(TypeChunk, chunk)
+		receiver.expect(TypeChunk, nil)
+	}
+	if got := h.sessions.Stats().BytesRelayedToday; got != 2*n {
+		t.Errorf("after relaying: %d bytes charged, want %d", got, 2*n)
+	}
+
+	sender.send(TypeChunk, chunk)
+	if payload := sender.expectError("QUOTA_EXCEEDED"); !payload.Fatal {
+		t.Error("QUOTA_EXCEEDED is not fatal")
+	}
+}
@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"log"
+	"takedat/internal/auth"
+	"takedat/internal/session"
+)
+
+// CheckRecipient enforces sess's recipient allowlist for p. Rejected
+// attempts are reported to the sender if it is connected. It returns
+// auth.ErrAuthRequired for anonymous callers and session.ErrNotRecipient
+// for callers not on the list.
+func (h *Hub) CheckRecipient(p auth.Principal, sess *session.Session) error {
+	if sess.AllowsRecipient(p.Subject(), p.Email()) {
+		return nil
+	}
+
+	err, reason := session.ErrNotRecipient, "NOT_A_RECIPIENT"
+	if p.Anonymous() {
+		err, reason = auth.ErrAuthRequired, "AUTH_REQUIRED"
+	}
+
+	identity := p.Email()
+	if identity == "" {
+		identity = p.Subject()
+	}
+	log.Printf("Recipient rejected: code=%s identity=%q reason=%s", sess.Code, identity, reason)
+	h.notifySender(sess.ID, TypeRecipientRejected, RecipientRejectedPayload{
+		Identity: identity,
+		Reason:   reason,
+	})
+
+	return err
+}
+
+// notifySender sends a message to the sender's control stream, if it is
+// connected.
+func (h *Hub) notifySender(sessionID string, msgType MessageType, payload interface{}) {
+	h.mu.RLock()
+	sc, exists := h.clients[sessionID]
+	h.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	if sender := sc.client("sender", ControlStream); sender != nil {
+		msg, _ := NewMessage(msgType, payload)
+		sender.Send(msg)
+	}
+}
@@ -0,0 +1,220 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// reliableTypes are control messages that must survive a reconnect. Chunks
+// are not included; they have their own chunk_ack flow.
+var reliableTypes = map[MessageType]bool{
+	TypeTransferRequest:  true,
+	TypeTransferAccept:   true,
+	TypeFileMeta:         true,
+	TypeFileMetaAck:      true,
+	TypeTransferComplete: true,
+}
+
+// replayBuffer holds reliable messages sent to one side of a session until
+// that side acknowledges them. It belongs to the role rather than the
+// connection so that a reconnecting client picks up where the old socket
+// left off.
+type replayBuffer struct {
+	limit   int
+	nextID  func() uint64
+	dropped uint64           // highest ID evicted before it was acknowledged
+	pending []pendingMessage // ordered by id
+	mu      sync.Mutex
+}
+
+type pendingMessage struct {
+	id   uint64
+	data []byte
+}
+
+// stamp assigns msg the next message ID, encodes it and keeps it until
+// acknowledged, dropping the oldest message if the buffer is full.
+func (b *replayBuffer) stamp(msg *Message) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID()
+	stamped := *msg
+	stamped.MessageID = strconv.FormatUint(id, 10)
+
+	data, err := stamped.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b.pending) >= b.limit {
+		log.Printf("Replay buffer full, dropping message %d", b.pending[0].id)
+		b.dropped = b.pending[0].id
+		b.pending = b.pending[1:]
+	}
+	b.pending = append(b.pending, pendingMessage{id: id, data: data})
+
+	return data, nil
+}
+
+// ack drops every pending message up to and including id.
+func (b *replayBuffer) ack(id uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := 0
+	for i < len(b.pending) && b.pending[i].id <= id {
+		i++
+	}
+	b.pending = b.pending[i:]
+}
+
+// since returns the encoded pending messages from id onwards. ok is false if
+// some of them were evicted before being acknowledged.
+func (b *replayBuffer) since(id uint64) (messages [][]byte, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, p := range b.pending {
+		if p.id >= id {
+			messages = append(messages, p.data)
+		}
+	}
+	return messages, id > b.dropped
+}
+
+func (b *replayBuffer) empty() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending) == 0
+}
+
+// replayFor returns the replay buffer for role, creating it if create is
+// set. Message IDs come from the session, so a buffer created after the
+// previous one was cleaned up carries on where it left off. Callers must
+// hold sc.mu for writing when create is set.
+func (sc *SessionClients) replayFor(role string, create bool) *replayBuffer {
+	buf := sc.replay[role]
+	if buf == nil && create {
+		if sc.replay == nil {
+			sc.replay = make(map[string]*replayBuffer)
+		}
+		sess := sc.session
+		buf = &replayBuffer{
+			limit:  sc.replayLimit,
+			nextID: func() uint64 { return sess.NextMessageID(role) },
+		}
+		sc.replay[role] = buf
+	}
+	return buf
+}
+
+// hasPending reports whether any side still has unacknowledged messages.
+// Callers must hold sc.mu.
+func (sc *SessionClients) hasPending() bool {
+	for _, buf := range sc.replay {
+		if !buf.empty() {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver sends msg to the client in role. Reliable messages to clients
+// that negotiated the reliable capability are stamped with an ID and
+// buffered; if that client is briefly disconnected they are only buffered
+// and replayed when it comes back. Callers must hold sc.mu.
+func (h *Hub) deliver(sc *SessionClients, role string, to *Client, msg *Message) bool {
+	buf := sc.replayFor(role, false)
+	if !reliableTypes[msg.Type] || buf == nil || (to != nil && !to.hasCapability(CapReliable)) {
+		if to == nil {
+			return false
+		}
+		bytes, err := msg.Bytes()
+		if err != nil {
+			return false
+		}
+		return to.sendBytes(bytes)
+	}
+
+	bytes, err := buf.stamp(msg)
+	if err != nil {
+		return false
+	}
+	if to == nil {
+		return true // replayed on reconnect
+	}
+	to.sendBytes(bytes)
+	return true
+}
+
+// replay resends the messages buffered for client's role that it has not
+// seen, starting after lastID. Callers must hold sc.mu.
+func (h *Hub) replay(sc *SessionClients, client *Client, lastID uint64) {
+	buf := sc.replayFor(client.role, true)
+	buf.ack(lastID)
+
+	messages, _ := buf.since(lastID + 1)
+	for _, data := range messages {
+		client.sendBytes(data)
+	}
+	if len(messages) > 0 {
+		log.Printf("Replayed %d messages: code=%s role=%s", len(messages), client.code, client.role)
+	}
+}
+
+// handleAck processes ack and nack messages from client. An ack confirms
+// every message up to its ID; a nack asks for everything from its ID on to
+// be sent again.
+func (h *Hub) handleAck(client *Client, sc *SessionClients, msg *Message) {
+	var payload AckPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		client.sendError("INVALID_MESSAGE", "Invalid ack payload", false)
+		return
+	}
+	id, err := strconv.ParseUint(payload.MessageID, 10, 64)
+	if err != nil {
+		client.sendError("INVALID_MESSAGE", "Invalid message ID", false)
+		return
+	}
+
+	buf := sc.replayFor(client.role, false)
+	if buf == nil {
+		client.sendError("UNSUPPORTED", "Reliable delivery was not negotiated", false)
+		return
+	}
+
+	if msg.Type == TypeAck {
+		buf.ack(id)
+		return
+	}
+
+	messages, ok := buf.since(id)
+	if !ok {
+		client.sendError("REPLAY_UNAVAILABLE", "Message is no longer buffered", false)
+	}
+	for _, data := range messages {
+		client.sendBytes(data)
+	}
+}
+
+// scheduleCleanup removes a session's clients entry once the replay grace
+// period has passed, giving clients time to reconnect, unless someone
+// reconnected in the meantime.
+func (h *Hub) scheduleCleanup(sessionID string, sc *SessionClients) {
+	time.AfterFunc(h.opts.ReplayGrace, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		sc.mu.RLock()
+		empty := sc.empty()
+		sc.mu.RUnlock()
+
+		if empty && h.clients[sessionID] == sc {
+			delete(h.clients, sessionID)
+		}
+	})
+}
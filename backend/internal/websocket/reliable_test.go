@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"takedat/internal/session"
+)
+
+// joinReliable connects as role with reliable delivery, resuming after
+// lastID if it is set.
+func (h *hubTest) joinReliable(t *testing.T, sess *session.Session, role, lastID string) *testPeer {
+	t.Helper()
+
+	p, ack := h.register(t, sess, RegisterPayload{
+		Role:          role,
+		SessionID:     sess.ID,
+		Version:       ProtocolVersion,
+		Capabilities:  []string{CapReliable},
+		LastMessageID: lastID,
+	})
+	if len(ack.Capabilities) != 1 || ack.Capabilities[0] != CapReliable {
+		t.Fatalf("%s: capabilities = %v", role, ack.Capabilities)
+	}
+	return p
+}
+
+// pairReliable connects a sender and a receiver with reliable delivery.
+func (h *hubTest) pairReliable(t *testing.T) (*session.Session, *testPeer, *testPeer) {
+	t.Helper()
+
+	sess := h.createSession(t)
+	sender := h.joinReliable(t, sess, "sender", "")
+	receiver := h.joinReliable(t, sess, "receiver", "")
+	sender.expect(TypePeerJoined, nil)
+	return sess, sender, receiver
+}
+
+// expectID reads a message of type msgType and checks its message ID.
+func (p *testPeer) expectID(msgType MessageType, id string) {
+	p.t.Helper()
+
+	msg := p.read()
+	if msg.Type != msgType || msg.MessageID != id {
+		p.t.Fatalf("%s: got %s #%s, want %s #%s", p.role, msg.Type, msg.MessageID, msgType, id)
+	}
+}
+
+// waitGone waits for the hub to forget a session's connections.
+func (h *hubTest) waitGone(t *testing.T, sess *session.Session) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, exists := h.hub.GetSessionClients(sess.ID); !exists {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session clients still present")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubReliableStamp(t *testing.T) {
+	h := newHubTest(t, Options{})
+	_, sender, receiver := h.pairReliable(t)
+
+	// Each side has its own sequence of IDs.
+	receiver.send(TypeTransferRequest, TransferRequestPayload{})
+	sender.expectID(TypeTransferRequest, "1")
+	sender.send(TypeFileMeta, FileMetaPayload{FileName: "a.bin", FileSize: 1, TotalChunks: 1, ChunkSize: 1})
+	receiver.expectID(TypeFileMeta, "1")
+	sender.expectID(TypeFileMetaAck, "2")
+
+	// Chunks and unreliable messages carry no ID.
+	sender.send(TypeChunk, ChunkPayload{Index: 0, Data: "AA==", Size: 1})
+	receiver.expectID(TypeChunk, "")
+	sender.send(TypePing, nil)
+	sender.expectID(TypePong, "")
+
+	sender.send(TypeTransferComplete, TransferCompletePayload{TotalBytes: 1, TotalChunks: 1})
+	receiver.expectID(TypeTransferComplete, "2")
+}
+
+func TestHubReliableReplay(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess, sender, receiver := h.pairReliable(t)
+
+	sender.send(TypeTransferComplete, TransferCompletePayload{})
+	receiver.expectID(TypeTransferComplete, "1")
+	receiver.conn.Close()
+	sender.expect(TypePeerLeft, nil)
+
+	// Messages sent while the receiver is away are buffered, not refused.
+	sender.send(TypeTransferComplete, TransferCompletePayload{})
+	sender.send(TypeTransferComplete, TransferCompletePayload{})
+
+	// The receiver saw message 1, so 2 and 3 are replayed after the
+	// register acknowledgement.
+	receiver = h.joinReliable(t, sess, "receiver", "1")
+	receiver.expectID(TypeTransferComplete, "2")
+	receiver.expectID(TypeTransferComplete, "3")
+	sender.expect(TypePeerJoined, nil)
+
+	// A client without the capability gets neither IDs nor replays.
+	receiver.conn.Close()
+	sender.expect(TypePeerLeft, nil)
+	sender.send(TypeTransferComplete, TransferCompletePayload{})
+	receiver, _ = h.join(t, sess, "receiver")
+	sender.expect(TypePeerJoined, nil)
+	sender.send(TypeTransferComplete, TransferCompletePayload{})
+	receiver.expectID(TypeTransferComplete, "")
+}
+
+func TestHubReliableAckNack(t *testing.T) {
+	h := newHubTest(t, Options{})
+	_, sender, receiver := h.pairReliable(t)
+
+	for _, id := range []string{"1", "2", "3"} {
+		sender.send(TypeTransferComplete, TransferCompletePayload{})
+		receiver.expectID(TypeTransferComplete, id)
+	}
+
+	// A nack resends everything from its ID on.
+	receiver.send(TypeNack, AckPayload{MessageID: "2"})
+	receiver.expectID(TypeTransferComplete, "2")
+	receiver.expectID(TypeTransferComplete, "3")
+
+	// Acknowledged messages are no longer resent.
+	receiver.send(TypeAck, AckPayload{MessageID: "2"})
+	receiver.send(TypeNack, AckPayload{MessageID: "1"})
+	receiver.expectID(TypeTransferComplete, "3")
+
+	receiver.send(TypeAck, AckPayload{MessageID: "x"})
+	receiver.expectError("INVALID_MESSAGE")
+}
+
+func TestHubReliableOverflow(t *testing.T) {
+	h := newHubTest(t, Options{ReplayBuffer: 2})
+	_, sender, receiver := h.pairReliable(t)
+
+	for _, id := range []string{"1", "2", "3"} {
+		sender.send(TypeTransferComplete, TransferCompletePayload{})
+		receiver.expectID(TypeTransferComplete, id)
+	}
+
+	// Message 1 was evicted to make room for 3: the client hears that the
+	// replay is incomplete and gets what is left.
+	receiver.send(TypeNack, AckPayload{MessageID: "1"})
+	if payload := receiver.expectError("REPLAY_UNAVAILABLE"); payload.Fatal {
+		t.Error("REPLAY_UNAVAILABLE is fatal")
+	}
+	receiver.expectID(TypeTransferComplete, "2")
+	receiver.expectID(TypeTransferComplete, "3")
+}
+
+// TestHubReliableIDsSurviveCleanup checks that message IDs keep increasing
+// after both peers leave with nothing pending and the hub forgets the
+// session's connections, so clients that drop IDs they have already seen
+// do not discard new messages.
+func TestHubReliableIDsSurviveCleanup(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess, sender, receiver := h.pairReliable(t)
+
+	receiver.send(TypeTransferRequest, TransferRequestPayload{})
+	sender.expectID(TypeTransferRequest, "1")
+	sender.send(TypeTransferComplete, TransferCompletePayload{})
+	receiver.expectID(TypeTransferComplete, "1")
+	sender.send(TypeAck, AckPayload{MessageID: "1"})
+	receiver.send(TypeAck, AckPayload{MessageID: "1"})
+
+	receiver.conn.Close()
+	sender.expect(TypePeerLeft, nil)
+	sender.conn.Close()
+	h.waitGone(t, sess)
+
+	sender = h.joinReliable(t, sess, "sender", "1")
+	receiver = h.joinReliable(t, sess, "receiver", "1")
+	sender.expect(TypePeerJoined, nil)
+
+	receiver.send(TypeTransferRequest, TransferRequestPayload{})
+	sender.expectID(TypeTransferRequest, "2")
+	sender.send(TypeTransferComplete, TransferCompletePayload{})
+	receiver.expectID(TypeTransferComplete, "2")
+}
@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"encoding/json"
+	"takedat/internal/session"
+)
+
+// maxSDPSize bounds session descriptions relayed for WebRTC signaling.
+const maxSDPSize = 64 * 1024
+
+// handleSignal validates a WebRTC signaling message and relays it to the
+// peer. The hub never interprets SDP or candidates beyond basic sanity
+// checks; the peers negotiate a direct DataChannel between themselves.
+func (h *Hub) handleSignal(client *Client, sc *SessionClients, msg *Message) {
+	if !client.hasCapability(CapWebRTC) {
+		client.sendError("UNSUPPORTED", "WebRTC signaling was not negotiated", false)
+		return
+	}
+	if peer := sc.peerOf(client); peer != nil && !peer.hasCapability(CapWebRTC) {
+		client.sendError("UNSUPPORTED", "Peer does not support WebRTC", false)
+		return
+	}
+
+	switch msg.Type {
+	case TypeOffer, TypeAnswer:
+		var payload SessionDescriptionPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.SDP == "" {
+			client.sendError("INVALID_SIGNAL", "Session description is required", false)
+			return
+		}
+		if len(payload.SDP) > maxSDPSize {
+			client.sendError("INVALID_SIGNAL", "Session description too large", false)
+			return
+		}
+
+	case TypeICECandidate:
+		// An empty candidate signals the end of gathering and is relayed as is.
+		var payload ICECandidatePayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			client.sendError("INVALID_SIGNAL", "Invalid ICE candidate", false)
+			return
+		}
+
+	case TypeTransport:
+		var payload TransportPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			client.sendError("INVALID_SIGNAL", "Invalid transport", false)
+			return
+		}
+		transport := session.Transport(payload.Mode)
+		if transport != session.TransportDirect && transport != session.TransportRelay {
+			client.sendError("INVALID_SIGNAL", "Transport must be direct or relay", false)
+			return
+		}
+		if !h.relayToPeer(client, sc, msg) {
+			return
+		}
+		if sess, err := h.sessions.GetByID(client.session); err == nil {
+			sess.SetTransport(transport)
+		}
+		return
+	}
+
+	h.relayToPeer(client, sc, msg)
+}
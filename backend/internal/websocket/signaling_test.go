@@ -0,0 +1,230 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"takedat/internal/session"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// rtcPeer is a WebRTC endpoint that signals through the hub. Socket writes
+// and peer connection calls happen on the test goroutine; pion's callbacks
+// and the socket reader only feed the channels.
+type rtcPeer struct {
+	ws         *testPeer
+	pc         *webrtc.PeerConnection
+	candidates chan *webrtc.ICECandidate // nil marks the end of gathering
+	messages   chan *Message
+}
+
+func newRTCPeer(t *testing.T, ws *testPeer) *rtcPeer {
+	t.Helper()
+
+	// Keep candidates on the loopback interface so the peers connect
+	// without a network or STUN server.
+	var se webrtc.SettingEngine
+	se.SetIncludeLoopbackCandidate(true)
+	se.SetIPFilter(func(ip net.IP) bool { return ip.IsLoopback() })
+	se.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+
+	pc, err := webrtc.NewAPI(webrtc.WithSettingEngine(se)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	p := &rtcPeer{
+		ws:         ws,
+		pc:         pc,
+		candidates: make(chan *webrtc.ICECandidate, 16),
+		messages:   make(chan *Message, 16),
+	}
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) { p.candidates <- c })
+
+	go func() {
+		defer close(p.messages)
+		for {
+			_, data, err := ws.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Errorf("%s: decode %q: %v", ws.role, data, err)
+				return
+			}
+			p.messages <- &msg
+		}
+	}()
+	return p
+}
+
+// sendCandidate relays a local candidate, or the end of gathering if c is
+// nil.
+func (p *rtcPeer) sendCandidate(c *webrtc.ICECandidate) {
+	var payload ICECandidatePayload
+	if c != nil {
+		init := c.ToJSON()
+		payload = ICECandidatePayload{
+			Candidate:        init.Candidate,
+			SDPMid:           init.SDPMid,
+			SDPMLineIndex:    init.SDPMLineIndex,
+			UsernameFragment: init.UsernameFragment,
+		}
+	}
+	p.ws.send(TypeICECandidate, payload)
+}
+
+// sendDescription sets a new offer or answer as the local description and
+// relays it.
+func (p *rtcPeer) sendDescription(t *testing.T, msgType MessageType) {
+	t.Helper()
+
+	var desc webrtc.SessionDescription
+	var err error
+	if msgType == TypeAnswer {
+		desc, err = p.pc.CreateAnswer(nil)
+	} else {
+		desc, err = p.pc.CreateOffer(nil)
+	}
+	if err != nil {
+		t.Fatalf("%s: create %s: %v", p.ws.role, msgType, err)
+	}
+	if err := p.pc.SetLocalDescription(desc); err != nil {
+		t.Fatalf("%s: set local %s: %v", p.ws.role, msgType, err)
+	}
+	p.ws.send(msgType, SessionDescriptionPayload{SDP: desc.SDP})
+}
+
+// handle applies a signaling message relayed from the other peer, and
+// returns the message if it is not part of the WebRTC handshake.
+func (p *rtcPeer) handle(t *testing.T, msg *Message) *Message {
+	t.Helper()
+
+	switch msg.Type {
+	case TypeOffer, TypeAnswer:
+		var payload SessionDescriptionPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		sdpType := webrtc.SDPTypeOffer
+		if msg.Type == TypeAnswer {
+			sdpType = webrtc.SDPTypeAnswer
+		}
+		if err := p.pc.SetRemoteDescription(webrtc.SessionDescription{Type: sdpType, SDP: payload.SDP}); err != nil {
+			t.Fatalf("%s: set remote %s: %v", p.ws.role, msg.Type, err)
+		}
+		if msg.Type == TypeOffer {
+			p.sendDescription(t, TypeAnswer)
+		}
+
+	case TypeICECandidate:
+		var payload ICECandidatePayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Candidate == "" {
+			return nil
+		}
+		err := p.pc.AddICECandidate(webrtc.ICECandidateInit{
+			Candidate:        payload.Candidate,
+			SDPMid:           payload.SDPMid,
+			SDPMLineIndex:    payload.SDPMLineIndex,
+			UsernameFragment: payload.UsernameFragment,
+		})
+		if err != nil {
+			t.Fatalf("%s: add candidate %q: %v", p.ws.role, payload.Candidate, err)
+		}
+
+	default:
+		return msg
+	}
+	return nil
+}
+
+func TestHubWebRTC(t *testing.T) {
+	h := newHubTest(t, Options{})
+	sess := h.createSession(t)
+
+	caps := []string{CapWebRTC}
+	senderWS, _ := h.register(t, sess, RegisterPayload{Role: "sender", SessionID: sess.ID, Version: ProtocolVersion, Capabilities: caps})
+	receiverWS, ack := h.register(t, sess, RegisterPayload{Role: "receiver", SessionID: sess.ID, Version: ProtocolVersion, Capabilities: caps})
+	if len(ack.PeerCapabilities) != 1 || ack.PeerCapabilities[0] != CapWebRTC {
+		t.Fatalf("receiver: peer capabilities = %v", ack.PeerCapabilities)
+	}
+	var joined PeerJoinedPayload
+	senderWS.expect(TypePeerJoined, &joined)
+
+	sender := newRTCPeer(t, senderWS)
+	receiver := newRTCPeer(t, receiverWS)
+
+	received := make(chan string, 1)
+	receiver.pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) { received <- string(msg.Data) })
+	})
+	dc, err := sender.pc.CreateDataChannel("file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.OnOpen(func() { dc.SendText("chunk 0") })
+
+	sender.sendDescription(t, TypeOffer)
+
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case c := <-sender.candidates:
+			sender.sendCandidate(c)
+		case c := <-receiver.candidates:
+			receiver.sendCandidate(c)
+		case msg, ok := <-sender.messages:
+			if !ok {
+				t.Fatal("sender: connection closed")
+			}
+			if msg = sender.handle(t, msg); msg != nil {
+				t.Fatalf("sender: unexpected %s %s", msg.Type, msg.Payload)
+			}
+		case msg, ok := <-receiver.messages:
+			if !ok {
+				t.Fatal("receiver: connection closed")
+			}
+			if msg = receiver.handle(t, msg); msg != nil {
+				t.Fatalf("receiver: unexpected %s %s", msg.Type, msg.Payload)
+			}
+		case data := <-received:
+			if data != "chunk 0" {
+				t.Errorf("data channel message = %q", data)
+			}
+			done = true
+		case <-timeout:
+			t.Fatalf("no data channel after signaling: sender %s, receiver %s",
+				sender.pc.ICEConnectionState(), receiver.pc.ICEConnectionState())
+		}
+	}
+
+	// Once the direct path works the sender moves the transfer off the
+	// relay.
+	senderWS.send(TypeTransport, TransportPayload{Mode: string(session.TransportDirect)})
+	for msg := range receiver.messages {
+		if msg = receiver.handle(t, msg); msg == nil {
+			// Candidates still trickling in.
+			continue
+		}
+		if msg.Type != TypeTransport {
+			t.Fatalf("receiver: got %s, want %s", msg.Type, TypeTransport)
+		}
+		break
+	}
+	// The hub records the transport after relaying it.
+	for sess.GetTransport() != session.TransportDirect {
+		select {
+		case <-timeout:
+			t.Fatalf("session transport = %s, want %s", sess.GetTransport(), session.TransportDirect)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package websocket
+
+import "fmt"
+
+// ControlStream is the stream every client opens first. It carries the
+// handshake and all control messages; higher streams only carry chunks and
+// chunk acknowledgements so transfers can be striped across connections.
+const ControlStream = 0
+
+// dataStreamTypes are the messages allowed on streams other than the
+// control stream.
+var dataStreamTypes = map[MessageType]bool{
+	TypeChunk:    true,
+	TypeChunkAck: true,
+	TypePing:     true,
+}
+
+// streamPair is the sender and receiver connection for one stream of a
+// session. The hub relays messages between the two ends of the same stream.
+type streamPair struct {
+	sender   *Client
+	receiver *Client
+}
+
+func (p *streamPair) get(role string) *Client {
+	if role == "sender" {
+		return p.sender
+	}
+	return p.receiver
+}
+
+func (p *streamPair) set(role string, client *Client) {
+	if role == "sender" {
+		p.sender = client
+	} else {
+		p.receiver = client
+	}
+}
+
+// client returns the connection for role on stream, if any. Callers must
+// hold sc.mu.
+func (sc *SessionClients) client(role string, stream int) *Client {
+	if p := sc.streams[stream]; p != nil {
+		return p.get(role)
+	}
+	return nil
+}
+
+// pair returns the pair for stream, creating it if needed. Callers must hold
+// sc.mu for writing.
+func (sc *SessionClients) pair(stream int) *streamPair {
+	if sc.streams == nil {
+		sc.streams = make(map[int]*streamPair)
+	}
+	p := sc.streams[stream]
+	if p == nil {
+		p = &streamPair{}
+		sc.streams[stream] = p
+	}
+	return p
+}
+
+// each calls fn for every connection of role across all streams. Callers
+// must hold sc.mu.
+func (sc *SessionClients) each(role string, fn func(*Client)) {
+	for _, p := range sc.streams {
+		if c := p.get(role); c != nil {
+			fn(c)
+		}
+	}
+}
+
+// empty reports whether no connections are left. Callers must hold sc.mu.
+func (sc *SessionClients) empty() bool {
+	for _, p := range sc.streams {
+		if p.sender != nil || p.receiver != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// validateStream checks a requested stream number against the hub's limit.
+func (h *Hub) validateStream(stream int) error {
+	if stream < 0 || stream >= h.maxStreams {
+		return fmt.Errorf("stream must be between 0 and %d", h.maxStreams-1)
+	}
+	return nil
+}
+
+func streamSuffix(stream int) string {
+	if stream == ControlStream {
+		return ""
+	}
+	return fmt.Sprintf(" on stream %d", stream)
+}
+
+func oppositeRole(role string) string {
+	if role == "sender" {
+		return "receiver"
+	}
+	return "sender"
+}
@@ -0,0 +1,207 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ipLimiterIdle is how long an idle per-IP limiter is kept before pruning.
+const ipLimiterIdle = 10 * time.Minute
+
+// RateLimits configures relay bandwidth in bytes per second. Zero means
+// unlimited.
+type RateLimits struct {
+	Global  int64
+	Session int64
+	IP      int64
+}
+
+// throttle holds the token buckets chunks pass through before being
+// relayed. Waiting on a bucket blocks the sender's read loop, which pushes
+// back on the sender through TCP flow control instead of dropping chunks.
+// Limits can change while chunks are waiting; buckets are adjusted in place
+// so waiters pick up the new rate.
+//
+// Per-IP buckets are keyed by the client address the realip middleware
+// resolved, so forwarding headers only count from trusted proxies.
+type throttle struct {
+	limits RateLimits
+	global *rate.Limiter
+	ips    map[string]*ipLimiter
+	mu     sync.Mutex
+
+	// now and sleep are the clock buckets are filled and waited on by.
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func newThrottle(limits RateLimits) *throttle {
+	return &throttle{
+		limits: limits,
+		global: newLimiter(limits.Global),
+		ips:    make(map[string]*ipLimiter),
+		now:    time.Now,
+		sleep:  sleepContext,
+	}
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newLimiter returns a token bucket for bytesPerSec. The burst allows one
+// second worth of traffic.
+func newLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))
+}
+
+// setLimit changes l to bytesPerSec, or to unlimited if zero.
+func setLimit(l *rate.Limiter, bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		l.SetLimit(rate.Inf)
+		return
+	}
+	l.SetBurst(int(bytesPerSec))
+	l.SetLimit(rate.Limit(bytesPerSec))
+}
+
+// sessionLimit is the lower of the server's per-session limit and the
+// owner's cap, zero if neither is set.
+func sessionLimit(serverLimit, ownerCap int64) int64 {
+	if ownerCap > 0 && (serverLimit <= 0 || ownerCap < serverLimit) {
+		return ownerCap
+	}
+	return serverLimit
+}
+
+// ipLimiter returns the limiter for ip, or nil if per-IP limits are off.
+func (t *throttle) ipLimiter(ip string) *rate.Limiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.limits.IP <= 0 {
+		return nil
+	}
+
+	l, ok := t.ips[ip]
+	if !ok {
+		l = &ipLimiter{limiter: newLimiter(t.limits.IP)}
+		t.ips[ip] = l
+	}
+	l.lastUsed = t.now()
+	return l.limiter
+}
+
+// prune drops per-IP limiters that have not been used for a while.
+func (t *throttle) prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ip, l := range t.ips {
+		if t.now().Sub(l.lastUsed) > ipLimiterIdle {
+			delete(t.ips, ip)
+		}
+	}
+}
+
+// setLimits applies new limits to the global and per-IP buckets. Session
+// buckets are updated by Hub.SetLimits.
+func (t *throttle) setLimits(limits RateLimits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.limits = limits
+	setLimit(t.global, limits.Global)
+	for _, l := range t.ips {
+		setLimit(l.limiter, limits.IP)
+	}
+}
+
+// sessionLimiter returns the session's limiter, creating it on first use
+// from the server limit and the owner's cap, whichever is lower. Callers
+// must not hold sc.mu.
+func (t *throttle) sessionLimiter(sc *SessionClients, ownerCap int64) *rate.Limiter {
+	sc.limiterOnce.Do(func() {
+		t.mu.Lock()
+		limit := t.limits.Session
+		t.mu.Unlock()
+		sc.limiter = newLimiter(sessionLimit(limit, ownerCap))
+	})
+	return sc.limiter
+}
+
+// wait blocks until n bytes may pass every limiter, or ctx is done, and
+// returns how long it waited.
+func (t *throttle) wait(ctx context.Context, n int, limiters ...*rate.Limiter) (time.Duration, error) {
+	var waited time.Duration
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		// Reservations above the burst fail, so take large messages in
+		// burst-sized pieces.
+		for remaining := n; remaining > 0 && l.Limit() != rate.Inf; {
+			take := min(remaining, l.Burst())
+			now := t.now()
+			r := l.ReserveN(now, take)
+			if !r.OK() {
+				// The burst was lowered below take; retry with the new one.
+				continue
+			}
+			if d := r.DelayFrom(now); d > 0 {
+				if err := t.sleep(ctx, d); err != nil {
+					r.CancelAt(t.now())
+					return waited, err
+				}
+				waited += d
+			}
+			remaining -= take
+		}
+	}
+	return waited, nil
+}
+
+// throttleChunk applies backpressure to a sender before one of its chunks
+// is relayed. It returns false if the client went away while waiting.
+func (h *Hub) throttleChunk(client *Client, sc *SessionClients, msg *Message) bool {
+	var ownerCap int64
+	if sess, err := h.sessions.GetByID(client.session); err == nil {
+		ownerCap = sess.SpeedLimit
+	}
+
+	waited, err := h.throttle.wait(client.ctx, len(msg.Payload),
+		h.throttle.global,
+		h.throttle.sessionLimiter(sc, ownerCap),
+		h.throttle.ipLimiter(client.ip),
+	)
+	if err != nil {
+		return false
+	}
+	if waited > 0 {
+		// Pongs are only read between messages, so time spent throttled
+		// must not count against the pong deadline. This runs on the
+		// client's read loop, which owns the deadline.
+		client.conn.SetReadDeadline(time.Now().Add(h.opts.PongWait))
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package websocket
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// newTestThrottle returns a throttle whose sleeps move clock forward
+// instead of blocking.
+func newTestThrottle(limits RateLimits) (*throttle, *testClock) {
+	clock := &testClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	t := newThrottle(limits)
+	t.now = clock.Now
+	t.sleep = func(ctx context.Context, d time.Duration) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		clock.Advance(d)
+		return nil
+	}
+	return t, clock
+}
+
+func TestThrottleWait(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int64
+		sizes  []int           // messages sent back to back
+		waited []time.Duration // per message
+	}{
+		{"unlimited", 0, []int{1 << 20, 1 << 20}, []time.Duration{0, 0}},
+		// The burst lets one second worth of bytes through at once.
+		{"within burst", 1000, []int{400, 600}, []time.Duration{0, 0}},
+		{"after burst", 1000, []int{1000, 500, 250}, []time.Duration{0, 500 * time.Millisecond, 250 * time.Millisecond}},
+		// Messages larger than the burst go through in burst-sized
+		// pieces.
+		{"above burst", 1000, []int{3500}, []time.Duration{2500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		th, _ := newTestThrottle(RateLimits{Global: tt.limit})
+		for i, size := range tt.sizes {
+			waited, err := th.wait(context.Background(), size, th.global)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if waited != tt.waited[i] {
+				t.Errorf("%s: message %d waited %s, want %s", tt.name, i, waited, tt.waited[i])
+			}
+		}
+	}
+}
+
+func TestThrottleRefill(t *testing.T) {
+	th, clock := newTestThrottle(RateLimits{Global: 1000})
+
+	if waited, _ := th.wait(context.Background(), 1000, th.global); waited != 0 {
+		t.Errorf("first burst waited %s", waited)
+	}
+	// Idle time refills the bucket up to the burst, not beyond.
+	clock.Advance(10 * time.Second)
+	if waited, _ := th.wait(context.Background(), 1000, th.global); waited != 0 {
+		t.Errorf("after refill waited %s", waited)
+	}
+	if waited, _ := th.wait(context.Background(), 100, th.global); waited != 100*time.Millisecond {
+		t.Errorf("after draining the refill waited %s, want 100ms", waited)
+	}
+}
+
+func TestThrottleLimiters(t *testing.T) {
+	th, _ := newTestThrottle(RateLimits{Global: 4000, IP: 1000})
+	session := newLimiter(sessionLimit(2000, 500))
+
+	// Every limiter must let the bytes pass; the slowest one decides.
+	waited, err := th.wait(context.Background(), 1500, th.global, session, th.ipLimiter("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2*time.Second + 500*time.Millisecond; waited != want {
+		t.Errorf("waited %s, want %s", waited, want)
+	}
+
+	// Each IP has its own bucket.
+	if th.ipLimiter("192.0.2.1") != th.ipLimiter("192.0.2.1") || th.ipLimiter("192.0.2.1") == th.ipLimiter("192.0.2.2") {
+		t.Error("per-IP limiters not keyed by IP")
+	}
+}
+
+func TestThrottleSetLimits(t *testing.T) {
+	th, _ := newTestThrottle(RateLimits{Global: 1000, IP: 1000})
+	ip := th.ipLimiter("192.0.2.1")
+
+	th.setLimits(RateLimits{Global: 2000, IP: 0})
+	if th.global.Limit() != 2000 || th.global.Burst() != 2000 {
+		t.Errorf("global limiter = %v/%d, want 2000/2000", th.global.Limit(), th.global.Burst())
+	}
+	if ip.Limit() != rate.Inf {
+		t.Errorf("per-IP limiter = %v, want unlimited", ip.Limit())
+	}
+	if th.ipLimiter("192.0.2.2") != nil {
+		t.Error("per-IP limiter created with per-IP limits off")
+	}
+}
+
+func TestThrottlePrune(t *testing.T) {
+	th, clock := newTestThrottle(RateLimits{IP: 1000})
+	th.ipLimiter("192.0.2.1")
+	clock.Advance(ipLimiterIdle / 2)
+	th.ipLimiter("192.0.2.2")
+	clock.Advance(ipLimiterIdle/2 + time.Second)
+
+	th.prune()
+	if _, ok := th.ips["192.0.2.1"]; ok {
+		t.Error("idle limiter kept")
+	}
+	if _, ok := th.ips["192.0.2.2"]; !ok {
+		t.Error("recently used limiter pruned")
+	}
+}
+
+func TestThrottleCanceled(t *testing.T) {
+	th, _ := newTestThrottle(RateLimits{Global: 1000})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := th.wait(ctx, 500, th.global); err != nil {
+		t.Errorf("wait within the burst: %v", err)
+	}
+	if _, err := th.wait(ctx, 1000, th.global); err == nil {
+		t.Error("wait on a canceled context succeeded")
+	}
+	// The canceled reservation is returned to the bucket.
+	if waited, err := th.wait(context.Background(), 500, th.global); err != nil || waited != 0 {
+		t.Errorf("wait after cancel: waited %s, %v", waited, err)
+	}
+}
+
+// TestHubThrottledPong checks that a sender held back by the rate limit for
+// longer than the pong deadline is not dropped.
+func TestHubThrottledPong(t *testing.T) {
+	h := newHubTest(t, Options{
+		PongWait:   300 * time.Millisecond,
+		PingPeriod: 100 * time.Millisecond,
+		RateLimits: RateLimits{Session: 1000},
+	})
+	_, sender, receiver := h.pair(t)
+
+	data := make([]byte, 1500) // twice the burst once encoded
+	sender.send(TypeChunk, ChunkPayload{Index: 0, Data: base64.StdEncoding.EncodeToString(data), Size: len(data)})
+	time.Sleep(100 * time.Millisecond)
+	sender.send(TypePing, nil)
+
+	receiver.expect(TypeChunk, nil)
+	sender.expect(TypePong, nil)
+}
+
+// TestHubSlowReceiver checks that a receiver reading slower than its
+// sender holds the sender back instead of losing chunks.
+func TestHubSlowReceiver(t *testing.T) {
+	h := newHubTest(t, Options{SendQueue: 1})
+	_, sender, receiver := h.pair(t)
+
+	const chunks = 200
+	data := base64.StdEncoding.EncodeToString(make([]byte, 32<<10))
+	sent := make(chan error, 1)
+	go func() {
+		for i := 0; i < chunks; i++ {
+			msg, _ := NewMessage(TypeChunk, ChunkPayload{Index: i, Data: data, Size: 32 << 10})
+			bytes, _ := msg.Bytes()
+			if err := sender.conn.WriteMessage(websocket.TextMessage, bytes); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+
+	// Let the sender run far ahead of the receiver before it reads.
+	time.Sleep(200 * time.Millisecond)
+	for i := 0; i < chunks; i++ {
+		var chunk ChunkPayload
+		receiver.expect(TypeChunk, &chunk)
+		if chunk.Index != i {
+			t.Fatalf("got chunk %d, want %d", chunk.Index, i)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("sender: %v", err)
+	}
+}
+
+func TestHubStalledReceiver(t *testing.T) {
+	// Long enough that a hub held up by the stalled receiver would time
+	// the test out rather than recover.
+	h := newHubTest(t, Options{SendQueue: 1, WriteWait: time.Minute})
+	stalledSess, sender, _ := h.pair(t)
+
+	// Send far more than the socket buffers hold to a receiver that never
+	// reads, until the relay is left waiting for it.
+	data := base64.StdEncoding.EncodeToString(make([]byte, 256<<10))
+	go func() {
+		for i := 0; i < 200; i++ {
+			msg, _ := NewMessage(TypeChunk, ChunkPayload{Index: i, Data: data, Size: 256 << 10})
+			bytes, _ := msg.Bytes()
+			if sender.conn.WriteMessage(websocket.TextMessage, bytes) != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(500 * time.Millisecond)
+
+	// The stalled session's slots can still be checked.
+	p, _, err := h.dial(t, stalledSess.Code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.role = "receiver"
+	p.send(TypeRegister, RegisterPayload{Role: "receiver", Version: ProtocolVersion})
+	p.expectError("SESSION_FULL")
+
+	// Another session pairs, transfers and leaves as usual.
+	_, sender2, receiver2 := h.pair(t)
+	sender2.send(TypeChunk, ChunkPayload{Index: 0, Data: "aGVsbG8=", Size: 5})
+	var chunk ChunkPayload
+	receiver2.expect(TypeChunk, &chunk)
+	if chunk.Data != "aGVsbG8=" {
+		t.Errorf("got chunk %+v", chunk)
+	}
+	sender2.conn.Close()
+	receiver2.expect(TypePeerLeft, nil)
+}
@@ -6,8 +6,20 @@ import { ReceiverView } from './components/receiver/ReceiverView';
 
 type Tab = 'send' | 'receive';
 
+// Receive links, such as the ones in share QR codes, look like
+// /receive/ABC-DEF and open the receive tab with the code filled in.
+const receiveCode = (() => {
+  const match = window.location.pathname.match(/^\/receive\/([^/]+)\/?$/);
+  if (!match) return '';
+  try {
+    return decodeURIComponent(match[1]);
+  } catch {
+    return '';
+  }
+})();
+
 function App() {
-  const [activeTab, setActiveTab] = useState<Tab>('send');
+  const [activeTab, setActiveTab] = useState<Tab>(receiveCode ? 'receive' : 'send');
 
   return (
     <div className="min-h-screen bg-gradient-to-br from-blue-50 to-indigo-100">
@@ -56,7 +68,7 @@ function App() {
       {/* Content */}
       <main className="max-w-4xl mx-auto mt-6 px-4 pb-8">
         <div className="bg-white rounded-xl shadow-lg">
-          {activeTab === 'send' ? <SenderView /> : <ReceiverView />}
+          {activeTab === 'send' ? <SenderView /> : <ReceiverView initialCode={receiveCode} />}
         </div>
       </main>
 
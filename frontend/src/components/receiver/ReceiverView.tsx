@@ -10,8 +10,28 @@ import type { SessionInfo } from '../../types/session';
 
 type ReceiverState = 'idle' | 'validating' | 'ready' | 'waiting' | 'transferring' | 'complete' | 'error';
 
-export function ReceiverView() {
-  const [code, setCode] = useState('');
+// Codes come in several formats: ABC-DEF, word codes such as
+// 7-purple-river-lamp and vanity codes. The server normalizes case and
+// separators, so the input only tidies what is typed.
+const MIN_CODE_CHARS = 4;
+const MAX_CODE_LENGTH = 80;
+
+const formatCode = (value: string) =>
+  value
+    .replace(/[^A-Za-z0-9 _.-]/g, '')
+    .replace(/[ _.-]+/g, '-')
+    .replace(/^-/, '')
+    .slice(0, MAX_CODE_LENGTH);
+
+const isCompleteCode = (code: string) =>
+  code.replace(/[^A-Za-z0-9]/g, '').length >= MIN_CODE_CHARS;
+
+interface ReceiverViewProps {
+  initialCode?: string;
+}
+
+export function ReceiverView({ initialCode = '' }: ReceiverViewProps) {
+  const [code, setCode] = useState(() => formatCode(initialCode));
   const [state, setState] = useState<ReceiverState>('idle');
   const [error, setError] = useState('');
   const [sessionInfo, setSessionInfo] = useState<SessionInfo | null>(null);
@@ -66,6 +86,11 @@ export function ReceiverView() {
         break;
       }
 
+      case 'session_expired':
+        setError('This transfer code has expired');
+        setState('error');
+        break;
+
       case 'error': {
         const err = message.payload as { message: string };
         setError(err.message);
@@ -86,19 +111,12 @@ export function ReceiverView() {
   });
 
   const handleCodeChange = (e: React.ChangeEvent<HTMLInputElement>) => {
-    let value = e.target.value.toUpperCase().replace(/[^A-Z0-9]/g, '');
-    if (value.length > 3 && !value.includes('-')) {
-      value = value.slice(0, 3) + '-' + value.slice(3);
-    }
-    if (value.length > 7) {
-      value = value.slice(0, 7);
-    }
-    setCode(value);
+    setCode(formatCode(e.target.value));
     setError('');
   };
 
   const handleValidate = async () => {
-    if (code.length !== 7) {
+    if (!isCompleteCode(code)) {
       setError('Please enter a complete code');
       return;
     }
@@ -156,8 +174,11 @@ export function ReceiverView() {
               value={code}
               onChange={handleCodeChange}
               placeholder="ABC-DEF"
-              className="w-full text-center text-3xl font-mono tracking-widest p-4 border-2 border-gray-300 rounded-lg focus:border-blue-500 focus:outline-none uppercase"
-              maxLength={7}
+              className="w-full text-center text-3xl font-mono tracking-widest p-4 border-2 border-gray-300 rounded-lg focus:border-blue-500 focus:outline-none"
+              maxLength={MAX_CODE_LENGTH}
+              autoCapitalize="none"
+              autoCorrect="off"
+              spellCheck={false}
             />
           </div>
 
@@ -167,7 +188,7 @@ export function ReceiverView() {
 
           <Button
             onClick={handleValidate}
-            disabled={code.length !== 7}
+            disabled={!isCompleteCode(code)}
             className="w-full"
             size="lg"
           >
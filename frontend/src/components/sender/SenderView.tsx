@@ -54,6 +54,11 @@ export function SenderView() {
         break;
       }
 
+      case 'session_expired':
+        setError('This transfer code has expired');
+        setState('error');
+        break;
+
       case 'error': {
         const err = message.payload as { message: string };
         setError(err.message);
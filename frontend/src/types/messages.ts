@@ -6,36 +6,69 @@ export type MessageType =
   | 'transfer_request'
   | 'transfer_accept'
   | 'file_meta'
+  | 'file_meta_ack'
   | 'chunk'
   | 'chunk_ack'
   | 'transfer_complete'
   | 'error'
   | 'ping'
-  | 'pong';
+  | 'pong'
+  | 'ack'
+  | 'nack'
+  | 'recipient_rejected'
+  | 'session_expired'
+  | 'offer'
+  | 'answer'
+  | 'ice_candidate'
+  | 'transport';
 
 export interface WSMessage<T = unknown> {
   type: MessageType;
+  version?: number;
   payload?: T;
   timestamp: number;
   messageId?: string;
 }
 
+export type Capability = 'webrtc' | 'compression' | 'reliable';
+
 export interface RegisterPayload {
   role: 'sender' | 'receiver';
   sessionId: string;
+  version: number;
+  capabilities?: Capability[];
+  require?: Capability[];
+  lastMessageId?: string;
+  stream?: number;
 }
 
 export interface RegisterAckPayload {
   success: boolean;
   peerConnected: boolean;
+  version: number;
+  capabilities: Capability[];
+  peerCapabilities?: Capability[];
+  stream?: number;
 }
 
 export interface PeerJoinedPayload {
   role: string;
+  capabilities: Capability[];
+  stream?: number;
+}
+
+export interface RecipientRejectedPayload {
+  identity?: string;
+  reason: 'AUTH_REQUIRED' | 'NOT_A_RECIPIENT';
+}
+
+export interface SessionExpiredPayload {
+  expiredAt: number; // Unix milliseconds
 }
 
 export interface PeerLeftPayload {
   role: string;
+  stream?: number;
 }
 
 export interface FileMetaPayload {
@@ -44,6 +77,18 @@ export interface FileMetaPayload {
   mimeType: string;
   totalChunks: number;
   chunkSize: number;
+  compressionOptions?: CompressionAlgorithm[];
+  compression?: CompressionAlgorithm;
+}
+
+export type CompressionAlgorithm = 'none' | 'gzip' | 'zstd';
+
+export interface TransferRequestPayload {
+  acceptCompression?: CompressionAlgorithm[];
+}
+
+export interface FileMetaAckPayload {
+  compression: CompressionAlgorithm;
 }
 
 export interface ChunkPayload {
@@ -63,6 +108,25 @@ export interface TransferCompletePayload {
   duration: number;
 }
 
+export interface SessionDescriptionPayload {
+  sdp: string;
+}
+
+export interface IceCandidatePayload {
+  candidate: string;
+  sdpMid?: string;
+  sdpMLineIndex?: number;
+  usernameFragment?: string;
+}
+
+export interface TransportPayload {
+  mode: 'direct' | 'relay';
+}
+
+export interface AckPayload {
+  messageId: string;
+}
+
 export interface ErrorPayload {
   code: string;
   message: string;
@@ -2,6 +2,8 @@ export interface CreateSessionRequest {
   fileName: string;
   fileSize: number;
   mimeType: string;
+  speedLimit?: number; // bytes per second
+  recipients?: string[]; // emails, @domains or token subjects
 }
 
 export interface CreateSessionResponse {
@@ -16,6 +18,33 @@ export interface SessionInfo {
   fileSize: number;
   mimeType: string;
   status: string;
+  transport: 'direct' | 'relay';
+  compression?: string;
+  speedLimit?: number; // bytes per second
+  createdBy?: { name?: string; email?: string };
+  progress: TransferProgress;
+  summary?: TransferSummary;
+}
+
+export interface TransferProgress {
+  bytesTransferred: number; // file bytes, before compression
+  bytesRelayed: number; // bytes sent, after compression
+  chunksRelayed: number;
+  chunksAcked: number;
+  percent: number;
+  throughput: number; // file bytes per second
+  eta: number; // milliseconds
+  startedAt?: number;
+}
+
+export interface TransferSummary {
+  totalBytes: number; // file bytes, before compression
+  bytesRelayed: number; // bytes sent, after compression
+  totalChunks: number;
+  chunksAcked: number;
+  duration: number; // milliseconds
+  throughput: number; // file bytes per second
+  completedAt: number;
 }
 
 export interface ApiError {